@@ -19,12 +19,15 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 
 	. "github.com/Comcast/eel/eellib"
+	. "github.com/Comcast/eel/jtl"
 	. "github.com/Comcast/eel/util"
 )
 
@@ -47,6 +50,13 @@ func eelCmd(in, tf string, istbe bool) {
 		fmt.Printf("blank transformation\n")
 		os.Exit(1)
 	}
+	if errs := EELVetTransformation(Gctx, tf, istbe); len(errs) > 0 {
+		fmt.Printf("bad transformation\n")
+		for _, e := range errs {
+			fmt.Printf("%s\n", e.Error())
+		}
+		os.Exit(1)
+	}
 	if strings.HasPrefix(in, "@") {
 		buf, err := ioutil.ReadFile(in[1:])
 		if err != nil {
@@ -97,3 +107,45 @@ func eelCmd(in, tf string, istbe bool) {
 		}
 	}
 }
+
+// vetCmd loads and lints all handlers and prints diagnostics for invalid handler configs, optionally as JSON. Exits with 1
+// if there are any.
+func vetCmd(asJson bool) {
+	Gctx = NewDefaultContext(L_NilLevel)
+	if *basePath != "" {
+		BasePath = *basePath
+	}
+	if *configPath != "" {
+		ConfigPath = filepath.Join(BasePath, *configPath)
+	} else {
+		ConfigPath = filepath.Join(BasePath, EelConfigFile)
+	}
+	config := GetConfigFromFile(Gctx)
+	Gctx.AddConfigValue(EelConfig, config)
+	if *handlerPath != "" {
+		HandlerPath = *handlerPath
+	} else if config.HandlerConfigPath != "" {
+		HandlerPath = config.HandlerConfigPath
+	}
+	folder := filepath.Join(BasePath, DefaultConfigFolder)
+	if HandlerPath != "" {
+		folder = filepath.Join(BasePath, HandlerPath)
+	}
+	warnings := VetHandlers(Gctx, []string{folder})
+	if asJson {
+		buf, err := json.MarshalIndent(warnings, "", "\t")
+		if err != nil {
+			fmt.Printf("cannot marshal warnings: %s\n", err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("%s\n", buf)
+	} else {
+		for _, w := range warnings {
+			fmt.Printf("%s\n", w.Error())
+		}
+	}
+	if len(warnings) > 0 {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
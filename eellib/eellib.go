@@ -291,10 +291,55 @@ func EELSimpleTransform(ctx Context, event string, transformation string, isTran
 	if err != nil {
 		return "", []error{err}
 	}
-	tf, err := NewJDocFromString(transformation)
+	h, _, err := newSimpleHandler(ctx, transformation, isTransformationByExample)
+	if err != nil {
+		return "", []error{err}
+	}
+	p, err := h.ProcessEvent(ctx, doc)
 	if err != nil {
 		return "", []error{err}
 	}
+	if len(p) > 1 {
+		return "", []error{errors.New("transformation must yield single result")}
+	} else if len(p) == 0 {
+		return "", nil
+	} else {
+		return p[0].GetPayload(), GetErrors(ctx)
+	}
+}
+
+// EELVetTransformation returns diagnostics for invalid jpath expressions and lint findings in a raw JSON
+// transformation or a transformation wrapped in a config handler.
+func EELVetTransformation(ctx Context, transformation string, isTransformationByExample bool) []error {
+	if Gctx == nil {
+		return []error{errors.New("must call EELInit first")}
+	}
+	if ctx == nil {
+		return []error{errors.New("ctx cannot be nil")}
+	}
+	ctx = ctx.SubContext()
+	h, warnings, err := newSimpleHandler(ctx, transformation, isTransformationByExample)
+	if err != nil {
+		return []error{err}
+	}
+	diagnostics := make([]error, 0)
+	for _, w := range warnings {
+		if _, ok := w.(ParseDiagnostic); ok {
+			diagnostics = append(diagnostics, w)
+		}
+	}
+	for _, d := range h.Lint(ctx) {
+		diagnostics = append(diagnostics, d)
+	}
+	return diagnostics
+}
+
+// newSimpleHandler wraps a raw JSON transformation in a handler config unless it already is a handler config.
+func newSimpleHandler(ctx Context, transformation string, isTransformationByExample bool) (*HandlerConfiguration, []error, error) {
+	tf, err := NewJDocFromString(transformation)
+	if err != nil {
+		return nil, nil, err
+	}
 	h := new(HandlerConfiguration)
 	err = json.Unmarshal([]byte(transformation), h)
 	if err != nil || h.Transformation == nil {
@@ -307,18 +352,8 @@ func EELSimpleTransform(ctx Context, event string, transformation string, isTran
 	if h.Endpoint == nil {
 		h.Endpoint = "http://localhost"
 	}
-	h, _ = GetHandlerConfigurationFromJson(ctx, "", *h)
-	p, err := h.ProcessEvent(ctx, doc)
-	if err != nil {
-		return "", []error{err}
-	}
-	if len(p) > 1 {
-		return "", []error{errors.New("transformation must yield single result")}
-	} else if len(p) == 0 {
-		return "", nil
-	} else {
-		return p[0].GetPayload(), GetErrors(ctx)
-	}
+	h, warnings := GetHandlerConfigurationFromJson(ctx, "", *h)
+	return h, warnings, nil
 }
 
 // EELSingleTransform can work with raw JSON transformation or a transformation wrapped in a config handler.
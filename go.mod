@@ -6,7 +6,12 @@ require (
 	github.com/Knetic/govaluate v3.0.0+incompatible
 	github.com/hashicorp/golang-lru v0.5.4
 	github.com/robertkrimen/otto v0.0.0-20200922221731-ef014fd054ac
+	github.com/segmentio/kafka-go v0.4.47
 	go.opentelemetry.io/otel v1.4.1
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.4.1
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.4.1
+	go.opentelemetry.io/otel/sdk v1.4.1
+	go.opentelemetry.io/otel/trace v1.4.1
 	golang.org/x/oauth2 v0.0.0-20210514164344-f6687ab2804c
 	gopkg.in/sourcemap.v1 v1.0.5 // indirect
 )
@@ -0,0 +1,580 @@
+/**
+ * Copyright 2015 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jtl
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	. "github.com/Comcast/eel/util"
+)
+
+// maxRangeLength is the maximum number of elements created by range()
+const maxRangeLength = 100000
+
+func init() {
+	// first element of array or null: first('<array>')
+	RegisterTypedFunction("first", fnFirst, 1, 1, ResultAny)
+	// last element of array or null: last('<array>')
+	RegisterTypedFunction("last", fnLast, 1, 1, ResultAny)
+	// sub array with python semantics: slice('<array>', '<start>', ['<end>']), negative indices count from the end
+	RegisterTypedFunction("slice", fnSlice, 2, 3, ResultArray)
+	// sort array numerically or by string value: sort('<array>', ['desc'])
+	RegisterTypedFunction("sort", fnSort, 1, 2, ResultArray)
+	// sort array of objects by value at path: sortby('<array>', '<path>', ['desc'])
+	RegisterTypedFunction("sortby", fnSortBy, 2, 3, ResultArray)
+	// remove duplicate elements from array keeping the first occurrence: unique('<array>')
+	RegisterTypedFunction("unique", fnUnique, 1, 1, ResultArray)
+	// reverse order of elements of array: reverse('<array>')
+	RegisterTypedFunction("reverse", fnReverse, 1, 1, ResultArray)
+	// flatten nested arrays: flatten('<array>', ['<depth>']), depth defaults to 1
+	RegisterTypedFunction("flatten", fnFlatten, 1, 2, ResultArray)
+	// combine arrays into array of tuples: zip('<array>', '<array>', ...)
+	RegisterTypedFunction("zip", fnZip, 1, 100, ResultArray)
+	// array of integers: range('<end>') or range('<start>', '<end>', ['<step>']), end is exclusive
+	RegisterTypedFunction("range", fnRange, 1, 3, ResultArray)
+	// sum of numeric elements of array: sum('<array>')
+	RegisterTypedFunction("sum", fnSum, 1, 1, ResultNumber)
+	// smallest numeric element of array: min('<array>')
+	RegisterTypedFunction("min", fnMin, 1, 1, ResultNumber)
+	// largest numeric element of array: max('<array>')
+	RegisterTypedFunction("max", fnMax, 1, 1, ResultNumber)
+	// average of numeric elements of array: avg('<array>')
+	RegisterTypedFunction("avg", fnAvg, 1, 1, ResultNumber)
+	// number of elements of array matching predicate: count('<array>', ['<predicate>'])
+	RegisterTypedFunction("count", fnCount, 1, 2, ResultNumber)
+	// apply named transformation or expression to each element: map('<array>', '<transformation>')
+	RegisterTypedFunction("map", fnMap, 2, 2, ResultArray)
+	// elements for which predicate, named transformation or expression is true: filter('<array>', '<predicate>')
+	RegisterTypedFunction("filter", fnFilter, 2, 2, ResultArray)
+}
+
+// checkParamCount reports an error and returns false if the number of parameters is not between min and max.
+func checkParamCount(ctx Context, op string, params []string, min int, max int) bool {
+	if params != nil && len(params) >= min && len(params) <= max {
+		return true
+	}
+	stats := ctx.Value(EelTotalStats).(*ServiceStats)
+	ctx.Log().Error("error_type", "func_"+op, "op", op, "cause", "wrong_number_of_parameters", "params", params)
+	stats.IncErrors()
+	AddError(ctx, SyntaxError{fmt.Sprintf("wrong number of parameters in call to %s function", op), op, params})
+	return false
+}
+
+// arrayParam parses a json array parameter, reporting an error if the parameter is not an array.
+func arrayParam(ctx Context, op string, params []string, param string) ([]interface{}, bool) {
+	var a []interface{}
+	err := json.Unmarshal([]byte(extractStringParam(param)), &a)
+	if err != nil || a == nil {
+		stats := ctx.Value(EelTotalStats).(*ServiceStats)
+		ctx.Log().Error("error_type", "func_"+op, "op", op, "cause", "param_not_array", "params", params)
+		stats.IncErrors()
+		AddError(ctx, SyntaxError{fmt.Sprintf("non array parameters in call to %s function", op), op, params})
+		return nil, false
+	}
+	return a, true
+}
+
+// intParam parses an integer parameter, reporting an error if the parameter is not an integer.
+func intParam(ctx Context, op string, params []string, param string) (int, bool) {
+	i, err := strconv.Atoi(strings.TrimSpace(extractStringParam(param)))
+	if err != nil {
+		stats := ctx.Value(EelTotalStats).(*ServiceStats)
+		ctx.Log().Error("error_type", "func_"+op, "op", op, "cause", "param_not_int", "params", params, "error", err.Error())
+		stats.IncErrors()
+		AddError(ctx, SyntaxError{fmt.Sprintf("non int parameters in call to %s function", op), op, params})
+		return 0, false
+	}
+	return i, true
+}
+
+// fnFirst returns the first element of an array.
+func fnFirst(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "first", params, 1, 1) {
+		return nil
+	}
+	a, ok := arrayParam(ctx, "first", params, params[0])
+	if !ok || len(a) == 0 {
+		return nil
+	}
+	return a[0]
+}
+
+// fnLast returns the last element of an array.
+func fnLast(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "last", params, 1, 1) {
+		return nil
+	}
+	a, ok := arrayParam(ctx, "last", params, params[0])
+	if !ok || len(a) == 0 {
+		return nil
+	}
+	return a[len(a)-1]
+}
+
+// fnSlice returns the elements from start up to but not including end. Like array path selectors, negative indices
+// count from the end of the array and out of range indices are clipped.
+func fnSlice(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "slice", params, 2, 3) {
+		return nil
+	}
+	a, ok := arrayParam(ctx, "slice", params, params[0])
+	if !ok {
+		return nil
+	}
+	s := &arraySelector{typ: selectorSlice, step: 1}
+	start, ok := intParam(ctx, "slice", params, params[1])
+	if !ok {
+		return nil
+	}
+	s.start = &start
+	if len(params) == 3 && strings.TrimSpace(extractStringParam(params[2])) != "" {
+		end, ok := intParam(ctx, "slice", params, params[2])
+		if !ok {
+			return nil
+		}
+		s.end = &end
+	}
+	return s.selectElements(ctx, a, "")
+}
+
+// descendingParam returns true if the optional sort order parameter is desc.
+func descendingParam(ctx Context, op string, params []string, param string) (bool, bool) {
+	switch strings.ToLower(strings.TrimSpace(extractStringParam(param))) {
+	case "", "asc":
+		return false, true
+	case "desc":
+		return true, true
+	}
+	stats := ctx.Value(EelTotalStats).(*ServiceStats)
+	ctx.Log().Error("error_type", "func_"+op, "op", op, "cause", "invalid_sort_order", "params", params)
+	stats.IncErrors()
+	AddError(ctx, SyntaxError{fmt.Sprintf("sort order must be asc or desc in call to %s function", op), op, params})
+	return false, false
+}
+
+// sortElements sorts a stable by the keys of its elements, numerically if both keys are numbers and by string value
+// otherwise.
+func sortElements(a []interface{}, keys []interface{}, desc bool) []interface{} {
+	idx := make([]int, len(a))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		c := compareOperands(keys[idx[i]], keys[idx[j]])
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	})
+	res := make([]interface{}, 0, len(a))
+	for _, i := range idx {
+		res = append(res, a[i])
+	}
+	return res
+}
+
+// fnSort sorts an array of numbers or strings.
+func fnSort(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "sort", params, 1, 2) {
+		return nil
+	}
+	a, ok := arrayParam(ctx, "sort", params, params[0])
+	if !ok {
+		return nil
+	}
+	desc := false
+	if len(params) == 2 {
+		if desc, ok = descendingParam(ctx, "sort", params, params[1]); !ok {
+			return nil
+		}
+	}
+	return sortElements(a, a, desc)
+}
+
+// fnSortBy sorts an array of objects by the value found at path in each element.
+func fnSortBy(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "sortby", params, 2, 3) {
+		return nil
+	}
+	a, ok := arrayParam(ctx, "sortby", params, params[0])
+	if !ok {
+		return nil
+	}
+	desc := false
+	if len(params) == 3 {
+		if desc, ok = descendingParam(ctx, "sortby", params, params[2]); !ok {
+			return nil
+		}
+	}
+	path := strings.TrimSpace(extractStringParam(params[1]))
+	keys := make([]interface{}, 0, len(a))
+	for _, e := range a {
+		ed, _ := NewJDocFromInterface(e)
+		keys = append(keys, ed.EvalPath(ctx, path))
+	}
+	return sortElements(a, keys, desc)
+}
+
+// fnUnique removes duplicate elements from an array, keeping the first occurrence.
+func fnUnique(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "unique", params, 1, 1) {
+		return nil
+	}
+	a, ok := arrayParam(ctx, "unique", params, params[0])
+	if !ok {
+		return nil
+	}
+	seen := make(map[string]bool, len(a))
+	res := make([]interface{}, 0, len(a))
+	for _, e := range a {
+		// json encoding of maps is sorted by key so equal objects have equal keys
+		buf, _ := json.Marshal(e)
+		if !seen[string(buf)] {
+			seen[string(buf)] = true
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// fnReverse reverses the order of the elements of an array.
+func fnReverse(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "reverse", params, 1, 1) {
+		return nil
+	}
+	a, ok := arrayParam(ctx, "reverse", params, params[0])
+	if !ok {
+		return nil
+	}
+	res := make([]interface{}, 0, len(a))
+	for i := len(a) - 1; i >= 0; i-- {
+		res = append(res, a[i])
+	}
+	return res
+}
+
+// fnFlatten replaces nested arrays with their elements, up to depth levels deep.
+func fnFlatten(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "flatten", params, 1, 2) {
+		return nil
+	}
+	a, ok := arrayParam(ctx, "flatten", params, params[0])
+	if !ok {
+		return nil
+	}
+	depth := 1
+	if len(params) == 2 {
+		if depth, ok = intParam(ctx, "flatten", params, params[1]); !ok {
+			return nil
+		}
+	}
+	return flatten(a, depth, make([]interface{}, 0, len(a)))
+}
+
+func flatten(a []interface{}, depth int, res []interface{}) []interface{} {
+	for _, e := range a {
+		if nested, ok := e.([]interface{}); ok && depth > 0 {
+			res = flatten(nested, depth-1, res)
+		} else {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// fnZip combines arrays into an array of tuples. The result is as long as the shortest array.
+func fnZip(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "zip", params, 1, 100) {
+		return nil
+	}
+	arrays := make([][]interface{}, 0, len(params))
+	n := -1
+	for _, p := range params {
+		a, ok := arrayParam(ctx, "zip", params, p)
+		if !ok {
+			return nil
+		}
+		if n < 0 || len(a) < n {
+			n = len(a)
+		}
+		arrays = append(arrays, a)
+	}
+	res := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		tuple := make([]interface{}, 0, len(arrays))
+		for _, a := range arrays {
+			tuple = append(tuple, a[i])
+		}
+		res = append(res, tuple)
+	}
+	return res
+}
+
+// fnRange returns an array of integers from start (inclusive, default 0) to end (exclusive).
+func fnRange(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "range", params, 1, 3) {
+		return nil
+	}
+	bounds := make([]int, 0, 3)
+	for _, p := range params {
+		i, ok := intParam(ctx, "range", params, p)
+		if !ok {
+			return nil
+		}
+		bounds = append(bounds, i)
+	}
+	start, end, step := 0, bounds[0], 1
+	if len(bounds) >= 2 {
+		start, end = bounds[0], bounds[1]
+	}
+	if len(bounds) == 3 {
+		step = bounds[2]
+	}
+	stats := ctx.Value(EelTotalStats).(*ServiceStats)
+	if step == 0 {
+		ctx.Log().Error("error_type", "func_range", "op", "range", "cause", "zero_step", "params", params)
+		stats.IncErrors()
+		AddError(ctx, SyntaxError{fmt.Sprintf("step cannot be zero in call to range function"), "range", params})
+		return nil
+	}
+	// count elements in float64, end-start overflows for bounds near the limits of int
+	n := math.Ceil((float64(end) - float64(start)) / float64(step))
+	if n > maxRangeLength {
+		ctx.Log().Error("error_type", "func_range", "op", "range", "cause", "range_too_long", "params", params)
+		stats.IncErrors()
+		AddError(ctx, RuntimeError{fmt.Sprintf("more than %d elements in call to range function", maxRangeLength), "range", params})
+		return nil
+	}
+	res := make([]interface{}, 0)
+	for i := start; (step > 0 && i < end) || (step < 0 && i > end); i += step {
+		res = append(res, i)
+		if next := i + step; (next > i) != (step > 0) {
+			break // overflow
+		}
+	}
+	return res
+}
+
+// numberElements converts the elements of an array to numbers, reporting an error for non numeric elements. The
+// second return value is true if all elements are integers.
+func numberElements(ctx Context, op string, params []string) ([]float64, bool, bool) {
+	if !checkParamCount(ctx, op, params, 1, 1) {
+		return nil, false, false
+	}
+	a, ok := arrayParam(ctx, op, params, params[0])
+	if !ok {
+		return nil, false, false
+	}
+	nums := make([]float64, 0, len(a))
+	ints := true
+	for _, e := range a {
+		f, ok := toNumberOperand(e)
+		if !ok {
+			stats := ctx.Value(EelTotalStats).(*ServiceStats)
+			ctx.Log().Error("error_type", "func_"+op, "op", op, "cause", "non_numeric_element", "params", params)
+			stats.IncErrors()
+			AddError(ctx, RuntimeError{fmt.Sprintf("non numeric element %s in call to %s function", ToFlatString(e), op), op, params})
+			return nil, false, false
+		}
+		ints = ints && isInt(e)
+		nums = append(nums, f)
+	}
+	return nums, ints, true
+}
+
+// numberResult returns r as int if the operands were integers and the result is whole, as float otherwise.
+func numberResult(r float64, ints bool) interface{} {
+	if ints && r == math.Trunc(r) {
+		return int(r)
+	}
+	return r
+}
+
+// fnSum returns the sum of the numeric elements of an array, 0 for an empty array.
+func fnSum(ctx Context, doc *JDoc, params []string) interface{} {
+	nums, ints, ok := numberElements(ctx, "sum", params)
+	if !ok {
+		return nil
+	}
+	sum := 0.0
+	for _, f := range nums {
+		sum += f
+	}
+	return numberResult(sum, ints)
+}
+
+// fnMin returns the smallest numeric element of an array, null for an empty array.
+func fnMin(ctx Context, doc *JDoc, params []string) interface{} {
+	nums, ints, ok := numberElements(ctx, "min", params)
+	if !ok || len(nums) == 0 {
+		return nil
+	}
+	min := nums[0]
+	for _, f := range nums[1:] {
+		if f < min {
+			min = f
+		}
+	}
+	return numberResult(min, ints)
+}
+
+// fnMax returns the largest numeric element of an array, null for an empty array.
+func fnMax(ctx Context, doc *JDoc, params []string) interface{} {
+	nums, ints, ok := numberElements(ctx, "max", params)
+	if !ok || len(nums) == 0 {
+		return nil
+	}
+	max := nums[0]
+	for _, f := range nums[1:] {
+		if f > max {
+			max = f
+		}
+	}
+	return numberResult(max, ints)
+}
+
+// fnAvg returns the average of the numeric elements of an array, null for an empty array.
+func fnAvg(ctx Context, doc *JDoc, params []string) interface{} {
+	nums, ints, ok := numberElements(ctx, "avg", params)
+	if !ok || len(nums) == 0 {
+		return nil
+	}
+	sum := 0.0
+	for _, f := range nums {
+		sum += f
+	}
+	return numberResult(sum/float64(len(nums)), ints)
+}
+
+// elementFunction returns a function evaluating spec for a single array element. The spec may be the name of a
+// transformation of the current handler, an escaped jpath expression such as ${{/price$}}, a path such as /price or,
+// if predicates are allowed, a predicate as used in array path selectors such as price>10 and type=book.
+func elementFunction(ctx Context, op string, params []string, spec string, predicates bool) func(e interface{}) interface{} {
+	stats := ctx.Value(EelTotalStats).(*ServiceStats)
+	spec = strings.TrimSpace(spec)
+	if h := GetCurrentHandlerConfig(ctx); h != nil && h.Transformations != nil && h.Transformations[spec] != nil {
+		t := h.Transformations[spec]
+		return func(e interface{}) interface{} {
+			ed, _ := NewJDocFromInterface(e)
+			if t.IsTransformationByExample {
+				return ed.ApplyTransformationByExample(ctx, t.t).GetOriginalObject()
+			}
+			return ed.ApplyTransformation(ctx, t.t).GetOriginalObject()
+		}
+	}
+	if strings.Contains(spec, "{{") {
+		return func(e interface{}) interface{} {
+			ed, _ := NewJDocFromInterface(e)
+			return ed.ParseExpression(ctx, spec)
+		}
+	}
+	if strings.HasPrefix(spec, "/") {
+		return func(e interface{}) interface{} {
+			ed, _ := NewJDocFromInterface(e)
+			return ed.EvalPath(ctx, spec)
+		}
+	}
+	if predicates {
+		s, err := parseArraySelector(spec)
+		if err == nil && s.typ == selectorPredicate {
+			return func(e interface{}) interface{} {
+				return s.matches(ctx, e, 0, op)
+			}
+		}
+		if err != nil {
+			ctx.Log().Error("error_type", "func_"+op, "op", op, "cause", "invalid_predicate", "params", params, "error", err.Error())
+			stats.IncErrors()
+			AddError(ctx, SyntaxError{fmt.Sprintf("invalid predicate %s in call to %s function: %s", spec, op, err.Error()), op, params})
+			return nil
+		}
+	}
+	ctx.Log().Error("error_type", "func_"+op, "op", op, "cause", "unknown_transformation", "params", params)
+	stats.IncErrors()
+	AddError(ctx, RuntimeError{fmt.Sprintf("no named transformation %s found in call to %s function", spec, op), op, params})
+	return nil
+}
+
+// filterElements returns the elements of array for which spec evaluates to true.
+func filterElements(ctx Context, op string, params []string) ([]interface{}, bool) {
+	a, ok := arrayParam(ctx, op, params, params[0])
+	if !ok {
+		return nil, false
+	}
+	if len(params) == 1 {
+		return a, true
+	}
+	f := elementFunction(ctx, op, params, extractStringParam(params[1]), true)
+	if f == nil {
+		return nil, false
+	}
+	res := make([]interface{}, 0, len(a))
+	for _, e := range a {
+		if b, ok := toBoolOperand(f(e)); ok && b {
+			res = append(res, e)
+		}
+	}
+	return res, true
+}
+
+// fnCount returns the number of elements of an array, optionally only counting elements matching a predicate.
+func fnCount(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "count", params, 1, 2) {
+		return nil
+	}
+	res, ok := filterElements(ctx, "count", params)
+	if !ok {
+		return nil
+	}
+	return len(res)
+}
+
+// fnFilter returns the elements of an array matching a predicate, named transformation or expression.
+func fnFilter(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "filter", params, 2, 2) {
+		return nil
+	}
+	res, ok := filterElements(ctx, "filter", params)
+	if !ok {
+		return nil
+	}
+	return res
+}
+
+// fnMap applies a named transformation or expression to each element of an array.
+func fnMap(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "map", params, 2, 2) {
+		return nil
+	}
+	a, ok := arrayParam(ctx, "map", params, params[0])
+	if !ok {
+		return nil
+	}
+	f := elementFunction(ctx, "map", params, extractStringParam(params[1]), false)
+	if f == nil {
+		return nil
+	}
+	res := make([]interface{}, 0, len(a))
+	for _, e := range a {
+		res = append(res, f(e))
+	}
+	return res
+}
@@ -0,0 +1,199 @@
+/**
+ * Copyright 2015 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jtl
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	. "github.com/Comcast/eel/util"
+)
+
+// prefixes of secret references which may be used instead of keys
+const (
+	secretEnvPrefix  = "env:"
+	secretFilePrefix = "file:"
+)
+
+var (
+	// hash functions supported by hmac() and digest functions
+	hashFunctions = map[string]func() hash.Hash{
+		"MD5":    md5.New,
+		"SHA1":   sha1.New,
+		"SHA256": sha256.New,
+		"SHA512": sha512.New,
+	}
+	// well known name spaces for uuidv5() as defined in RFC 4122
+	uuidNamespaces = map[string]string{
+		"dns":  "6ba7b810-9dad-11d1-80b4-00c04fd430c8",
+		"url":  "6ba7b811-9dad-11d1-80b4-00c04fd430c8",
+		"oid":  "6ba7b812-9dad-11d1-80b4-00c04fd430c8",
+		"x500": "6ba7b814-9dad-11d1-80b4-00c04fd430c8",
+	}
+)
+
+func init() {
+	// sha256 digest of input: sha256('<input>', ['hex'|'base64'|'base64url']), hex by default
+	RegisterTypedFunction("sha256", fnSha256, 1, 2, ResultString)
+	// sha512 digest of input: sha512('<input>', ['hex'|'base64'|'base64url']), hex by default
+	RegisterTypedFunction("sha512", fnSha512, 1, 2, ResultString)
+	// hex encoded md5 digest of input, not suitable for security purposes: md5hex('<input>')
+	RegisterTypedFunction("md5hex", fnMd5Hex, 1, 1, ResultString)
+	// name based uuid: uuidv5('<namespace uuid or dns, url, oid, x500>', '<name>')
+	RegisterTypedFunction("uuidv5", fnUuidV5, 2, 2, ResultString)
+}
+
+// isSecretReference returns true if s refers to a secret in an environment variable (env:NAME) or file (file:/path).
+func isSecretReference(s string) bool {
+	return strings.HasPrefix(s, secretEnvPrefix) || strings.HasPrefix(s, secretFilePrefix)
+}
+
+// resolveSecret returns the value of a secret reference, a trailing new line of a secret file is removed. Values which
+// are not secret references, for example keys obtained with prop(), are returned unchanged.
+func resolveSecret(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, secretEnvPrefix):
+		name := strings.TrimPrefix(s, secretEnvPrefix)
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s not set", name)
+		}
+		return v, nil
+	case strings.HasPrefix(s, secretFilePrefix):
+		buf, err := ioutil.ReadFile(strings.TrimPrefix(s, secretFilePrefix))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(strings.TrimSuffix(string(buf), "\n"), "\r"), nil
+	}
+	return s, nil
+}
+
+// secretParam resolves the key parameter at index idx which is either a secret reference or a key obtained with prop().
+// Secret references are only resolved if they are given literally in the expression, a reference obtained from the
+// event, for example '{{/key}}' with an event value of file:/etc/passwd, is rejected.
+func secretParam(ctx Context, op string, params []string, idx int) (string, bool) {
+	param := extractStringParam(params[idx])
+	if isSecretReference(param) {
+		literal, _ := ctx.Value(EelLiteralSecrets).([]bool)
+		if idx >= len(literal) || !literal[idx] {
+			stats := ctx.Value(EelTotalStats).(*ServiceStats)
+			ctx.Log().Error("error_type", "func_"+op, "op", op, "cause", "secret_not_literal")
+			stats.IncErrors()
+			AddError(ctx, RuntimeError{fmt.Sprintf("secret reference in call to %s function must be a literal parameter", op), op, nil})
+			return "", false
+		}
+	}
+	key, err := resolveSecret(param)
+	if err != nil {
+		stats := ctx.Value(EelTotalStats).(*ServiceStats)
+		// do not log params, they may contain the key
+		ctx.Log().Error("error_type", "func_"+op, "op", op, "cause", "secret_not_found", "error", err.Error())
+		stats.IncErrors()
+		AddError(ctx, RuntimeError{fmt.Sprintf("secret not found in call to %s function: %s", op, err.Error()), op, nil})
+		return "", false
+	}
+	return key, true
+}
+
+// encodeDigest encodes a digest as hex, base64 or base64url (without padding).
+func encodeDigest(ctx Context, op string, params []string, sum []byte, encoding string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "hex":
+		return hex.EncodeToString(sum), true
+	case "base64":
+		return base64.StdEncoding.EncodeToString(sum), true
+	case "base64url":
+		return base64.RawURLEncoding.EncodeToString(sum), true
+	}
+	stats := ctx.Value(EelTotalStats).(*ServiceStats)
+	ctx.Log().Error("error_type", "func_"+op, "op", op, "cause", "unknown_encoding", "encoding", encoding)
+	stats.IncErrors()
+	AddError(ctx, SyntaxError{fmt.Sprintf("unknown encoding %s in call to %s function, expected hex, base64 or base64url", encoding, op), op, params})
+	return "", false
+}
+
+// digest returns the digest of the input parameter, hex encoded by default.
+func digest(ctx Context, op string, params []string, h hash.Hash) interface{} {
+	if !checkParamCount(ctx, op, params, 1, 2) {
+		return nil
+	}
+	h.Write([]byte(extractStringParam(params[0])))
+	encoding := "hex"
+	if len(params) == 2 {
+		encoding = extractStringParam(params[1])
+	}
+	res, ok := encodeDigest(ctx, op, params, h.Sum(nil), encoding)
+	if !ok {
+		return nil
+	}
+	return res
+}
+
+// fnSha256 returns the sha256 digest of the input.
+func fnSha256(ctx Context, doc *JDoc, params []string) interface{} {
+	return digest(ctx, "sha256", params, sha256.New())
+}
+
+// fnSha512 returns the sha512 digest of the input.
+func fnSha512(ctx Context, doc *JDoc, params []string) interface{} {
+	return digest(ctx, "sha512", params, sha512.New())
+}
+
+// fnMd5Hex returns the hex encoded md5 digest of the input.
+func fnMd5Hex(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "md5hex", params, 1, 1) {
+		return nil
+	}
+	return digest(ctx, "md5hex", params, md5.New())
+}
+
+// fnUuidV5 returns the name based uuid (version 5, sha1) of name in a namespace. The namespace is a uuid or one of the
+// well known namespaces dns, url, oid and x500.
+func fnUuidV5(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "uuidv5", params, 2, 2) {
+		return nil
+	}
+	ns := strings.TrimSpace(extractStringParam(params[0]))
+	if u, ok := uuidNamespaces[strings.ToLower(ns)]; ok {
+		ns = u
+	}
+	nsBytes, err := hex.DecodeString(strings.Replace(ns, "-", "", -1))
+	if err != nil || len(nsBytes) != 16 {
+		stats := ctx.Value(EelTotalStats).(*ServiceStats)
+		ctx.Log().Error("error_type", "func_uuidv5", "op", "uuidv5", "cause", "invalid_namespace", "params", params)
+		stats.IncErrors()
+		AddError(ctx, SyntaxError{fmt.Sprintf("invalid namespace %s in call to uuidv5 function", ns), "uuidv5", params})
+		return nil
+	}
+	h := sha1.New()
+	h.Write(nsBytes)
+	h.Write([]byte(extractStringParam(params[1])))
+	u := h.Sum(nil)[:16]
+	u[6] = (u[6] & 0x0f) | 0x50 // version 5
+	u[8] = (u[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
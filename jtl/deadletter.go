@@ -0,0 +1,456 @@
+/**
+ * Copyright 2015 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jtl
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/Comcast/eel/util"
+)
+
+type (
+	// DeadLetter is the state of a publisher that failed to deliver an event after all retries.
+	DeadLetter struct {
+		Id               string
+		Time             string
+		TenantId         string
+		Handler          string
+		TraceId          string
+		Protocol         string
+		Endpoint         string
+		Path             string
+		Url              string
+		Verb             string
+		Headers          map[string]string
+		AuthInfo         map[string]string // kept for replay, masked in api responses
+		Payload          string
+		PublisherConfigs map[string]string
+		Error            string
+		Replays          int // number of failed replay attempts
+	}
+	// DeadLetterStore stores events that could not be published so they can be inspected and replayed later.
+	DeadLetterStore interface {
+		Add(ctx Context, dl *DeadLetter) error
+		Get(id string) *DeadLetter
+		// List returns all dead letters for tenant and handler in the order they were added, blank tenant or handler match any.
+		List(tenantId string, handler string) []*DeadLetter
+		Delete(ctx Context, id string) error
+	}
+	// LocalFileDeadLetterStore keeps dead letters in memory backed by an append-only file of JSON lines.
+	LocalFileDeadLetterStore struct {
+		fileName string
+		file     *os.File
+		letters  map[string]*DeadLetter
+		order    []string
+		sync.RWMutex
+	}
+	deadLetterRecord struct {
+		Op         string
+		Id         string      `json:",omitempty"`
+		DeadLetter *DeadLetter `json:",omitempty"`
+	}
+)
+
+const (
+	deadLetterOpAdd    = "add"
+	deadLetterOpDelete = "delete"
+)
+
+// NewLocalFileDeadLetterStore opens (or creates) a dead letter store file. Existing entries are loaded and the file is
+// compacted so that deleted entries do not accumulate across restarts.
+func NewLocalFileDeadLetterStore(ctx Context, fileName string) (DeadLetterStore, error) {
+	s := new(LocalFileDeadLetterStore)
+	s.fileName = fileName
+	s.letters = make(map[string]*DeadLetter, 0)
+	s.order = make([]string, 0)
+	err := s.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = s.compact()
+	if err != nil {
+		return nil, err
+	}
+	ctx.Log().Info("action", "open_dead_letter_store", "file", fileName, "count", len(s.order))
+	return s, nil
+}
+
+// InitDeadLetterStore opens the dead letter store configured in config.json (if any) and adds it to the context.
+func InitDeadLetterStore(ctx Context) {
+	fileName := GetConfig(ctx).DeadLetterFile
+	if fileName == "" {
+		return
+	}
+	if !filepath.IsAbs(fileName) {
+		fileName = filepath.Join(BasePath, fileName)
+	}
+	dls, err := NewLocalFileDeadLetterStore(ctx, fileName)
+	if err != nil {
+		ctx.Log().Error("error_type", "dead_letter_store", "cause", "open_failed", "file", fileName, "error", err.Error())
+		return
+	}
+	ctx.AddValue(EelDeadLetterStore, dls)
+}
+
+// GetDeadLetterStore returns the dead letter store from context or nil if dead letters are not enabled.
+func GetDeadLetterStore(ctx Context) DeadLetterStore {
+	if ctx.Value(EelDeadLetterStore) != nil {
+		return ctx.Value(EelDeadLetterStore).(DeadLetterStore)
+	}
+	return nil
+}
+
+// NewDeadLetter captures the state of a failed publisher.
+func NewDeadLetter(ctx Context, handler *HandlerConfiguration, p EventPublisher, err error) *DeadLetter {
+	dl := new(DeadLetter)
+	dl.Id, _ = NewUUID()
+	dl.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	if handler != nil {
+		dl.TenantId = handler.TenantId
+		dl.Handler = handler.Name
+	}
+	if ctx.Value("tx.traceId") != nil {
+		dl.TraceId = ctx.Value("tx.traceId").(string)
+	}
+	dl.Protocol = p.GetProtocol()
+	dl.Endpoint = p.GetEndpoint()
+	dl.Path = p.GetPath()
+	dl.Url = p.GetUrl()
+	dl.Verb = p.GetVerb()
+	dl.Headers = copyStringMap(p.GetHeaders())
+	if ap, ok := p.(AuthInfoPublisher); ok {
+		dl.AuthInfo = copyStringMap(ap.GetAuthInfo())
+	}
+	dl.Payload = p.GetPayload()
+	dl.PublisherConfigs = copyStringMap(p.GetPublisherConfigs())
+	if err != nil {
+		dl.Error = err.Error()
+	}
+	return dl
+}
+
+// copyStringMap returns a copy of m so that dead letters do not share state with the publisher they were created from.
+func copyStringMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// masked returns a copy of the dead letter with all AuthInfo values except the type replaced so that credentials are
+// not exposed by the api.
+func (dl *DeadLetter) masked() *DeadLetter {
+	c := *dl
+	if dl.AuthInfo != nil {
+		c.AuthInfo = make(map[string]string, len(dl.AuthInfo))
+		for k, v := range dl.AuthInfo {
+			if k != "type" {
+				v = "***"
+			}
+			c.AuthInfo[k] = v
+		}
+	}
+	return &c
+}
+
+// ReplayDeadLetter attempts to publish a dead letter again using a new publisher for its protocol.
+func ReplayDeadLetter(ctx Context, dl *DeadLetter) error {
+	if dl.TenantId != "" {
+		ctx.AddValue(EelTenantId, dl.TenantId)
+	}
+	if dl.TraceId != "" {
+		ctx.AddLogValue("tx.traceId", dl.TraceId)
+		ctx.AddValue("tx.traceId", dl.TraceId)
+	}
+	p := NewEventPublisher(ctx, dl.Protocol)
+	if p == nil {
+		return errors.New("unsupported protocol " + dl.Protocol)
+	}
+	if dl.AuthInfo != nil {
+		p.SetAuthInfo(dl.AuthInfo)
+	}
+	p.SetHeaders(dl.Headers)
+	p.SetPayload(dl.Payload)
+	p.SetPath(dl.Path)
+	err := p.SetPublisherConfigs(dl.PublisherConfigs)
+	if err != nil {
+		return err
+	}
+	p.SetEndpoint(dl.Endpoint)
+	if dl.Verb != "" {
+		p.SetVerb(dl.Verb)
+	}
+	if doc, err := NewJDocFromString(dl.Payload); err == nil {
+		p.SetPayloadParsed(doc)
+	}
+	_, err = p.Publish()
+	return err
+}
+
+func (s *LocalFileDeadLetterStore) load(ctx Context) error {
+	file, err := os.Open(s.fileName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var rec deadLetterRecord
+			if jerr := json.Unmarshal(line, &rec); jerr != nil {
+				// most likely a partially written last line after a crash
+				ctx.Log().Error("error_type", "dead_letter_store", "cause", "invalid_record", "file", s.fileName, "error", jerr.Error())
+			} else {
+				s.apply(&rec)
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *LocalFileDeadLetterStore) apply(rec *deadLetterRecord) {
+	switch rec.Op {
+	case deadLetterOpAdd:
+		if rec.DeadLetter == nil {
+			return
+		}
+		if _, ok := s.letters[rec.DeadLetter.Id]; !ok {
+			s.order = append(s.order, rec.DeadLetter.Id)
+		}
+		s.letters[rec.DeadLetter.Id] = rec.DeadLetter
+	case deadLetterOpDelete:
+		if _, ok := s.letters[rec.Id]; !ok {
+			return
+		}
+		delete(s.letters, rec.Id)
+		for i, id := range s.order {
+			if id == rec.Id {
+				s.order = append(s.order[:i], s.order[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// compact rewrites the store file with the current entries only and reopens it for appending. The file is only
+// readable by the owner since dead letters contain the AuthInfo of their publishers.
+func (s *LocalFileDeadLetterStore) compact() error {
+	tmp := s.fileName + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	for _, id := range s.order {
+		buf, err := json.Marshal(&deadLetterRecord{Op: deadLetterOpAdd, DeadLetter: s.letters[id]})
+		if err != nil {
+			file.Close()
+			return err
+		}
+		w.Write(buf)
+		w.WriteString("\n")
+	}
+	if err = w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	file.Close()
+	if err = os.Rename(tmp, s.fileName); err != nil {
+		return err
+	}
+	s.file, err = os.OpenFile(s.fileName, os.O_APPEND|os.O_WRONLY, 0600)
+	return err
+}
+
+func (s *LocalFileDeadLetterStore) write(rec *deadLetterRecord) error {
+	buf, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = s.file.Write(append(buf, '\n'))
+	if err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+// Add adds a dead letter or replaces the dead letter with the same id.
+func (s *LocalFileDeadLetterStore) Add(ctx Context, dl *DeadLetter) error {
+	s.Lock()
+	defer s.Unlock()
+	rec := &deadLetterRecord{Op: deadLetterOpAdd, DeadLetter: dl}
+	err := s.write(rec)
+	if err != nil {
+		ctx.Log().Error("error_type", "dead_letter_store", "cause", "write_failed", "file", s.fileName, "error", err.Error())
+		return err
+	}
+	s.apply(rec)
+	return nil
+}
+
+func (s *LocalFileDeadLetterStore) Get(id string) *DeadLetter {
+	s.RLock()
+	defer s.RUnlock()
+	return s.letters[id]
+}
+
+func (s *LocalFileDeadLetterStore) List(tenantId string, handler string) []*DeadLetter {
+	s.RLock()
+	defer s.RUnlock()
+	list := make([]*DeadLetter, 0)
+	for _, id := range s.order {
+		dl := s.letters[id]
+		if (tenantId == "" || dl.TenantId == tenantId) && (handler == "" || dl.Handler == handler) {
+			list = append(list, dl)
+		}
+	}
+	return list
+}
+
+func (s *LocalFileDeadLetterStore) Delete(ctx Context, id string) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.letters[id]; !ok {
+		return nil
+	}
+	rec := &deadLetterRecord{Op: deadLetterOpDelete, Id: id}
+	err := s.write(rec)
+	if err != nil {
+		ctx.Log().Error("error_type", "dead_letter_store", "cause", "write_failed", "file", s.fileName, "error", err.Error())
+		return err
+	}
+	s.apply(rec)
+	return nil
+}
+
+// DeadLetterHandler http handler to list, inspect, replay and purge dead letters.
+//   GET    /deadletters?tenant=<tenant>&handler=<handler> - list dead letters, tenant and handler are optional
+//   DELETE /deadletters?tenant=<tenant>&handler=<handler> - purge dead letters
+//   POST   /deadletters/replay?tenant=<tenant>&handler=<handler> - replay dead letters
+//   GET    /deadletters/<id> - inspect single dead letter
+//   DELETE /deadletters/<id> - purge single dead letter
+//   POST   /deadletters/<id>/replay - replay single dead letter
+func DeadLetterHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := Gctx.SubContext()
+	w.Header().Set("Content-Type", "application/json")
+	dls := GetDeadLetterStore(ctx)
+	if dls == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"error":"%s"}`, "dead letter store not enabled")
+		return
+	}
+	tenantId := r.URL.Query().Get("tenant")
+	handler := r.URL.Query().Get("handler")
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, apiBasePath), "/")
+	segments := strings.Split(path, "/")
+	// drop leading v1 and deadletters segments
+	for len(segments) > 0 && (segments[0] == "v1" || segments[0] == "deadletters") {
+		segments = segments[1:]
+	}
+	var list []*DeadLetter
+	replay := len(segments) > 0 && segments[len(segments)-1] == "replay"
+	if replay {
+		segments = segments[:len(segments)-1]
+	}
+	if len(segments) > 0 {
+		dl := dls.Get(segments[0])
+		if dl == nil {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, `{"error":"%s"}`, "unknown dead letter "+segments[0])
+			return
+		}
+		list = []*DeadLetter{dl}
+	} else {
+		list = dls.List(tenantId, handler)
+	}
+	var result interface{}
+	switch {
+	case r.Method == "GET" && !replay:
+		masked := make([]*DeadLetter, 0, len(list))
+		for _, dl := range list {
+			masked = append(masked, dl.masked())
+		}
+		if len(segments) > 0 {
+			result = masked[0]
+		} else {
+			result = masked
+		}
+	case r.Method == "DELETE" && !replay:
+		purged := 0
+		for _, dl := range list {
+			if err := dls.Delete(ctx, dl.Id); err == nil {
+				purged++
+			}
+		}
+		ctx.Log().Info("action", "purge_dead_letters", "tenant", tenantId, "handler", handler, "count", purged)
+		result = map[string]int{"purged": purged}
+	case r.Method == "POST" && replay:
+		replayed := 0
+		failed := 0
+		for _, dl := range list {
+			err := ReplayDeadLetter(ctx.SubContext(), dl)
+			if err != nil {
+				ctx.Log().Error("error_type", "dead_letter_store", "cause", "replay_failed", "id", dl.Id, "tenant", dl.TenantId, "handler", dl.Handler, "error", err.Error())
+				updated := *dl
+				updated.Error = err.Error()
+				updated.Replays++
+				dls.Add(ctx, &updated)
+				failed++
+			} else {
+				dls.Delete(ctx, dl.Id)
+				replayed++
+			}
+		}
+		ctx.Log().Info("action", "replay_dead_letters", "tenant", tenantId, "handler", handler, "replayed", replayed, "failed", failed)
+		result = map[string]int{"replayed": replayed, "failed": failed}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		fmt.Fprintf(w, `{"error":"%s"}`, "method not allowed")
+		return
+	}
+	buf, err := json.MarshalIndent(result, "", "\t")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, `{"error":"%s"}`, err.Error())
+		return
+	}
+	w.Write(buf)
+}
@@ -66,6 +66,7 @@ type (
 		Transformations  string
 		CustomProperties string
 		Lists            [][][]string
+		PathTrace        []string
 		BasePath         string
 	}
 )
@@ -351,9 +352,12 @@ func ParserDebugHandler(w http.ResponseWriter, r *http.Request) {
 			t.Execute(w, ta)
 			return
 		}
+		trace := make([]string, 0)
+		ctx.AddValue(EelPathTrace, &trace)
 		r, l := jexpr.ExecuteDebug(ctx, mIn)
 		ta.Result = ToFlatString(r)
 		ta.Lists = l
+		ta.PathTrace = trace
 		if errs := GetErrors(ctx); errs != nil {
 			for _, e := range errs {
 				ta.ErrorMessage += e.Error() + "<br/>"
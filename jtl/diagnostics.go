@@ -0,0 +1,139 @@
+/**
+ * Copyright 2015 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jtl
+
+import (
+	"sort"
+	"strings"
+
+	. "github.com/Comcast/eel/util"
+)
+
+// checks reported in parse diagnostics
+const (
+	CheckSyntax                = "syntax"
+	CheckArity                 = "arity"
+	CheckUnknownFunction       = "unknown_function"
+	CheckIfteCondition         = "ifte_condition"
+	CheckUnknownTransformation = "unknown_transformation"
+	CheckUnknownProperty       = "unknown_property"
+	CheckUnknownVariable       = "unknown_variable"
+	CheckInlineSecret          = "inline_secret"
+)
+
+// NewParseDiagnostic creates a diagnostic for the parse error err of expression expr found at the json pointer in a
+// handler file. Errors other than syntax errors are reported at line 1, column 1.
+func NewParseDiagnostic(file string, pointer string, expr string, err error) ParseDiagnostic {
+	d := ParseDiagnostic{Message: err.Error(), File: file, Pointer: pointer, Expression: expr, Line: 1, Column: 1, Check: CheckSyntax}
+	pos := 0
+	if e, ok := err.(*ExprError); ok {
+		if e.Expr == "" {
+			e.Expr = expr
+		}
+		d.Line = e.Line()
+		d.Column = e.Column()
+		pos = e.Pos
+	}
+	d.Excerpt = excerpt(expr, pos)
+	if strings.HasPrefix(d.Message, "wrong number of params") {
+		d.Check = CheckArity
+	}
+	if strings.HasPrefix(d.Message, "unknown function ") {
+		d.Check = CheckUnknownFunction
+		if s := suggestFunction(strings.TrimPrefix(d.Message, "unknown function ")); s != "" {
+			d.Suggestion = "did you mean " + s + "()?"
+		}
+	}
+	return d
+}
+
+// excerpt returns the line of expr containing byte offset pos and a second line with a caret pointing at pos.
+func excerpt(expr string, pos int) string {
+	if pos > len(expr) {
+		pos = len(expr)
+	}
+	if pos < 0 {
+		pos = 0
+	}
+	start := strings.LastIndex(expr[:pos], "\n") + 1
+	end := strings.Index(expr[pos:], "\n")
+	if end < 0 {
+		end = len(expr)
+	} else {
+		end += pos
+	}
+	// keep tabs so that the caret lines up
+	indent := strings.Map(func(r rune) rune {
+		if r == '\t' {
+			return r
+		}
+		return ' '
+	}, expr[start:pos])
+	return expr[start:end] + "\n" + indent + "^"
+}
+
+// suggestFunction returns the name of the registered function closest to the misspelled name, or blank if there is
+// no reasonably close match.
+func suggestFunction(name string) string {
+	functionMutex.RLock()
+	names := make([]string, 0, len(functionMap))
+	for fn := range functionMap {
+		names = append(names, fn)
+	}
+	functionMutex.RUnlock()
+	sort.Strings(names)
+	maxDist := 1 + len(name)/4
+	best := ""
+	bestDist := maxDist + 1
+	for _, fn := range names {
+		d := editDistance(strings.ToLower(name), strings.ToLower(fn))
+		if d < bestDist {
+			best = fn
+			bestDist = d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance of a and b.
+func editDistance(a string, b string) int {
+	ra := []rune(a)
+	rb := []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
@@ -47,6 +47,12 @@ type (
 		SetPublisherConfigs(configs map[string]string) error
 		GetPublisherConfigs() map[string]string
 	}
+	// AuthInfoPublisher is implemented by publishers that return the AuthInfo they were given, dead letters of other
+	// publishers are replayed without AuthInfo.
+	AuthInfoPublisher interface {
+		EventPublisher
+		GetAuthInfo() map[string]string
+	}
 )
 
 var publisherMap = make(map[string]NewPublisher, 0)
@@ -56,6 +62,7 @@ type NewPublisher func(ctx Context) EventPublisher
 func init() {
 	publisherMap["http"] = NewHttpPublisher
 	publisherMap["null"] = NewNullPublisher
+	publisherMap["kafka"] = NewKafkaPublisher
 }
 
 // RegisterEventPublisher registers an external event publisher implementation for a new protocol
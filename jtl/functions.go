@@ -20,7 +20,6 @@ import (
 	"bytes"
 	"context"
 	"crypto/hmac"
-	"crypto/sha1"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -31,8 +30,10 @@ import (
 	"net/url"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	. "github.com/Comcast/eel/util"
@@ -47,195 +48,235 @@ type (
 		fn           func(ctx Context, doc *JDoc, params []string) interface{}
 		minNumParams int
 		maxNumParams int
-	}
+		result       FunctionResult
+		exactFloats  bool // floats are passed with full precision instead of rounded to two decimals
+	}
+	// JFunctionSignature describes name, number of parameters and result kind of a registered function.
+	JFunctionSignature struct {
+		Name         string
+		MinNumParams int
+		MaxNumParams int
+		Result       FunctionResult
+	}
+	// FunctionResult is the kind of value a function returns. Lint uses it to report ifte conditions that can never
+	// be true or false.
+	FunctionResult string
+)
+
+const (
+	ResultAny     FunctionResult = "any" // depends on the parameters or unknown
+	ResultBoolean FunctionResult = "boolean"
+	ResultString  FunctionResult = "string"
+	ResultNumber  FunctionResult = "number"
+	ResultObject  FunctionResult = "object"
+	ResultArray   FunctionResult = "array"
 )
 
+var functionMap = make(map[string]*JFunction, 0)
+var functionMutex sync.RWMutex
+
+func init() {
+	// hit external web service
+	// method - POST, GET etc.
+	// url - url of external service
+	// payload - payload to be sent to external service
+	// headers - headers to be sent to external service
+	// retries - if true, applies retry policy as specified in config.json in case of failure, no retries if false
+	// curl('<method>','<url>',['<payload>'],['<header-map>'],['<retries>'])
+	// example curl('POST', 'http://foo.com/bar/json', 'foo-{{/content/bar}}')
+	RegisterTypedFunction("curl", fnCurl, 2, 5, ResultAny)
+	// like curl but returns {"status":<status>,"headers":{...},"body":<body>} also for error responses, optional timeout
+	// as go duration or milliseconds: curlx('<method>','<url>',['<payload>'],['<header-map>'],['<timeout>'],['<retries>'])
+	RegisterTypedFunction("curlx", fnCurlX, 2, 6, ResultObject)
+	// hmac('<hashFunc>', '<input>', '<key>', ['<encoding>']), key is a secret reference (env:NAME or file:/path) or from prop()
+	RegisterTypedFunction("hmac", fnHmac, 3, 4, ResultString)
+	// perform a HTTP request to a given url using 2-legged oauth2 authentication.
+	//   url        - the url
+	//   oauth2Cred - the oauth2 credential in the custom property. It is expected to have the following 3 property
+	//                values - ClientId, ClientSecret, TokenURL.
+	//   method     - optional. The http method. Default is GET
+	//   payload    - optional. The body payload normally for POST or PUT method
+	// oauth2("<url>", '<oauth2Cred>')
+	RegisterTypedFunction("oauth2", fnOauth2, 2, 4, ResultString)
+	// loadfile("<filename>')
+	RegisterTypedFunction("loadfile", fnLoadFile, 1, 1, ResultString)
+	// returns UUID string
+	// uuid()
+	RegisterTypedFunction("uuid", fnUuid, 0, 0, ResultString)
+	// returns a value given the http request header key, or all headers if no key is given
+	// header('mykey')
+	RegisterTypedFunction("header", fnHeader, 0, 1, ResultAny)
+	// returns a value given the http request query string parameter key, or all headers if no key is given
+	// param('mykey')
+	RegisterTypedFunction("param", fnParam, 0, 1, ResultAny)
+	// returns input parameter unchanged, for debugging only
+	// ident('foo')
+	RegisterTypedFunction("ident", fnIdent, 1, 1, ResultAny)
+	// upper case input string, example upper('foo')
+	RegisterTypedFunction("upper", fnUpper, 1, 1, ResultString)
+	// lower case input string, example lower('foo')
+	RegisterTypedFunction("lower", fnLower, 1, 1, ResultString)
+	// base64 decode input string, example base64decode('foo')
+	RegisterTypedFunction("base64decode", fnBase64Decode, 1, 1, ResultString)
+	// substring by start and end index, example substr('foo', 0, 1)
+	RegisterTypedFunction("substr", fnSubstr, 3, 3, ResultString)
+	// evaluates simple path expression on current document and returns result
+	RegisterTypedFunction("eval", fnEval, 1, 2, ResultAny)
+	// return property from CustomProperties section in config.json
+	RegisterTypedFunction("prop", fnProp, 1, 1, ResultAny)
+	// check whether or not a property exists
+	RegisterTypedFunction("propexists", fnPropExists, 1, 1, ResultBoolean)
+	// return variable from Variables section of current handler: var('<name>')
+	RegisterTypedFunction("var", fnVar, 1, 1, ResultAny)
+	// execute arbitrary javascript and return result
+	RegisterTypedFunction("js", fnJs, 1, 100, ResultAny)
+	// return first non blank parameter (alternative)
+	RegisterTypedFunction("alt", fnAlt, 2, 100, ResultAny)
+	// simplification of nested ifte(equals(),'foo', ifte(equals(...),...)) cascade
+	// case('<path_1>','<comparison_value_1>','<return_value_1>', '<path_2>','<comparison_value_2>','<return_value_2>,...,'<default>')
+	RegisterTypedFunction("case", fnCase, 3, 100, ResultAny)
+	// apply regex to string value and return (first) result: regex('<string>', '<regex>')
+	RegisterTypedFunction("regex", fnRegex, 2, 3, ResultAny)
+	// apply regex to string value and return true if matches: match('<string>', '<regex>')
+	RegisterTypedFunction("match", fnMatch, 2, 2, ResultBoolean)
+	// check whether or not a string has suffix: match('<string>', '<suffix>')
+	RegisterTypedFunction("hassuffix", fnHasSuffix, 2, 2, ResultBoolean)
+	// boolean and: and('<bool>', '<bool>', ...)
+	RegisterTypedFunction("and", fnAnd, 1, 100, ResultBoolean)
+	// boolean or: or('<bool>', '<bool>', ...)
+	RegisterTypedFunction("or", fnOr, 1, 100, ResultBoolean)
+	// boolean not: not('<bool>')
+	RegisterTypedFunction("not", fnNot, 1, 1, ResultBoolean)
+	// checks if document contains another document: contains('<doc1>', ['<doc2>'])
+	RegisterTypedFunction("contains", fnContains, 1, 2, ResultBoolean)
+	// checks if document is equal to another json document or if two strings are equal: equals('<doc1>',['<doc2>'])
+	RegisterTypedFunction("equals", fnEquals, 1, 2, ResultBoolean)
+	// merges two json documents into one, key conflicts are resolved at random
+	RegisterTypedFunction("join", fnJoin, 2, 2, ResultObject)
+	// input is a string and output is json object
+	// input example:{\"timestamp\": 1602873483}
+	RegisterTypedFunction("stringtojson", fnStringToJson, 1, 1, ResultAny)
+	// formats time string: format('<ms>',['<layout>'],['<timezone>']), example: format('1439962298000','Mon Jan 2 15:04:05 2006','PST')
+	RegisterTypedFunction("format", fnFormat, 1, 3, ResultString)
+	// if condition then this else that: ifte('<condition>','<then>',['<else>']), example: ifte('{{equals('{{/data/name}}','')}}','','by {{/data/name}}')
+	RegisterTypedFunction("ifte", fnIfte, 1, 3, ResultAny)
+	// apply transformation: transform('<name_of_transformation>', '<doc>', ['<pattern>'], ['<join>']), example: transform('my_transformation', '{{/content}}')
+	// - the transformation is selected by name from an optional transformation map in the handler config
+	// - if the document is an array, the transformation will be iteratively applied to all array elements
+	// - if a pattern is provided will only be applied if document is matching the pattern
+	// - if a join is provided it will be joined with the document before applying the transformation
+	RegisterTypedFunction("transform", fnTransform, 1, 4, ResultAny)
+	// apply transformation iteratively: transform('<name_of_transformation>', '<doc>', ['<pattern>'], ['<join>']), example: transform('my_transformation', '{{/content}}')
+	// - the transformation is selected by name from an optional transformation map in the handler config
+	// - if the document is an array, the transformation will be iteratively applied to all array elements
+	// - if a pattern is provided will only be applied if document is matching the pattern
+	// - if a join is provided it will be joined with the document before applying the transformation
+	RegisterTypedFunction("itransform", fnITransform, 1, 4, ResultAny)
+	// apply external transformation and return single result (efficient shortcut for and equivalent to curl http://localhost:8080/proc)
+	RegisterTypedFunction("etransform", fnETransform, 1, 1, ResultAny)
+	// apply external transformation and execute publisher(s) (efficient shortcut for and equivalent to curl http://localhost:8080/proxy)
+	RegisterTypedFunction("ptransform", fnPTransform, 1, 1, ResultAny)
+	// returns always true, shorthand for equals('1', '1')
+	RegisterTypedFunction("true", fnTrue, 0, 0, ResultBoolean)
+	// returns always false, shorthand for equals('1', '2')
+	RegisterTypedFunction("false", fnFalse, 0, 0, ResultBoolean)
+	// returns current time as timestamp
+	RegisterTypedFunction("time", fnTime, 0, 0, ResultNumber)
+	// returns tenant of current handler
+	RegisterTypedFunction("tenant", fnTenant, 0, 0, ResultString)
+	// returns partner of current handler
+	RegisterTypedFunction("partner", fnPartner, 0, 0, ResultString)
+	// returns current trace id used for logging
+	RegisterTypedFunction("traceid", fnTraceId, 0, 0, ResultString)
+	// chooses elements for list or array based on pattern
+	RegisterTypedFunction("choose", fnChoose, 2, 2, ResultAny)
+	// collapse a JSON document into a flat array
+	RegisterTypedFunction("crush", fnCrush, 1, 1, ResultArray)
+	// returns length of object (string, array, map)
+	RegisterTypedFunction("len", fnLen, 1, 1, ResultNumber)
+	// returns length of object (string, array, map)
+	RegisterTypedFunction("string", fnString, 2, 2, ResultString)
+	// returns true if path exists in document
+	RegisterTypedFunction("exists", fnExists, 1, 2, ResultBoolean)
+	// evaluates simple arithmetic expressions in native go and returns result
+	RegisterTypedFunction("calc", fnCalc, 1, 1, ResultAny)
+	// logs parameter for debuging
+	RegisterTypedFunction("log", fnLog, 1, 1, ResultString)
+	// hash a given string
+	RegisterTypedFunction("hash", fnHash, 1, 1, ResultString)
+	// hash a given string and then mod it by the given divider
+	RegisterTypedFunction("hashmod", fnHashMod, 2, 2, ResultNumber)
+	//Take a timestamp string and convert it to unix ts in milliseconds
+	RegisterTypedFunction("toTS", fnToTS, 2, 2, ResultNumber)
+}
+
+// RegisterFunction registers an external function implementation under the given name so it can be used in
+// jpath expressions. Registering a function with the name of an existing function replaces the existing function.
+// RegisterFunction adds or replaces a function implementation whose result kind is not known in advance.
+func RegisterFunction(name string, fn func(ctx Context, doc *JDoc, params []string) interface{}, minNumParams int, maxNumParams int) {
+	RegisterTypedFunction(name, fn, minNumParams, maxNumParams, ResultAny)
+}
+
+// RegisterTypedFunction adds or replaces a function implementation which always returns the given kind of result.
+func RegisterTypedFunction(name string, fn func(ctx Context, doc *JDoc, params []string) interface{}, minNumParams int, maxNumParams int, result FunctionResult) {
+	functionMutex.Lock()
+	defer functionMutex.Unlock()
+	functionMap[name] = &JFunction{fn, minNumParams, maxNumParams, result, false}
+}
+
+// RegisterExactFunction adds or replaces a function implementation like RegisterTypedFunction. Unlike for other
+// functions, float values passed as parameters are not rounded to two decimals, so that for example round() and
+// tofloat() see the full value.
+func RegisterExactFunction(name string, fn func(ctx Context, doc *JDoc, params []string) interface{}, minNumParams int, maxNumParams int, result FunctionResult) {
+	functionMutex.Lock()
+	defer functionMutex.Unlock()
+	functionMap[name] = &JFunction{fn, minNumParams, maxNumParams, result, true}
+}
+
+// GetFunctionResult returns the result kind of a registered function, ResultAny for unknown functions.
+func GetFunctionResult(name string) FunctionResult {
+	functionMutex.RLock()
+	defer functionMutex.RUnlock()
+	if f, ok := functionMap[name]; ok {
+		return f.result
+	}
+	return ResultAny
+}
+
+// UnregisterFunction removes a function implementation
+func UnregisterFunction(name string) {
+	functionMutex.Lock()
+	defer functionMutex.Unlock()
+	delete(functionMap, name)
+}
+
+// GetFunctionSignatures returns the signatures of all registered functions sorted by name.
+func GetFunctionSignatures() []*JFunctionSignature {
+	functionMutex.RLock()
+	defer functionMutex.RUnlock()
+	sigs := make([]*JFunctionSignature, 0, len(functionMap))
+	for name, f := range functionMap {
+		sigs = append(sigs, &JFunctionSignature{name, f.minNumParams, f.maxNumParams, f.result})
+	}
+	sort.Slice(sigs, func(i, j int) bool { return sigs[i].Name < sigs[j].Name })
+	return sigs
+}
+
 // NewFunction gets function implementation by name.
 func NewFunction(fn string) *JFunction {
-	//stats := gctx.Value(EelTotalStats).(*ServiceStats)
-	switch fn {
-	case "curl":
-		// hit external web service
-		// method - POST, GET etc.
-		// url - url of external service
-		// payload - payload to be sent to external service
-		// headers - headers to be sent to external service
-		// retries - if true, applies retry policy as specified in config.json in case of failure, no retries if false
-		// curl('<method>','<url>',['<payload>'],['<header-map>'],['<retries>'])
-		// example curl('POST', 'http://foo.com/bar/json', 'foo-{{/content/bar}}')
-		return &JFunction{fnCurl, 2, 5}
-	case "hmac":
-		// hmac("<hashFunc>", '<input>', '<key>')
-		return &JFunction{fnHmac, 3, 3}
-	case "oauth2":
-		// perform a HTTP request to a given url using 2-legged oauth2 authentication.
-		//   url        - the url
-		//   oauth2Cred - the oauth2 credential in the custom property. It is expected to have the following 3 property
-		//                values - ClientId, ClientSecret, TokenURL.
-		//   method     - optional. The http method. Default is GET
-		//   payload    - optional. The body payload normally for POST or PUT method
-		// oauth2("<url>", '<oauth2Cred>')
-		return &JFunction{fnOauth2, 2, 4}
-	case "loadfile":
-		// loadfile("<filename>')
-		return &JFunction{fnLoadFile, 1, 1}
-	case "uuid":
-		// returns UUID string
-		// uuid()
-		return &JFunction{fnUuid, 0, 0}
-	case "header":
-		// returns a value given the http request header key, or all headers if no key is given
-		// header('mykey')
-		return &JFunction{fnHeader, 0, 1}
-	case "param":
-		// returns a value given the http request query string parameter key, or all headers if no key is given
-		// param('mykey')
-		return &JFunction{fnParam, 0, 1}
-	case "ident":
-		// returns input parameter unchanged, for debugging only
-		// ident('foo')
-		return &JFunction{fnIdent, 1, 1}
-	case "upper":
-		// upper case input string, example upper('foo')
-		return &JFunction{fnUpper, 1, 1}
-	case "lower":
-		// lower case input string, example lower('foo')
-		return &JFunction{fnLower, 1, 1}
-	case "base64decode":
-		// base64 decode input string, example base64decode('foo')
-		return &JFunction{fnBase64Decode, 1, 1}
-	case "substr":
-		// substring by start and end index, example substr('foo', 0, 1)
-		return &JFunction{fnSubstr, 3, 3}
-	case "eval":
-		// evaluates simple path expression on current document and returns result
-		return &JFunction{fnEval, 1, 2}
-	case "prop":
-		// return property from CustomProperties section in config.json
-		return &JFunction{fnProp, 1, 1}
-	case "propexists":
-		// check whether or not a property exists
-		return &JFunction{fnPropExists, 1, 1}
-	case "js":
-		// execute arbitrary javascript and return result
-		return &JFunction{fnJs, 1, 100}
-	case "alt":
-		// return first non blank parameter (alternative)
-		return &JFunction{fnAlt, 2, 100}
-	case "case":
-		// simplification of nested ifte(equals(),'foo', ifte(equals(...),...)) cascade
-		// case('<path_1>','<comparison_value_1>','<return_value_1>', '<path_2>','<comparison_value_2>','<return_value_2>,...,'<default>')
-		return &JFunction{fnCase, 3, 100}
-	case "regex":
-		// apply regex to string value and return (first) result: regex('<string>', '<regex>')
-		return &JFunction{fnRegex, 2, 3}
-	case "match":
-		// apply regex to string value and return true if matches: match('<string>', '<regex>')
-		return &JFunction{fnMatch, 2, 2}
-	case "hassuffix":
-		// check whether or not a string has suffix: match('<string>', '<suffix>')
-		return &JFunction{fnHasSuffix, 2, 2}
-	case "and":
-		// boolean and: and('<bool>', '<bool>', ...)
-		return &JFunction{fnAnd, 1, 100}
-	case "or":
-		// boolean or: or('<bool>', '<bool>', ...)
-		return &JFunction{fnOr, 1, 100}
-	case "not":
-		// boolean not: not('<bool>')
-		return &JFunction{fnNot, 1, 1}
-	case "contains":
-		// checks if document contains another document: contains('<doc1>', ['<doc2>'])
-		return &JFunction{fnContains, 1, 2}
-	case "equals":
-		// checks if document is equal to another json document or if two strings are equal: equals('<doc1>',['<doc2>'])
-		return &JFunction{fnEquals, 1, 2}
-	case "join":
-		// merges two json documents into one, key conflicts are resolved at random
-		return &JFunction{fnJoin, 2, 2}
-	case "stringtojson":
-		// input is a string and output is json object
-		// input example:{\"timestamp\": 1602873483}
-		return &JFunction{fnStringToJson, 1, 1}
-	case "format":
-		// formats time string: format('<ms>',['<layout>'],['<timezone>']), example: format('1439962298000','Mon Jan 2 15:04:05 2006','PST')
-		return &JFunction{fnFormat, 1, 3}
-	case "ifte":
-		// if condition then this else that: ifte('<condition>','<then>',['<else>']), example: ifte('{{equals('{{/data/name}}','')}}','','by {{/data/name}}')
-		return &JFunction{fnIfte, 1, 3}
-	case "transform":
-		// apply transformation: transform('<name_of_transformation>', '<doc>', ['<pattern>'], ['<join>']), example: transform('my_transformation', '{{/content}}')
-		// - the transformation is selected by name from an optional transformation map in the handler config
-		// - if the document is an array, the transformation will be iteratively applied to all array elements
-		// - if a pattern is provided will only be applied if document is matching the pattern
-		// - if a join is provided it will be joined with the document before applying the transformation
-		return &JFunction{fnTransform, 1, 4}
-	case "itransform":
-		// apply transformation iteratively: transform('<name_of_transformation>', '<doc>', ['<pattern>'], ['<join>']), example: transform('my_transformation', '{{/content}}')
-		// - the transformation is selected by name from an optional transformation map in the handler config
-		// - if the document is an array, the transformation will be iteratively applied to all array elements
-		// - if a pattern is provided will only be applied if document is matching the pattern
-		// - if a join is provided it will be joined with the document before applying the transformation
-		return &JFunction{fnITransform, 1, 4}
-	case "etransform":
-		// apply external transformation and return single result (efficient shortcut for and equivalent to curl http://localhost:8080/proc)
-		return &JFunction{fnETransform, 1, 1}
-	case "ptransform":
-		// apply external transformation and execute publisher(s) (efficient shortcut for and equivalent to curl http://localhost:8080/proxy)
-		return &JFunction{fnPTransform, 1, 1}
-	case "true":
-		// returns always true, shorthand for equals('1', '1')
-		return &JFunction{fnTrue, 0, 0}
-	case "false":
-		// returns always false, shorthand for equals('1', '2')
-		return &JFunction{fnFalse, 0, 0}
-	case "time":
-		// returns current time as timestamp
-		return &JFunction{fnTime, 0, 0}
-	case "tenant":
-		// returns tenant of current handler
-		return &JFunction{fnTenant, 0, 0}
-	case "partner":
-		// returns partner of current handler
-		return &JFunction{fnPartner, 0, 0}
-	case "traceid":
-		// returns current trace id used for logging
-		return &JFunction{fnTraceId, 0, 0}
-	case "choose":
-		// chooses elements for list or array based on pattern
-		return &JFunction{fnChoose, 2, 2}
-	case "crush":
-		// collapse a JSON document into a flat array
-		return &JFunction{fnCrush, 1, 1}
-	case "len":
-		// returns length of object (string, array, map)
-		return &JFunction{fnLen, 1, 1}
-	case "string":
-		// returns length of object (string, array, map)
-		return &JFunction{fnString, 2, 2}
-	case "exists":
-		// returns true if path exists in document
-		return &JFunction{fnExists, 1, 2}
-	case "calc":
-		// evaluates simple arithmetic expressions in native go and returns result
-		return &JFunction{fnCalc, 1, 1}
-	case "log":
-		// logs parameter for debuging
-		return &JFunction{fnLog, 1, 1}
-	case "hash":
-		// hash a given string
-		return &JFunction{fnHash, 1, 1}
-	case "hashmod":
-		// hash a given string and then mod it by the given divider
-		return &JFunction{fnHashMod, 2, 2}
-	case "toTS":
-		//Take a timestamp string and convert it to unix ts in milliseconds
-		return &JFunction{fnToTS, 2, 2}
-	default:
-		//gctx.Log.Error("error_type", "func_", "op", fn, "cause", "not_implemented")
-		//stats.IncErrors()
-		return nil
-	}
+	functionMutex.RLock()
+	defer functionMutex.RUnlock()
+	return functionMap[fn]
+}
+
+// GetMinNumParams returns the minimum number of parameters the function accepts.
+func (f *JFunction) GetMinNumParams() int {
+	return f.minNumParams
+}
+
+// GetMaxNumParams returns the maximum number of parameters the function accepts.
+func (f *JFunction) GetMaxNumParams() int {
+	return f.maxNumParams
 }
 
 var oauthClientCache map[string]*http.Client = make(map[string]*http.Client)
@@ -751,22 +792,145 @@ func fnCurl(ctx Context, doc *JDoc, params []string) interface{} {
 			return nil
 		}
 	}
-	endpoint := extractStringParam(params[1])
-	// url encode query string
+	endpoint := curlEndpoint(ctx, extractStringParam(params[1]))
+	headerMap := ""
+	if len(params) >= 4 {
+		headerMap = extractStringParam(params[3])
+	}
+	headers := curlHeaders(ctx, doc, "curl", params, headerMap)
+	body := ""
+	if len(params) >= 3 {
+		body = extractStringParam(params[2])
+	}
+	ctx.AddLogValue("destination", "external_service")
+	var resp string
+	var status int
+	if retry {
+		resp, status, err = GetRetrier(ctx).RetryEndpoint(ctx, endpoint, body, extractStringParam(params[0]), headers, nil)
+	} else {
+		resp, status, err = HitEndpoint(ctx, endpoint, body, extractStringParam(params[0]), headers, nil)
+	}
+	if err != nil {
+		// this error will already be counted by hitEndpoint
+		ctx.Log().Error("error_type", "func_curl", "op", "curl", "cause", "curl_error", "status", strconv.Itoa(status), "error", err.Error(), "response", resp, "params", params)
+		AddError(ctx, NetworkError{endpoint, err.Error(), status})
+		return nil
+	}
+	if status < 200 || status >= 300 {
+		// this error will already be counted by hitEndpoint
+		ctx.Log().Error("error_type", "func_curl", "op", "curl", "cause", "curl_status", "status", strconv.Itoa(status), "response", resp, "params", params)
+		AddError(ctx, NetworkError{endpoint, "endpoint returned error", status})
+		return nil
+	}
+	ctx.Log().Debug("op", "curl", "resp", resp, "endpoint", endpoint, "body", body, "params", extractStringParam(params[0]), "headers", headers, "status", status)
+
+	var res interface{}
+	err = json.Unmarshal([]byte(resp), &res)
+	if err != nil {
+		return resp
+	} else {
+		return res
+	}
+}
+
+// fnCurlX is like curl but returns status, headers and body of the response as json object, also for responses outside
+// 2xx, so that handlers can branch on the status or map error responses with transform(). Header values are joined
+// with commas, the body is parsed as json if possible. An optional timeout (go duration or milliseconds) replaces
+// HttpTimeout for this call. If the endpoint cannot be reached the status is 0 and the error is returned as well.
+func fnCurlX(ctx Context, doc *JDoc, params []string) interface{} {
+	stats := ctx.Value(EelTotalStats).(*ServiceStats)
+	if !checkParamCount(ctx, "curlx", params, 2, 6) {
+		return nil
+	}
+	var timeout time.Duration
+	if len(params) >= 5 && strings.TrimSpace(extractStringParam(params[4])) != "" {
+		t := strings.TrimSpace(extractStringParam(params[4]))
+		ms, err := strconv.Atoi(t)
+		if err == nil {
+			timeout = time.Duration(ms) * time.Millisecond
+		} else if timeout, err = parseDuration(t); err != nil || timeout <= 0 {
+			stats.IncErrors()
+			ctx.Log().Error("error_type", "func_curlx", "op", "curlx", "cause", "invalid_timeout", "params", params)
+			AddError(ctx, SyntaxError{fmt.Sprintf("invalid timeout %s in call to curlx function", t), "curlx", params})
+			return nil
+		}
+	}
+	retry := false
+	if len(params) == 6 {
+		var err error
+		if retry, err = strconv.ParseBool(extractStringParam(params[5])); err != nil {
+			stats.IncErrors()
+			ctx.Log().Error("error_type", "func_curlx", "op", "curlx", "cause", "non_boolean_parameter", "params", params, "error", err.Error())
+			AddError(ctx, SyntaxError{"non boolean parameter in call to curlx function", "curlx", params})
+			return nil
+		}
+	}
+	verb := extractStringParam(params[0])
+	endpoint := curlEndpoint(ctx, extractStringParam(params[1]))
+	headerMap := ""
+	if len(params) >= 4 {
+		headerMap = extractStringParam(params[3])
+	}
+	headers := curlHeaders(ctx, doc, "curlx", params, headerMap)
+	body := ""
+	if len(params) >= 3 {
+		body = extractStringParam(params[2])
+	}
+	ctx.AddLogValue("destination", "external_service")
+	resp := &HttpResponse{}
+	hit := func(ctx Context, url string, payload string, verb string, headers map[string]string, auth map[string]string) (string, int, error) {
+		var err error
+		resp, err = HitEndpointWithResponse(ctx, url, payload, verb, headers, auth, timeout)
+		return resp.Body, resp.Status, err
+	}
+	var err error
+	if retry {
+		_, _, err = GetRetrier(ctx).Retry(ctx, endpoint, body, verb, headers, nil, hit)
+	} else {
+		_, _, err = hit(ctx, endpoint, body, verb, headers, nil)
+	}
+	header := make(map[string]interface{}, len(resp.Header))
+	for k, v := range resp.Header {
+		header[k] = strings.Join(v, ", ")
+	}
+	res := map[string]interface{}{"status": resp.Status, "headers": header, "body": resp.Body}
+	if err != nil {
+		// this error will already be counted by hitEndpoint
+		ctx.Log().Error("error_type", "func_curlx", "op", "curlx", "cause", "curl_error", "status", strconv.Itoa(resp.Status), "error", err.Error(), "params", params)
+		AddError(ctx, NetworkError{endpoint, err.Error(), resp.Status})
+		res["error"] = err.Error()
+		return res
+	}
+	ctx.Log().Debug("op", "curlx", "resp", resp.Body, "endpoint", endpoint, "body", body, "params", verb, "headers", headers, "status", resp.Status)
+	var parsed interface{}
+	if json.Unmarshal([]byte(resp.Body), &parsed) == nil {
+		res["body"] = parsed
+	}
+	return res
+}
+
+// curlEndpoint url encodes the query string of an endpoint, which is replaced by debug.url if configured.
+func curlEndpoint(ctx Context, endpoint string) string {
 	parsed, _ := url.Parse(endpoint)
 	parsed.RawQuery = parsed.Query().Encode()
 	endpoint = parsed.String()
 	if ctx.ConfigValue("debug.url") != nil {
 		endpoint = ctx.ConfigValue("debug.url").(string)
 	}
-	// compose http headers: at a minimum use trace header (if available), then add extra headers (if given in param #5)
+	return endpoint
+}
+
+// curlHeaders composes the http headers of a curl call: at a minimum the trace header (if available), then the extra
+// headers of the header map parameter.
+func curlHeaders(ctx Context, doc *JDoc, op string, params []string, headerMap string) map[string]string {
 	hmap := make(map[string]interface{})
-	if len(params) >= 4 {
-		hdoc, err := NewJDocFromString(extractStringParam(params[3]))
+	if headerMap != "" {
+		hdoc, err := NewJDocFromString(headerMap)
 		if err != nil {
+			stats := ctx.Value(EelTotalStats).(*ServiceStats)
 			stats.IncErrors()
-			ctx.Log().Error("error_type", "func_curl", "op", "curl", "cause", "invalid_headers", "error", err.Error(), "params", params)
-			AddError(ctx, SyntaxError{fmt.Sprintf("invalid headers parameters in call to curl function"), "curl", params})
+			ctx.Log().Error("error_type", "func_"+op, "op", op, "cause", "invalid_headers", "error", err.Error(), "params", params)
+			AddError(ctx, SyntaxError{fmt.Sprintf("invalid headers parameters in call to %s function", op), op, params})
 		} else {
 			hmap = hdoc.GetMapValue("/")
 		}
@@ -808,39 +972,7 @@ func fnCurl(ctx Context, doc *JDoc, params []string) interface{} {
 			}
 		}
 	}
-	body := ""
-	if len(params) >= 3 {
-		body = extractStringParam(params[2])
-	}
-	ctx.AddLogValue("destination", "external_service")
-	var resp string
-	var status int
-	if retry {
-		resp, status, err = GetRetrier(ctx).RetryEndpoint(ctx, endpoint, body, extractStringParam(params[0]), headers, nil)
-	} else {
-		resp, status, err = HitEndpoint(ctx, endpoint, body, extractStringParam(params[0]), headers, nil)
-	}
-	if err != nil {
-		// this error will already be counted by hitEndpoint
-		ctx.Log().Error("error_type", "func_curl", "op", "curl", "cause", "curl_error", "status", strconv.Itoa(status), "error", err.Error(), "response", resp, "params", params)
-		AddError(ctx, NetworkError{endpoint, err.Error(), status})
-		return nil
-	}
-	if status < 200 || status >= 300 {
-		// this error will already be counted by hitEndpoint
-		ctx.Log().Error("error_type", "func_curl", "op", "curl", "cause", "curl_status", "status", strconv.Itoa(status), "response", resp, "params", params)
-		AddError(ctx, NetworkError{endpoint, "endpoint returned error", status})
-		return nil
-	}
-	ctx.Log().Debug("op", "curl", "resp", resp, "endpoint", endpoint, "body", body, "params", extractStringParam(params[0]), "headers", headers, "status", status)
-
-	var res interface{}
-	err = json.Unmarshal([]byte(resp), &res)
-	if err != nil {
-		return resp
-	} else {
-		return res
-	}
+	return headers
 }
 
 // fnHeader function to obtain http header value from incoming event by key.
@@ -1228,6 +1360,25 @@ func fnPropExists(ctx Context, doc *JDoc, params []string) interface{} {
 	return true
 }
 
+// fnVar returns a variable of the current handler. Variables are evaluated once per event before the transformation.
+func fnVar(ctx Context, doc *JDoc, params []string) interface{} {
+	stats := ctx.Value(EelTotalStats).(*ServiceStats)
+	if params == nil || len(params) != 1 {
+		ctx.Log().Error("error_type", "func_var", "op", "var", "cause", "wrong_number_of_parameters", "params", params)
+		stats.IncErrors()
+		AddError(ctx, SyntaxError{fmt.Sprintf("wrong number of parameters in call to var function"), "var", params})
+		return ""
+	}
+	val, ok := GetVariables(ctx)[extractStringParam(params[0])]
+	if !ok {
+		ctx.Log().Error("error_type", "func_var", "op", "var", "cause", "variable_not_found", "params", params)
+		stats.IncErrors()
+		AddError(ctx, RuntimeError{fmt.Sprintf("variable %s not found in call to var function", extractStringParam(params[0])), "var", params})
+		return ""
+	}
+	return val
+}
+
 // fnTenant return current tenant.
 func fnTenant(ctx Context, doc *JDoc, params []string) interface{} {
 	stats := ctx.Value(EelTotalStats).(*ServiceStats)
@@ -1799,29 +1950,39 @@ func fnToTS(ctx Context, doc *JDoc, params []string) interface{} {
 	return t.UnixNano() / 1e6
 }
 
-// fnHmac uses specified hash function to hash input with key
+// fnHmac uses specified hash function (MD5, SHA1, SHA256 or SHA512) to sign input with key. The key is either a secret
+// reference (env:NAME or file:/path) or obtained with prop(), the signature is base64 encoded by default.
 func fnHmac(ctx Context, doc *JDoc, params []string) interface{} {
 	stats := ctx.Value(EelTotalStats).(*ServiceStats)
-	if params == nil || len(params) != 3 {
-		ctx.Log().Error("error_type", "func_hmac", "op", "hmac", "cause", "wrong_number_of_parameters", "params", params)
+	if params == nil || len(params) < 3 || len(params) > 4 {
+		ctx.Log().Error("error_type", "func_hmac", "op", "hmac", "cause", "wrong_number_of_parameters")
 		stats.IncErrors()
-		AddError(ctx, SyntaxError{fmt.Sprintf("wrong number of parameters in call to hmac function"), "curl", params})
+		AddError(ctx, SyntaxError{fmt.Sprintf("wrong number of parameters in call to hmac function"), "hmac", nil})
 		return nil
-	} else {
-		hashFunc := extractStringParam(params[0])
-		input := extractStringParam(params[1])
-		key := extractStringParam(params[2])
-		if hashFunc == "SHA1" {
-			key_for_sign := []byte(key)
-			h := hmac.New(sha1.New, key_for_sign)
-			h.Write([]byte(input))
-			return base64.StdEncoding.EncodeToString(h.Sum(nil))
-		} else {
-			ctx.Log().Error("error_type", "func_hmac", "op", "hmac", "cause", "hash_func_not_yet_support", "params", params)
-			return nil
-		}
 	}
-	return nil
+	hashFunc := strings.ToUpper(strings.Replace(extractStringParam(params[0]), "-", "", -1))
+	newHash, ok := hashFunctions[hashFunc]
+	if !ok {
+		ctx.Log().Error("error_type", "func_hmac", "op", "hmac", "cause", "hash_func_not_yet_support", "hash", hashFunc)
+		stats.IncErrors()
+		AddError(ctx, SyntaxError{fmt.Sprintf("unsupported hash function %s in call to hmac function", hashFunc), "hmac", nil})
+		return nil
+	}
+	key, ok := secretParam(ctx, "hmac", params, 2)
+	if !ok {
+		return nil
+	}
+	h := hmac.New(newHash, []byte(key))
+	h.Write([]byte(extractStringParam(params[1])))
+	encoding := "base64"
+	if len(params) == 4 {
+		encoding = extractStringParam(params[3])
+	}
+	res, ok := encodeDigest(ctx, "hmac", nil, h.Sum(nil), encoding)
+	if !ok {
+		return nil
+	}
+	return res
 }
 
 // fnLoadFile reads file, and returns the content as string
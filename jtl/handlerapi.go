@@ -0,0 +1,210 @@
+/**
+ * Copyright 2015 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jtl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	. "github.com/Comcast/eel/util"
+)
+
+// handlerMutex serializes changes to handler files so that version checks and writes are atomic. It is shared with
+// HotReload and ReloadConfig so that a reload cannot swap the handler factory while the API is updating it.
+var handlerMutex sync.Mutex
+
+// HandlerApiHandler http handler to read, create, update and delete handlers at runtime using
+// GET, PUT and DELETE on /v1/handlers/{tenant}/{name}. Handlers are vetted before they are saved in the tenant folder
+// and the live handler factory is updated without a full reload. Updates and deletes of an existing handler require an
+// If-Match header with the current handler version (as returned in the ETag header by GET) to prevent lost updates.
+func HandlerApiHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := Gctx.SubContext()
+	w.Header().Set("Content-Type", "application/json")
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, apiBasePath), "/")
+	segments := strings.Split(path, "/")
+	// drop leading v1 and handlers segments
+	for len(segments) > 0 && (segments[0] == "v1" || segments[0] == "handlers") {
+		segments = segments[1:]
+	}
+	if len(segments) != 2 || !isValidHandlerPathSegment(segments[0]) || !isValidHandlerPathSegment(segments[1]) {
+		writeHandlerApiError(w, http.StatusBadRequest, "expected /v1/handlers/{tenant}/{name}")
+		return
+	}
+	tenant := segments[0]
+	name := segments[1]
+	handlerMutex.Lock()
+	defer handlerMutex.Unlock()
+	file, current := findHandlerFile(ctx, tenant, name)
+	switch r.Method {
+	case "GET":
+		if current == nil {
+			writeHandlerApiError(w, http.StatusNotFound, "unknown handler "+tenant+"/"+name)
+			return
+		}
+		writeHandler(w, http.StatusOK, current)
+	case "PUT":
+		if !checkHandlerVersion(w, r, current) {
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeHandlerApiError(w, http.StatusBadRequest, "error reading request body")
+			return
+		}
+		var hc HandlerConfiguration
+		err = json.Unmarshal(body, &hc)
+		if err != nil {
+			writeHandlerApiError(w, http.StatusBadRequest, "invalid json: "+err.Error())
+			return
+		}
+		if hc.Name == "" {
+			hc.Name = name
+		}
+		if hc.Name != name {
+			writeHandlerApiError(w, http.StatusBadRequest, "handler name "+hc.Name+" does not match "+name)
+			return
+		}
+		if current != nil && hc.Version == current.Version {
+			writeHandlerApiError(w, http.StatusConflict, "version must change on update, current version is "+current.Version)
+			return
+		}
+		if file == "" {
+			file = filepath.Join(HandlerPaths[0], tenant, name+".json")
+		}
+		handler, warnings := GetHandlerConfigurationFromJson(ctx, file, hc)
+		if len(warnings) > 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			buf, _ := json.MarshalIndent(map[string]interface{}{"error": "invalid handler", "warnings": warnings}, "", "\t")
+			w.Write(buf)
+			return
+		}
+		handler.File = file
+		err = os.MkdirAll(filepath.Dir(file), 0755)
+		if err == nil {
+			err = handler.Save()
+		}
+		if err != nil {
+			ctx.Log().Error("error_type", "handler_api", "cause", "save_failed", "file", file, "tenant", tenant, "name", name, "error", err.Error())
+			writeHandlerApiError(w, http.StatusInternalServerError, "error saving handler")
+			return
+		}
+		Gctx.AddConfigValue(EelHandlerFactory, GetHandlerFactory(Gctx).WithHandler(ctx, tenant, name, handler))
+		ctx.Log().Info("action", "saved_handler", "file", file, "tenant", tenant, "name", name, "version", handler.Version)
+		if current == nil {
+			writeHandler(w, http.StatusCreated, handler)
+		} else {
+			writeHandler(w, http.StatusOK, handler)
+		}
+	case "DELETE":
+		if current == nil {
+			writeHandlerApiError(w, http.StatusNotFound, "unknown handler "+tenant+"/"+name)
+			return
+		}
+		if !checkHandlerVersion(w, r, current) {
+			return
+		}
+		err := os.Remove(file)
+		if err != nil {
+			ctx.Log().Error("error_type", "handler_api", "cause", "delete_failed", "file", file, "tenant", tenant, "name", name, "error", err.Error())
+			writeHandlerApiError(w, http.StatusInternalServerError, "error deleting handler")
+			return
+		}
+		Gctx.AddConfigValue(EelHandlerFactory, GetHandlerFactory(Gctx).WithHandler(ctx, tenant, name, nil))
+		ctx.Log().Info("action", "deleted_handler", "file", file, "tenant", tenant, "name", name, "version", current.Version)
+		fmt.Fprintf(w, `{"status":"ok"}`)
+	default:
+		writeHandlerApiError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// findHandlerFile returns file and configuration of the named handler in the tenant folder, including inactive handlers.
+func findHandlerFile(ctx Context, tenant string, name string) (string, *HandlerConfiguration) {
+	hf := GetHandlerFactory(ctx)
+	for _, folder := range HandlerPaths {
+		for _, file := range hf.getAllConfigurationFiles(ctx, folder) {
+			if filepath.Base(filepath.Dir(file)) != tenant {
+				continue
+			}
+			buf, err := ioutil.ReadFile(file)
+			if err != nil {
+				continue
+			}
+			var handler HandlerConfiguration
+			if json.Unmarshal(buf, &handler) != nil || handler.Name != name {
+				continue
+			}
+			handler.File = file
+			handler.TenantId = tenant
+			return file, &handler
+		}
+	}
+	return "", nil
+}
+
+// checkHandlerVersion verifies the If-Match and If-None-Match headers against the current handler and writes an error
+// response if the precondition fails.
+func checkHandlerVersion(w http.ResponseWriter, r *http.Request, current *HandlerConfiguration) bool {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	ifNoneMatch := strings.TrimSpace(r.Header.Get("If-None-Match"))
+	if current == nil {
+		if ifMatch != "" && ifMatch != "*" {
+			writeHandlerApiError(w, http.StatusPreconditionFailed, "handler does not exist")
+			return false
+		}
+		return true
+	}
+	if ifNoneMatch == "*" {
+		writeHandlerApiError(w, http.StatusPreconditionFailed, "handler already exists")
+		return false
+	}
+	if ifMatch == "" {
+		writeHandlerApiError(w, http.StatusPreconditionRequired, "missing If-Match header with current version "+current.Version)
+		return false
+	}
+	if ifMatch != "*" && strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`) != current.Version {
+		writeHandlerApiError(w, http.StatusPreconditionFailed, "version mismatch, current version is "+current.Version)
+		return false
+	}
+	return true
+}
+
+func isValidHandlerPathSegment(segment string) bool {
+	return segment != "" && !strings.HasPrefix(segment, ".") && !strings.ContainsAny(segment, `/\`)
+}
+
+func writeHandler(w http.ResponseWriter, status int, handler *HandlerConfiguration) {
+	buf, err := json.MarshalIndent(handler, "", "\t")
+	if err != nil {
+		writeHandlerApiError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.Header().Set("ETag", `"`+handler.Version+`"`)
+	w.WriteHeader(status)
+	w.Write(buf)
+}
+
+func writeHandlerApiError(w http.ResponseWriter, status int, msg string) {
+	w.WriteHeader(status)
+	buf, _ := json.Marshal(map[string]string{"error": msg})
+	w.Write(buf)
+}
@@ -22,8 +22,8 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 
 	. "github.com/Comcast/eel/util"
@@ -50,6 +50,8 @@ type (
 		Transformations           map[string]*Transformation // optional - named transformations, used by transform() function
 		// custom properties
 		CustomProperties map[string]interface{} // optional - overrides custom properties in config.json, in addition, map values can be jpath expessions
+		// variables
+		Variables []*Variable // optional - evaluated once per event before the transformation in declaration order, used by var() function
 		// filtering by pattern
 		Filter                    map[string]interface{} // optional - only forward event if event matches this pattern (by path or by example)
 		IsFilterByExample         bool                   // optional - choose syntax style by path or by example for event filtering
@@ -63,16 +65,17 @@ type (
 		// outgoing HTTP config
 		Path        interface{}       // relative path added to endpoint URL, if array of multiple paths, event will be fanned out to endpoint/path1, endpoint/path2 etc.
 		Verb        string            // otpional - HTTP verb like PUT, POST
-		AuthInfo    map[string]string // optional - to overwrite default auth info, example: {"type":"basic","username":"foo","password":"bar"}
+		AuthInfo    map[string]string // optional - to overwrite default auth info, example: {"type":"basic","username":"foo","password":"bar"} or {"type":"bearer","token":"{{jwtsign(...)}}"}
 		Protocol    string            // optional - if omitted defaults to http, other valid values: x1, emo, email, sms (the protocol in the match section, if present, is just a meaningless custom match value!)
 		Endpoint    interface{}       // optional - overwrite default endpoint from config.json, if array of multiple endpoints, event will be fanned out to endpoint1, endpoint2 etc.
 		HttpHeaders map[string]string // optional - http headers
 		// extra publisher config
 		PublisherConfigs map[string]string //optional - any extra publisher configuration parameters should go here
 		// internal pre-compiled configs
-		t *JDoc // transformation
-		f *JDoc // filter
-		m *JDoc // match
+		t *JDoc                 // transformation
+		f *JDoc                 // filter
+		m *JDoc                 // match
+		e map[string]*JExprItem // compiled jpath expressions
 	}
 	handlerMatchInstance struct {
 		handler  *HandlerConfiguration
@@ -99,6 +102,8 @@ func newHandlerMatchInstance(handler *HandlerConfiguration, strength int) *handl
 
 // ReloadConfig reloads config.json as well as all handler configs from disk.
 func ReloadConfig() {
+	handlerMutex.Lock()
+	defer handlerMutex.Unlock()
 	config := GetConfigFromFile(Gctx)
 	Gctx.Log().Info("action", "load_config", "config", *config)
 	Gctx.AddConfigValue(EelConfig, config)
@@ -145,46 +150,93 @@ func NewHandlerFactory(ctx Context, configFolders []string) (*HandlerFactory, []
 			handler, w := GetHandlerConfigurationFromFile(ctx, configFile)
 			warnings = append(warnings, w...)
 			if handler != nil && handler.Active {
-				if handler.Topic != "" {
-					// if is topic handler
-					if _, ok := hf.TopicHandlerMap[handler.TenantId]; !ok {
-						hf.TopicHandlerMap[handler.TenantId] = make(map[string][]*HandlerConfiguration)
-					}
-					if _, ok := hf.TopicHandlerMap[handler.TenantId][handler.Topic]; !ok {
-						hf.TopicHandlerMap[handler.TenantId][handler.Topic] = make([]*HandlerConfiguration, 0)
-					}
-					ctx.Log().Info("action", "registering_topic_handler", "tenant", handler.TenantId, "name", handler.Name, "topic", handler.Topic)
-					hf.TopicHandlerMap[handler.TenantId][handler.Topic] = append(hf.TopicHandlerMap[handler.TenantId][handler.Topic], handler)
-				} else {
-					// custom match handler or default handler (formerly known as notification handler)
-					if _, ok := hf.CustomHandlerMap[handler.TenantId]; !ok {
-						hf.CustomHandlerMap[handler.TenantId] = make(map[string]*HandlerConfiguration, 0)
-					}
-					hf.CustomHandlerMap[handler.TenantId][handler.Name] = handler
-					ctx.Log().Info("action", "registering_handler", "tenant", handler.TenantId, "name", handler.Name, "match", handler.Match)
-				}
+				hf.addHandler(ctx, handler)
 				tenantMap[handler.TenantId] = true
 			}
 		}
 	}
 	if tenantMap["_default"] {
-		// we have default handlers, let's make sure we populate them into every tenant's CustomHandlerMap
-		for tenant, handlerMap := range hf.CustomHandlerMap {
-			if tenant == "_default" {
+		hf.addDefaultHandlers(ctx)
+	}
+	return hf, warnings
+}
+
+// addHandler registers an active handler as topic handler or custom match handler.
+func (hf *HandlerFactory) addHandler(ctx Context, handler *HandlerConfiguration) {
+	if handler.Topic != "" {
+		// if is topic handler
+		if _, ok := hf.TopicHandlerMap[handler.TenantId]; !ok {
+			hf.TopicHandlerMap[handler.TenantId] = make(map[string][]*HandlerConfiguration)
+		}
+		if _, ok := hf.TopicHandlerMap[handler.TenantId][handler.Topic]; !ok {
+			hf.TopicHandlerMap[handler.TenantId][handler.Topic] = make([]*HandlerConfiguration, 0)
+		}
+		ctx.Log().Info("action", "registering_topic_handler", "tenant", handler.TenantId, "name", handler.Name, "topic", handler.Topic)
+		hf.TopicHandlerMap[handler.TenantId][handler.Topic] = append(hf.TopicHandlerMap[handler.TenantId][handler.Topic], handler)
+	} else {
+		// custom match handler or default handler (formerly known as notification handler)
+		if _, ok := hf.CustomHandlerMap[handler.TenantId]; !ok {
+			hf.CustomHandlerMap[handler.TenantId] = make(map[string]*HandlerConfiguration, 0)
+		}
+		hf.CustomHandlerMap[handler.TenantId][handler.Name] = handler
+		ctx.Log().Info("action", "registering_handler", "tenant", handler.TenantId, "name", handler.Name, "match", handler.Match)
+	}
+}
+
+// addDefaultHandlers populates the _default handlers into every tenant's CustomHandlerMap.
+func (hf *HandlerFactory) addDefaultHandlers(ctx Context) {
+	for tenant, handlerMap := range hf.CustomHandlerMap {
+		if tenant == "_default" {
+			continue
+		}
+		for handlerName, handler := range hf.CustomHandlerMap["_default"] {
+			_, ok := handlerMap[handlerName]
+			if ok {
+				// tenant already have the same handler name, don't overwrite
 				continue
 			}
-			for handlerName, handler := range hf.CustomHandlerMap["_default"] {
-				_, ok := handlerMap[handlerName]
-				if ok {
-					// tenant already have the same handler name, don't overwrite
-					continue
+			handlerMap[handlerName] = handler
+			ctx.Log().Info("action", "registering_handler", "tenant", tenant, "name", handler.Name, "match", handler.Match)
+		}
+	}
+}
+
+// WithHandler returns a copy of the handler factory in which the handler with the given tenant and name is replaced by
+// handler. If handler is nil or not active the named handler is removed. The current handler factory is not modified.
+func (hf *HandlerFactory) WithHandler(ctx Context, tenant string, name string, handler *HandlerConfiguration) *HandlerFactory {
+	handlers := make([]*HandlerConfiguration, 0)
+	for _, m1 := range hf.TopicHandlerMap {
+		for _, m2 := range m1 {
+			for _, h := range m2 {
+				if h.TenantId != tenant || h.Name != name {
+					handlers = append(handlers, h)
 				}
-				handlerMap[handlerName] = handler
-				ctx.Log().Info("action", "registering_handler", "tenant", tenant, "name", handler.Name, "match", handler.Match)
 			}
 		}
 	}
-	return hf, warnings
+	for tenantId, m1 := range hf.CustomHandlerMap {
+		for _, h := range m1 {
+			// skip _default handlers populated into other tenants
+			if h.TenantId == tenantId && (h.TenantId != tenant || h.Name != name) {
+				handlers = append(handlers, h)
+			}
+		}
+	}
+	if handler != nil && handler.Active {
+		handlers = append(handlers, handler)
+	}
+	// keep the order in which handlers are loaded from disk
+	sort.SliceStable(handlers, func(i, j int) bool { return handlers[i].File < handlers[j].File })
+	nhf := new(HandlerFactory)
+	nhf.TopicHandlerMap = make(map[string]map[string][]*HandlerConfiguration, 0)
+	nhf.CustomHandlerMap = make(map[string]map[string]*HandlerConfiguration, 0)
+	for _, h := range handlers {
+		nhf.addHandler(ctx, h)
+	}
+	if _, ok := nhf.CustomHandlerMap["_default"]; ok {
+		nhf.addDefaultHandlers(ctx)
+	}
+	return nhf
 }
 
 func (h *HandlerConfiguration) DeepCopy() *HandlerConfiguration {
@@ -212,7 +264,13 @@ func (h *HandlerConfiguration) Save() error {
 		return err
 	}
 	Gctx.Log().Info("file", h.File, "buf", string(buf))
-	return ioutil.WriteFile(h.File, buf, 0644)
+	// write to temp file and rename so that readers never see a partially written handler
+	tmp := h.File + ".tmp"
+	err = ioutil.WriteFile(tmp, buf, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.File)
 }
 
 func (hf *HandlerFactory) getPartialTopicHandlersForTenant(tenantId string, currentTopic string) ([]*HandlerConfiguration, bool) {
@@ -301,8 +359,8 @@ func (hf *HandlerFactory) GetTopicHandlersForTenant(tenantId string, topic strin
 func (h *HandlerConfiguration) matchesChoiceOfValues(ctx Context, event *JDoc, matchMap map[string]interface{}) (bool, int) {
 	numMatches := 0
 	for path, expectedValue := range matchMap {
-		actualVal := event.ParseExpression(ctx, path)
-		expectedVal := event.ParseExpression(ctx, expectedValue)
+		actualVal := h.evalExpression(ctx, event, path)
+		expectedVal := h.evalExpression(ctx, event, expectedValue)
 		switch actualVal.(type) {
 		// support a choice of values (think of an array as a set): if any of the actual array elements matches any of the expected array elements we have a match
 		// also a single flat element can match any elment of an array (both ways, in actual as well as expected value) - we may revisit this behavior in the future
@@ -491,30 +549,79 @@ func (hf *HandlerFactory) GetAllHandlers(ctx Context) []*HandlerConfiguration {
 
 // IsValidTransformation helper function to check if a transformation given as parameter is valid.
 func (h *HandlerConfiguration) IsValidTransformation(ctx Context, t *JDoc, istbe bool) (bool, string) {
+	_, reason, err := h.vetTransformation(t, istbe)
+	return err == nil, reason
+}
+
+// vetTransformation returns the relative json pointer, the expression and the parse error of the first invalid jpath
+// expression in a transformation (or match). Returns blank pointer and reason if the transformation itself is invalid.
+func (h *HandlerConfiguration) vetTransformation(t *JDoc, istbe bool) (string, string, error) {
 	if t == nil {
-		return false, "missing transformation"
+		return "", "missing transformation", errors.New("missing transformation")
 	}
 	if !istbe {
-		o := t.GetOriginalObject()
-		switch o.(type) {
-		case map[string]interface{}:
-			for k, v := range o.(map[string]interface{}) {
-				if reflect.TypeOf(v).Kind() == reflect.String {
-					if !t.IsValidPathExpression(ctx, v.(string)) {
-						return false, v.(string)
-					}
-				}
-				if !t.IsValidPathExpression(ctx, k) {
-					return false, k
+		o, ok := t.GetOriginalObject().(map[string]interface{})
+		if !ok {
+			return "", "transformation not a map", errors.New("transformation not a map")
+		}
+		keys := make([]string, 0, len(o))
+		for k := range o {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if v, ok := o[k].(string); ok {
+				if _, err := NewJExpr(v); err != nil {
+					return "/" + k, v, err
 				}
 			}
-		default:
-			return false, "transformation not a map"
+			if _, err := NewJExpr(k); err != nil {
+				return "/" + k, k, err
+			}
+		}
+		return "", "", nil
+	}
+	return vetExampleValues(t.GetOriginalObject(), "")
+}
+
+// vetExampleValues recursively checks the jpath expressions in the values of a transformation by example. Function call
+// errors are left to compileExpressions.
+func vetExampleValues(v interface{}, pointer string) (string, string, error) {
+	switch v.(type) {
+	case string:
+		if strings.Contains(v.(string), leftMeta) {
+			if _, err := NewJExpr(v.(string)); err != nil && !isFunctionCallError(err) {
+				return pointer, v.(string), err
+			}
+		}
+	case []interface{}:
+		for i, e := range v.([]interface{}) {
+			if p, expr, err := vetExampleValues(e, pointer+"/"+strconv.Itoa(i)); err != nil {
+				return p, expr, err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0)
+		for k := range v.(map[string]interface{}) {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if p, expr, err := vetExampleValues(v.(map[string]interface{})[k], pointer+"/"+k); err != nil {
+				return p, expr, err
+			}
 		}
-	} else {
-		//TODO: validate transformation by example
 	}
-	return true, ""
+	return "", "", nil
+}
+
+// transformationWarning returns a parse diagnostic for an invalid expression in a transformation, match or filter
+// section of a handler config, or a generic parse error if the section itself is invalid.
+func transformationWarning(section string, file string, pointer string, expr string, err error) error {
+	if _, ok := err.(*ExprError); ok {
+		return NewParseDiagnostic(file, pointer, expr, err)
+	}
+	return ParseError{"invalid " + section + " in config file " + file + ": " + expr}
 }
 
 func (hf *HandlerFactory) getAllConfigurationFiles(ctx Context, configFolder string) []string {
@@ -563,6 +670,11 @@ func GetHandlerConfigurationFromFile(ctx Context, filepath string) (*HandlerConf
 	return GetHandlerConfigurationFromJson(ctx, filepath, handler)
 }
 
+// GetHandlerConfigurationFromJson parses and vets handler configuration from a partially populated HandlerConfiguration struct.
+func (hf *HandlerFactory) GetHandlerConfigurationFromJson(ctx Context, filepath string, handler HandlerConfiguration) (*HandlerConfiguration, []error) {
+	return GetHandlerConfigurationFromJson(ctx, filepath, handler)
+}
+
 // GetHandlerConfigurationFromJson parses and vets handler configuration from a partially populated HandlerConfiguration struct.
 func GetHandlerConfigurationFromJson(ctx Context, filepath string, handler HandlerConfiguration) (*HandlerConfiguration, []error) {
 	var err error
@@ -594,10 +706,10 @@ func GetHandlerConfigurationFromJson(ctx Context, filepath string, handler Handl
 			ctx.Log().Error("error_type", "load_handler", "cause", "invalid_transformation", "file", filepath, "name", handler.Name, "tenant", handler.TenantId, "error", err.Error())
 			warnings = append(warnings, ParseError{"non json transformation in config file " + filepath})
 		}
-		valid, reason := handler.IsValidTransformation(ctx, handler.t, handler.IsTransformationByExample)
-		if !valid {
-			ctx.Log().Error("error_type", "load_handler", "cause", "invalid_transformation", "file", filepath, "reason", reason, "name", handler.Name, "tenant", handler.TenantId)
-			warnings = append(warnings, ParseError{"invalid transformation in config file " + filepath + ": " + reason})
+		pointer, reason, err := handler.vetTransformation(handler.t, handler.IsTransformationByExample)
+		if err != nil {
+			ctx.Log().Error("error_type", "load_handler", "cause", "invalid_transformation", "file", filepath, "pointer", "/Transformation"+pointer, "reason", reason, "error", err.Error(), "name", handler.Name, "tenant", handler.TenantId)
+			warnings = append(warnings, transformationWarning("transformation", filepath, "/Transformation"+pointer, reason, err))
 		}
 	}
 	if handler.Transformations != nil {
@@ -608,10 +720,11 @@ func GetHandlerConfigurationFromJson(ctx Context, filepath string, handler Handl
 				warnings = append(warnings, ParseError{"non json transformation " + k + " in config file " + filepath})
 			}
 			v.SetTransformation(tf)
-			valid, reason := handler.IsValidTransformation(ctx, v.GetTransformation(), v.IsTransformationByExample)
-			if !valid {
-				ctx.Log().Error("error_type", "load_handler", "cause", "invalid_transformation", "file", filepath, "reason", reason, "name", handler.Name, "tenant", handler.TenantId)
-				warnings = append(warnings, ParseError{"invalid transformation " + k + " in config file " + filepath + ": " + reason})
+			pointer, reason, err := handler.vetTransformation(v.GetTransformation(), v.IsTransformationByExample)
+			if err != nil {
+				pointer = "/Transformations/" + k + "/Transformation" + pointer
+				ctx.Log().Error("error_type", "load_handler", "cause", "invalid_transformation", "file", filepath, "pointer", pointer, "reason", reason, "error", err.Error(), "name", handler.Name, "tenant", handler.TenantId)
+				warnings = append(warnings, transformationWarning("transformation "+k, filepath, pointer, reason, err))
 			}
 		}
 	}
@@ -621,10 +734,10 @@ func GetHandlerConfigurationFromJson(ctx Context, filepath string, handler Handl
 			ctx.Log().Error("error_type", "load_handler", "cause", "invalid_match", "file", filepath, "name", handler.Name, "tenant", handler.TenantId)
 			warnings = append(warnings, ParseError{"non json match in config file " + filepath})
 		}
-		valid, invalidPath := handler.IsValidTransformation(ctx, handler.m, handler.IsMatchByExample)
-		if !valid {
-			ctx.Log().Error("error_type", "load_handler", "cause", "invalid_match", "file", filepath, "path", invalidPath, "name", handler.Name, "tenant", handler.TenantId)
-			warnings = append(warnings, ParseError{"invalid match in config file " + filepath})
+		pointer, invalidPath, err := handler.vetTransformation(handler.m, handler.IsMatchByExample)
+		if err != nil {
+			ctx.Log().Error("error_type", "load_handler", "cause", "invalid_match", "file", filepath, "pointer", "/Match"+pointer, "path", invalidPath, "error", err.Error(), "name", handler.Name, "tenant", handler.TenantId)
+			warnings = append(warnings, transformationWarning("match", filepath, "/Match"+pointer, invalidPath, err))
 		}
 	}
 	if handler.Filter != nil {
@@ -643,6 +756,22 @@ func GetHandlerConfigurationFromJson(ctx Context, filepath string, handler Handl
 			}
 		}
 	}
+	names := make(map[string]bool, 0)
+	for _, v := range handler.Variables {
+		if v == nil || v.Name == "" {
+			ctx.Log().Error("error_type", "load_handler", "cause", "blank_variable_name", "file", filepath, "name", handler.Name, "tenant", handler.TenantId)
+			warnings = append(warnings, ParseError{"blank variable name in config file " + filepath})
+		} else if names[v.Name] {
+			ctx.Log().Error("error_type", "load_handler", "cause", "duplicate_variable", "file", filepath, "variable", v.Name, "name", handler.Name, "tenant", handler.TenantId)
+			warnings = append(warnings, ParseError{"duplicate variable " + v.Name + " in config file " + filepath})
+		} else {
+			names[v.Name] = true
+		}
+	}
+	for _, w := range handler.compileExpressions(filepath) {
+		ctx.Log().Error("error_type", "load_handler", "cause", "invalid_function_call", "file", filepath, "pointer", w.Pointer, "line", w.Line, "column", w.Column, "reason", w.Message, "expression", w.Expression, "name", handler.Name, "tenant", handler.TenantId)
+		warnings = append(warnings, w)
+	}
 	// default to http protocol if none other specified
 	if handler.Protocol == "" {
 		handler.Protocol = "http"
@@ -658,6 +787,118 @@ func GetHandlerConfigurationFromJson(ctx Context, filepath string, handler Handl
 	return &handler, warnings
 }
 
+// compileExpressions parses all jpath expressions in a handler config once and reports calls to unknown functions or
+// calls with the wrong number of parameters. The ASTs are not evaluated directly, see compiledExpression.
+func (h *HandlerConfiguration) compileExpressions(file string) []ParseDiagnostic {
+	h.e = make(map[string]*JExprItem, 0)
+	diagnostics := make([]ParseDiagnostic, 0)
+	exprs := h.expressions()
+	sorted := make([]string, 0, len(exprs))
+	for expr := range exprs {
+		sorted = append(sorted, expr)
+	}
+	sort.Strings(sorted)
+	for _, expr := range sorted {
+		ast, err := NewJExpr(expr)
+		if err == nil {
+			h.e[expr] = ast
+		} else if isFunctionCallError(err) {
+			diagnostics = append(diagnostics, NewParseDiagnostic(file, exprs[expr], expr, err))
+		}
+	}
+	return diagnostics
+}
+
+// isFunctionCallError returns true for parse errors caused by calls to unknown functions or calls with the wrong
+// number of parameters.
+func isFunctionCallError(err error) bool {
+	return strings.HasPrefix(err.Error(), "unknown function") || strings.HasPrefix(err.Error(), "wrong number of params")
+}
+
+// compiledExpression returns a copy of the pre-compiled AST for the given jpath expression or nil if there is none.
+// Execution collapses the AST it runs on, so every call deep-copies the shared AST. This saves lexing and parsing the
+// expression per event but still allocates one node per AST node, compiled paths are shared by all copies.
+func (h *HandlerConfiguration) compiledExpression(expr string) *JExprItem {
+	if ast, ok := h.e[expr]; ok {
+		return ast.clone(nil)
+	}
+	return nil
+}
+
+// evalExpression evaluates a jpath expression of this handler config, using the pre-compiled AST if available.
+func (h *HandlerConfiguration) evalExpression(ctx Context, doc *JDoc, expr interface{}) interface{} {
+	if s, ok := expr.(string); ok {
+		if ast := h.compiledExpression(s); ast != nil {
+			return ast.Execute(ctx, doc)
+		}
+	}
+	return doc.ParseExpression(ctx, expr)
+}
+
+// expressions returns all strings containing jpath expressions in a handler config with the json pointer of their
+// (first) location.
+func (h *HandlerConfiguration) expressions() map[string]string {
+	exprs := make(map[string]string, 0)
+	collectExpressions(h.Transformation, "/Transformation", exprs)
+	for k, v := range h.Transformations {
+		if v != nil {
+			collectExpressions(v.Transformation, "/Transformations/"+k+"/Transformation", exprs)
+		}
+	}
+	collectExpressions(h.Match, "/Match", exprs)
+	collectExpressions(h.Filter, "/Filter", exprs)
+	for i, f := range h.Filters {
+		if f != nil {
+			collectExpressions(f.Filter, "/Filters/"+strconv.Itoa(i)+"/Filter", exprs)
+			collectExpressions(f.LogParams, "/Filters/"+strconv.Itoa(i)+"/LogParams", exprs)
+		}
+	}
+	collectExpressions(h.FilterIfTrue, "/FilterIfTrue", exprs)
+	collectExpressions(h.FilterIfFalse, "/FilterIfFalse", exprs)
+	collectExpressions(h.CustomProperties, "/CustomProperties", exprs)
+	for i, v := range h.Variables {
+		if v != nil {
+			collectExpressions(v.Value, "/Variables/"+strconv.Itoa(i)+"/Value", exprs)
+		}
+	}
+	collectExpressions(h.Path, "/Path", exprs)
+	collectExpressions(h.Endpoint, "/Endpoint", exprs)
+	collectExpressions(h.HttpHeaders, "/HttpHeaders", exprs)
+	collectExpressions(h.AuthInfo, "/AuthInfo", exprs)
+	collectExpressions(h.PublisherConfigs, "/PublisherConfigs", exprs)
+	return exprs
+}
+
+// collectExpressions recursively collects all strings containing jpath expressions from keys and values of a config
+// section, keeping the smallest json pointer for expressions used more than once.
+func collectExpressions(v interface{}, pointer string, exprs map[string]string) {
+	add := func(expr string, pointer string) {
+		if strings.Contains(expr, leftMeta) {
+			if p, ok := exprs[expr]; !ok || pointer < p {
+				exprs[expr] = pointer
+			}
+		}
+	}
+	switch v.(type) {
+	case string:
+		add(v.(string), pointer)
+	case []interface{}:
+		for i, e := range v.([]interface{}) {
+			collectExpressions(e, pointer+"/"+strconv.Itoa(i), exprs)
+		}
+	case map[string]interface{}:
+		for k, e := range v.(map[string]interface{}) {
+			add(k, pointer+"/"+k)
+			collectExpressions(e, pointer+"/"+k, exprs)
+		}
+	case map[string]string:
+		for k, e := range v.(map[string]string) {
+			add(k, pointer+"/"+k)
+			add(e, pointer+"/"+k)
+		}
+	}
+}
+
 // for main filter and the two boolean filters (deprecated but still in use)
 func (h *HandlerConfiguration) filterEvent(ctx Context, event *JDoc) bool {
 	if h.FilterIfTrue != "" {
@@ -793,6 +1034,17 @@ func (h *HandlerConfiguration) ProcessEvent(ctx Context, event *JDoc) ([]EventPu
 		}
 		ctx.AddValue(EelCustomProperties, cp)
 	}
+	// variables, later variables may reference earlier ones. They are used by the transformation and therefore evaluated
+	// before filters applied after the transformation.
+	vars := make(map[string]interface{}, len(h.Variables))
+	ctx.AddValue(EelVariables, vars)
+	for _, v := range h.Variables {
+		// blank variables are reported when the handler is loaded
+		if v == nil || v.Name == "" {
+			continue
+		}
+		vars[v.Name] = event.ParseExpression(ctx, v.Value)
+	}
 	// apply debug logs
 	debug := h.applyDebugLogsIfWhiteListed(ctx, event, event)
 	if ctx.ConfigValue(EelTraceLogger) != nil {
@@ -805,6 +1057,14 @@ func (h *HandlerConfiguration) ProcessEvent(ctx Context, event *JDoc) ([]EventPu
 			headers[hk] = ToFlatString(event.ParseExpression(ctx, jexpr))
 		}
 	}
+	// prepare auth info
+	var auth map[string]string
+	if h.AuthInfo != nil {
+		auth = make(map[string]string, len(h.AuthInfo))
+		for ak, jexpr := range h.AuthInfo {
+			auth[ak] = ToFlatString(event.ParseExpression(ctx, jexpr))
+		}
+	}
 	debugHeaderKey := GetConfig(ctx).HttpDebugHeader
 	if debug && debugHeaderKey != "" {
 		headers[debugHeaderKey] = "true"
@@ -917,8 +1177,8 @@ func (h *HandlerConfiguration) ProcessEvent(ctx Context, event *JDoc) ([]EventPu
 				ctx.Log().Error("error_type", "process_event", "cause", "unsupported_protocol", "protocol", h.Protocol, "event", event.String(), "handler", h.Name)
 				continue
 			}
-			if h.AuthInfo != nil {
-				publisher.SetAuthInfo(h.AuthInfo)
+			if auth != nil {
+				publisher.SetAuthInfo(auth)
 			}
 			publisher.SetHeaders(headers)
 			publisher.SetPayload(payload)
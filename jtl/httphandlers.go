@@ -22,6 +22,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"sync/atomic"
 	"time"
 
 	. "github.com/Comcast/eel/util"
@@ -50,6 +51,9 @@ func StatusHandler(w http.ResponseWriter, r *http.Request) {
 			callstats["WorkersIdle"+"_"+tenantId] = len(GetWorkDispatcher(ctx, tenantId).WorkerQueue)
 		}
 	}
+	if sq := GetSpillOverQueue(ctx); sq != nil {
+		callstats["SpillOver"] = sq.Stats(ctx)
+	}
 	if ctx.Value(EelTotalStats) != nil {
 		callstats["TotalStats"] = ctx.Value(EelTotalStats)
 	}
@@ -96,10 +100,11 @@ func StatusHandler(w http.ResponseWriter, r *http.Request) {
 	ctx.Log().Info("action", "health", "d1", int64(elapsed1/1e6), "d2", int64(elapsed2/1e6), "d3", int64(elapsed3/1e6), "d4", int64(elapsed4/1e6))
 }
 
-// VetHandler http handler for vetting all handler configurations. Writes JSON with list of warnings (if any) to w.
+// VetHandler http handler for vetting all handler configurations. Writes JSON with list of warnings and lint findings
+// (if any) to w.
 func VetHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	_, warnings := NewHandlerFactory(Gctx, HandlerPaths)
+	warnings := VetHandlers(Gctx, HandlerPaths)
 	if len(warnings) == 0 {
 		fmt.Fprintf(w, `{"status":"ok"}`)
 	} else {
@@ -112,6 +117,48 @@ func VetHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// FunctionsHandler http handler to list all registered jpath functions with their number of parameters.
+func FunctionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	buf, err := json.MarshalIndent(GetFunctionSignatures(), "", "\t")
+	if err != nil {
+		fmt.Fprintf(w, `{"error":"%s"}`, err.Error())
+	} else {
+		fmt.Fprintf(w, string(buf))
+	}
+}
+
+// MetricsHandler http handler for metrics in Prometheus text exposition format. Writes total service stats, work queue
+// fill level and idle workers per tenant and, if the prometheus observer is registered, counters and latency histograms per
+// tenant, handler, topic and destination host.
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := Gctx.SubContext()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if stats, ok := ctx.Value(EelTotalStats).(*ServiceStats); ok {
+		WritePrometheusMetric(w, "eel_in_total", "counter", nil, float64(atomic.LoadUint64(&stats.InCount)))
+		WritePrometheusMetric(w, "eel_out_total", "counter", nil, float64(atomic.LoadUint64(&stats.OutCount)))
+		WritePrometheusMetric(w, "eel_error_total", "counter", nil, float64(atomic.LoadUint64(&stats.ErrorCount)))
+		WritePrometheusMetric(w, "eel_bytes_in_total", "counter", nil, float64(atomic.LoadUint64(&stats.TotalBytesIn)))
+		WritePrometheusMetric(w, "eel_bytes_out_total", "counter", nil, float64(atomic.LoadUint64(&stats.TotalBytesOut)))
+	}
+	for tenantId := range GetConfig(ctx).WorkerPoolSize {
+		if ctx.Value(EelDispatcher+"_"+tenantId) != nil {
+			dp := GetWorkDispatcher(ctx, tenantId)
+			labels := map[string]string{"tenant": tenantId}
+			WritePrometheusMetric(w, "eel_work_queue_fill_level", "gauge", labels, float64(len(dp.WorkQueue)))
+			WritePrometheusMetric(w, "eel_workers_idle", "gauge", labels, float64(len(dp.WorkerQueue)))
+		}
+	}
+	if sq := GetSpillOverQueue(ctx); sq != nil {
+		stats := sq.Stats(ctx)
+		WritePrometheusMetric(w, "eel_spill_over_depth", "gauge", nil, float64(stats.Depth))
+		WritePrometheusMetric(w, "eel_spill_over_oldest_event_age_seconds", "gauge", nil, float64(stats.OldestEventAgeMs)/1000)
+	}
+	if o := GetPrometheusObserver(ctx); o != nil {
+		o.WriteMetrics(w)
+	}
+}
+
 // NilHandler http handler to to do almost nothing.
 func NilHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
@@ -57,7 +57,7 @@ func (p *HttpPublisher) Publish() (string, error) {
 	if p.verb == "" {
 		return "", errors.New("missing verb")
 	}
-	resp, status, err := GetRetrier(p.ctx).RetryEndpoint(p.ctx, p.GetUrl(), p.payload, p.verb, p.headers, nil)
+	resp, status, err := GetRetrier(p.ctx).RetryEndpoint(p.ctx, p.GetUrl(), p.payload, p.verb, p.headers, p.auth)
 	if err != nil {
 		return resp, err
 	}
@@ -138,6 +138,10 @@ func (p *HttpPublisher) SetAuthInfo(auth map[string]string) {
 	p.auth = auth
 }
 
+func (p *HttpPublisher) GetAuthInfo() map[string]string {
+	return p.auth
+}
+
 func (p *HttpPublisher) SetHeaders(headers map[string]string) {
 	p.headers = headers
 }
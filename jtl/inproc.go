@@ -181,7 +181,17 @@ func HandleEvent(ctx Context, w http.ResponseWriter, r *http.Request) error {
 			w.Write(GetResponse(ctx, StatusProcessed))
 			return nil
 		case <-time.After(time.Millisecond * time.Duration(GetConfig(ctx).MessageQueueTimeout)):
-			// consider spilling over to SQS here
+			if sq := GetSpillOverQueue(ctx); sq != nil {
+				err := sq.Push(ctx, string(body))
+				if err == nil {
+					ctx.Log().Info("status", "202", "action", "spilled_over")
+					ctx.Log().Metric("spilled_over", M_Namespace, "xrs", M_Metric, "spilled_over", M_Unit, "Count", M_Dims, "app="+AppId+"&env="+EnvName+"&instance="+InstanceName, M_Val, 1.0)
+					w.WriteHeader(http.StatusAccepted)
+					w.Write(GetResponse(ctx, StatusProcessed))
+					return nil
+				}
+				ctx.Log().Error("error_type", "spill_over_queue", "cause", "push_failed", "error", err.Error())
+			}
 			err := fmt.Errorf("queue_full")
 			ctx.Log().Error("status", "429", "action", "rejected", "error_type", "work_queue", "cause", err)
 			ctx.Log().Metric("rejected", M_Namespace, "xrs", M_Metric, "rejected", M_Unit, "Count", M_Dims, "app="+AppId+"&env="+EnvName+"&instance="+InstanceName, M_Val, 1.0)
@@ -21,7 +21,7 @@ import (
 	"io/ioutil"
 	"reflect"
 	"regexp"
-	"strconv"
+	"sort"
 	"strings"
 
 	. "github.com/Comcast/eel/util"
@@ -47,6 +47,25 @@ type (
 		LogParams                 map[string]string      // extra log parameters
 		f                         *JDoc
 	}
+	// compiledPath is a jpath expression prepared for repeated evaluation, see compilePath.
+	compiledPath struct {
+		path     string
+		segments []pathSegment // nil for simple paths
+		array    bool          // contains array selectors
+		wildcard bool          // contains wildcards, recursive descent or array selectors which may select multiple elements
+	}
+	pathSegment struct {
+		raw      string // trimmed segment, empty for the root and recursive descent
+		key      string // map key without array selector
+		array    bool
+		selector *arraySelector
+		err      error // error parsing the array selector
+	}
+	// Variable is a named value (typically a jpath expression) evaluated once per event, used by var() function.
+	Variable struct {
+		Name  string
+		Value interface{}
+	}
 )
 
 func (t *Transformation) GetTransformation() *JDoc {
@@ -602,6 +621,15 @@ func (j *JDoc) contains(a interface{}, b interface{}, strength int) (bool, int)
 		switch a.(type) {
 		case map[string]interface{}:
 			for k, vb := range b.(map[string]interface{}) {
+				if k == JPathWildcard {
+					// wildcard key matches if any element of the map contains the pattern
+					c, s := j.containsAny(a.(map[string]interface{}), vb)
+					if !c {
+						return false, strength
+					}
+					strength += s
+					continue
+				}
 				c, s := j.contains(a.(map[string]interface{})[k], vb, 0)
 				if !c {
 					return false, strength
@@ -696,6 +724,21 @@ func (j *JDoc) contains(a interface{}, b interface{}, strength int) (bool, int)
 	return true, strength
 }
 
+// containsAny is a helper function to check if b is contained in any element of map a, returns the strongest match.
+func (j *JDoc) containsAny(a map[string]interface{}, b interface{}) (bool, int) {
+	found := false
+	strength := 0
+	for _, va := range a {
+		if c, s := j.contains(va, b, 0); c {
+			found = true
+			if s > strength {
+				strength = s
+			}
+		}
+	}
+	return found, strength
+}
+
 // ListAllPaths list all possible path expressions in document as string slice.
 func (j *JDoc) ListAllPaths() []string {
 	l := make([]string, len(j.pmap))
@@ -716,139 +759,237 @@ func (j *JDoc) HasPath(path string) bool {
 	return false
 }
 
-func (j *JDoc) getChildElement(ctx Context, curr interface{}, segment string, path string) interface{} {
+func (j *JDoc) getChildElement(ctx Context, curr interface{}, segment *pathSegment, path string) interface{} {
 	//TODO: use true array paths
 	//Gctx.Log().Info("segment", segment, "path", path, "curr", curr)
 	if curr == nil {
 		return nil
 	}
-	if !strings.HasSuffix(segment, "]") {
+	if !segment.array {
 		// map element
 		switch curr.(type) {
 		case map[string]interface{}:
-			return curr.(map[string]interface{})[segment]
+			return curr.(map[string]interface{})[segment.key]
 		}
-	} else {
-		switch curr.(type) {
-		case map[string]interface{}:
-			// array element
-			key := segment[:strings.Index(segment, "[")]
-			switch curr.(map[string]interface{})[key].(type) {
-			case []interface{}:
-				idxStr := segment[strings.Index(segment, "[")+1 : len(segment)-1]
-				if strings.Contains(idxStr, "=") {
-					// array element by key selector
-					kv := strings.Split(idxStr, "=")
-					if len(kv) != 2 {
-						ctx.Log().Error("error_type", "parser", "cause", "key_selector_error", "path", path)
-						return nil
-					}
-					k := strings.TrimSpace(kv[0])
-					v := strings.TrimSpace(kv[1])
-					for _, ae := range curr.(map[string]interface{})[key].([]interface{}) {
-						switch ae.(type) {
-						case map[string]interface{}:
-							if ae.(map[string]interface{})[k] == v {
-								return ae
-							}
-						}
-					}
-				} else {
-					// array element by index
-					idx, err := strconv.Atoi(idxStr)
-					if err != nil {
-						ctx.Log().Error("error_type", "parser", "cause", "int_conversion_error", "path", path)
-						return nil
-					}
-					if idx >= len(curr.(map[string]interface{})[key].([]interface{})) {
-						//ctx.Log().Error("error_type", "parser", "cause", "array_length_error", "path", path)
-						return nil
-					}
-					return curr.(map[string]interface{})[key].([]interface{})[idx]
-				}
-			}
-		case []interface{}:
-			idxStr := segment[strings.Index(segment, "[")+1 : len(segment)-1]
-			if strings.Contains(idxStr, "=") {
-				// array element by key selector
-				kv := strings.Split(idxStr, "=")
-				if len(kv) != 2 {
-					ctx.Log().Error("error_type", "parser", "cause", "key_selector_error", "path", path)
-					return nil
-				}
-				k := strings.TrimSpace(kv[0])
-				v := strings.TrimSpace(kv[1])
-				for _, ae := range curr.([]interface{}) {
-					switch ae.(type) {
-					case map[string]interface{}:
-						if ae.(map[string]interface{})[k] == v {
-							return ae
-						}
-					}
-				}
-			} else {
-				// array element by index
-				idx, err := strconv.Atoi(idxStr)
-				if err != nil {
-					ctx.Log().Error("error_type", "parser", "cause", "int_conversion_error", "path", path)
-					return nil
-				}
-				if idx >= len(curr.([]interface{})) {
-					//ctx.Log().Error("error_type", "parser", "cause", "array_length_error", "path", path)
-					return nil
-				}
-				return curr.([]interface{})[idx]
-			}
+		return nil
+	}
+	switch curr.(type) {
+	case map[string]interface{}:
+		// array element
+		curr = curr.(map[string]interface{})[segment.key]
+	}
+	switch curr.(type) {
+	case []interface{}:
+		if segment.err != nil {
+			ctx.Log().Error("error_type", "parser", "cause", "array_selector_error", "path", path, "error", segment.err.Error())
+			return nil
+		}
+		elements := segment.selector.selectElements(ctx, curr.([]interface{}), path)
+		if segment.selector.multi() {
+			return elements
+		}
+		if len(elements) > 0 {
+			return elements[0]
 		}
 	}
 	//ctx.Log.Error("error_type", "parser", "cause", "flat_type_error", "path", path)
 	return nil
 }
 
-// evalArrayPath evaluates a jpath expression containing an array selector.
-// Examples: "/content/deviceId[0]" or "/content/deviceId[foo=bar]" or "/content/deviceId[foo=b/ar]"
-func (j *JDoc) evalArrayPath(ctx Context, path string) interface{} {
+// evalArrayPath evaluates a jpath expression containing an array selector which selects a single element.
+// Examples: "/content/deviceId[0]" or "/content/deviceId[-1]" or "/content/deviceId[foo=bar]" or "/content/deviceId[foo=b/ar]"
+func (j *JDoc) evalArrayPath(ctx Context, cp *compiledPath) interface{} {
 	//TODO: use true array paths
+	var curr interface{}
+	curr = j.orig
+	for i := range cp.segments {
+		if curr == nil {
+			return nil
+		}
+		if cp.segments[i].raw == "" {
+			continue
+		}
+		curr = j.getChildElement(ctx, curr, &cp.segments[i], cp.path)
+	}
+	return curr
+}
+
+// splitPath splits a jpath expression into its segments, slashes inside of array selectors are ignored.
+// An empty segment other than the first and the last one denotes recursive descent.
+// Example: "//items[name=a/b]/*" -> ["", "", "items[name=a/b]", "*"]
+func splitPath(path string) []string {
+	segments := make([]string, 0)
 	inArr := false
 	last := 0
-	segments := make([]string, 0)
 	for pos, c := range path {
 		if c == '[' {
 			inArr = true
 		} else if c == ']' {
 			inArr = false
-		}
-		if c == '/' && !inArr && pos > last {
-			segments = append(segments, path[(last+1):pos])
-			last = pos
-		} else if pos == len(path)-1 && pos-(last) > 0 {
-			segments = append(segments, path[last+1:len(path)])
+		} else if c == '/' && !inArr {
+			segments = append(segments, path[last:pos])
+			last = pos + 1
 		}
 	}
-	var curr interface{}
-	curr = j.orig
-	//Gctx.Log().Info("array_path", path, "segments", segments)
-	for i := 0; i < len(segments); i++ {
-		if curr == nil {
-			return nil
+	return append(segments, path[last:])
+}
+
+// compilePath splits a jpath expression into segments and parses their array selectors once, so that the path can be
+// evaluated repeatedly (for example as part of a compiled handler expression) without parsing it again.
+func compilePath(path string) *compiledPath {
+	cp := &compiledPath{path: path}
+	if !strings.ContainsAny(path, "[]*") && !strings.Contains(path, "//") {
+		// simple path, evaluated by lookup in the flattened document
+		return cp
+	}
+	raw := splitPath(path)
+	cp.segments = make([]pathSegment, len(raw))
+	for i, r := range raw {
+		seg := &cp.segments[i]
+		seg.raw = strings.TrimSpace(r)
+		seg.key = seg.raw
+		if strings.HasSuffix(seg.raw, "]") && strings.Contains(seg.raw, "[") {
+			seg.array = true
+			seg.key = seg.raw[:strings.Index(seg.raw, "[")]
+			seg.selector, seg.err = parseArraySelector(seg.raw[strings.Index(seg.raw, "[")+1 : len(seg.raw)-1])
+			cp.array = true
+		}
+		if seg.raw == JPathWildcard || strings.HasPrefix(seg.raw, JPathWildcard+"[") {
+			cp.wildcard = true
 		}
-		elem := strings.TrimSpace(segments[i])
-		if elem == "" {
+		if seg.raw == "" && i > 0 && i < len(raw)-1 {
+			cp.wildcard = true
+		}
+		if seg.err == nil && seg.selector != nil && seg.selector.multi() {
+			cp.wildcard = true
+		}
+	}
+	return cp
+}
+
+// isWildcardPath checks if a jpath expression contains wildcard segments, recursive descent or array selectors
+// which may select more than one element (array wildcards, slices and predicates).
+func isWildcardPath(path string) bool {
+	return compilePath(path).wildcard
+}
+
+// evalWildcardPath evaluates a jpath expression containing wildcard segments (/items/*/id), array wildcards (/items[*]/sku)
+// or recursive descent (//id). The result is always an array of all selected elements in document order, map
+// elements are visited in order of their keys.
+func (j *JDoc) evalWildcardPath(ctx Context, cp *compiledPath) interface{} {
+	nodes := []interface{}{j.orig}
+	descend := false
+	for i := 1; i < len(cp.segments); i++ {
+		segment := &cp.segments[i]
+		if segment.raw == "" {
+			descend = i < len(cp.segments)-1
 			continue
 		}
-		curr = j.getChildElement(ctx, curr, elem, path)
+		if descend {
+			descendants := make([]interface{}, 0)
+			for _, n := range nodes {
+				descendants = j.descendants(n, descendants)
+			}
+			nodes = descendants
+			descend = false
+		}
+		selected := make([]interface{}, 0)
+		for _, n := range nodes {
+			selected = append(selected, j.selectChildElements(ctx, n, segment, cp.path)...)
+		}
+		nodes = selected
 	}
-	return curr
+	return nodes
+}
+
+// selectChildElements selects all child elements of curr matching a single path segment such as "id", "*", "items[0]",
+// "items[*]", "items[1:3]" or "items[price>10]".
+func (j *JDoc) selectChildElements(ctx Context, curr interface{}, segment *pathSegment, path string) []interface{} {
+	children := make([]interface{}, 0)
+	switch segment.key {
+	case JPathWildcard:
+		children = j.childElements(curr, children)
+	case "":
+		children = append(children, curr)
+	default:
+		switch curr.(type) {
+		case map[string]interface{}:
+			children = append(children, curr.(map[string]interface{})[segment.key])
+		}
+	}
+	result := make([]interface{}, 0, len(children))
+	if !segment.array {
+		for _, c := range children {
+			if c != nil {
+				result = append(result, c)
+			}
+		}
+		return result
+	}
+	if segment.err != nil {
+		ctx.Log().Error("error_type", "parser", "cause", "array_selector_error", "path", path, "error", segment.err.Error())
+		return result
+	}
+	for _, c := range children {
+		switch c.(type) {
+		case []interface{}:
+			for _, e := range segment.selector.selectElements(ctx, c.([]interface{}), path) {
+				if e != nil {
+					result = append(result, e)
+				}
+			}
+		}
+	}
+	return result
+}
+
+// childElements appends all direct child elements of a map (ordered by key) or an array to result.
+func (j *JDoc) childElements(curr interface{}, result []interface{}) []interface{} {
+	switch curr.(type) {
+	case map[string]interface{}:
+		m := curr.(map[string]interface{})
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			result = append(result, m[k])
+		}
+	case []interface{}:
+		result = append(result, curr.([]interface{})...)
+	}
+	return result
 }
 
-// EvalPath evaluates any jpath expression (with or without array selectors).
+// descendants appends curr and all its descendants in document order to result.
+func (j *JDoc) descendants(curr interface{}, result []interface{}) []interface{} {
+	if curr == nil {
+		return result
+	}
+	result = append(result, curr)
+	for _, c := range j.childElements(curr, nil) {
+		result = j.descendants(c, result)
+	}
+	return result
+}
+
+// EvalPath evaluates any jpath expression (with or without array selectors, wildcards or recursive descent).
 // Example path: "/content/deviceId"
 func (j *JDoc) EvalPath(ctx Context, path string) interface{} {
 	//Gctx.Log().Info("path", path, "j.pmap", j.pmap, "value", j.pmap[path])
-	if strings.Contains(path, "[") && strings.Contains(path, "]") {
-		return j.evalArrayPath(ctx, path)
+	return j.evalCompiledPath(ctx, compilePath(path))
+}
+
+// evalCompiledPath evaluates a jpath expression compiled with compilePath.
+func (j *JDoc) evalCompiledPath(ctx Context, cp *compiledPath) interface{} {
+	if cp.wildcard {
+		return j.evalWildcardPath(ctx, cp)
 	}
-	if val, ok := j.pmap[path]; ok {
+	if cp.array {
+		return j.evalArrayPath(ctx, cp)
+	}
+	if val, ok := j.pmap[cp.path]; ok {
 		return val
 	}
 	return nil
@@ -875,14 +1016,22 @@ func (j *JDoc) IsValidPathExpression(ctx Context, expr string) bool {
 }
 
 // ParseExpression parsed and evaluates a given jpath expression. Results are returned as interface.
-// Examples: "{{/content/deviceId}}" or "{{uuid()}}" etc.
+// Examples: "{{/content/deviceId}}" or "{{uuid()}}" etc. Uses the pre-compiled AST of the current handler (if any).
 func (j *JDoc) ParseExpression(ctx Context, expr interface{}) interface{} {
 	if expr == nil {
 		return nil
 	}
 	switch expr.(type) {
 	case string:
-		prsr, _ := NewJExpr(expr.(string))
+		var prsr *JExprItem
+		if ctx != nil {
+			if h := GetCurrentHandlerConfig(ctx); h != nil {
+				prsr = h.compiledExpression(expr.(string))
+			}
+		}
+		if prsr == nil {
+			prsr, _ = NewJExpr(expr.(string))
+		}
 		return prsr.Execute(ctx, j)
 	default:
 		return expr
@@ -0,0 +1,346 @@
+/**
+ * Copyright 2015 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jtl
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	. "github.com/Comcast/eel/util"
+)
+
+// array selectors used in jpath expressions such as /items[0], /items[-1], /items[1:3], /items[*] or /items[price>10 and tag in (a,b)]
+
+type (
+	arraySelectorType int
+	arraySelector     struct {
+		typ   arraySelectorType
+		expr  string
+		index int                // index selector
+		start *int               // slice selector, nil for default
+		end   *int               // slice selector, nil for default
+		step  int                // slice selector
+		or    [][]*predicateTerm // predicate selector as disjunction of conjunctions
+		first bool               // predicate selector with a single key=value (or key==value) term selects first matching element only
+	}
+	predicateTerm struct {
+		key    string   // key of map element, . for the element itself
+		op     string   // =, !=, <, <=, >, >= or in
+		values []string // expected value, list of values for in
+	}
+)
+
+const (
+	selectorIndex arraySelectorType = iota
+	selectorSlice
+	selectorWildcard
+	selectorPredicate
+)
+
+var (
+	selectorIndexReg = regexp.MustCompile(`^-?[0-9]+$`)
+	selectorSliceReg = regexp.MustCompile(`^(-?[0-9]*):(-?[0-9]*)(:(-?[0-9]*))?$`)
+	predicateOps     = []string{" in ", "!=", "<=", ">=", "==", "=", "<", ">"}
+)
+
+// parseArraySelector parses the expression between the brackets of an array selector.
+func parseArraySelector(expr string) (*arraySelector, error) {
+	s := &arraySelector{expr: expr}
+	expr = strings.TrimSpace(expr)
+	switch {
+	case expr == JPathWildcard:
+		s.typ = selectorWildcard
+	case selectorIndexReg.MatchString(expr):
+		s.typ = selectorIndex
+		s.index, _ = strconv.Atoi(expr)
+	case selectorSliceReg.MatchString(expr):
+		s.typ = selectorSlice
+		m := selectorSliceReg.FindStringSubmatch(expr)
+		if m[1] != "" {
+			start, _ := strconv.Atoi(m[1])
+			s.start = &start
+		}
+		if m[2] != "" {
+			end, _ := strconv.Atoi(m[2])
+			s.end = &end
+		}
+		s.step = 1
+		if m[4] != "" {
+			s.step, _ = strconv.Atoi(m[4])
+		}
+		if s.step == 0 {
+			return nil, errors.New("slice step cannot be zero: " + expr)
+		}
+	default:
+		s.typ = selectorPredicate
+		for _, disjunct := range splitPredicate(expr, " or ") {
+			conjunction := make([]*predicateTerm, 0)
+			for _, conjunct := range splitPredicate(disjunct, " and ") {
+				term, err := parsePredicateTerm(conjunct)
+				if err != nil {
+					return nil, err
+				}
+				conjunction = append(conjunction, term)
+			}
+			s.or = append(s.or, conjunction)
+		}
+		s.first = len(s.or) == 1 && len(s.or[0]) == 1 && s.or[0][0].op == "="
+	}
+	return s, nil
+}
+
+// splitPredicate splits a predicate at sep, ignoring separators inside of brackets.
+func splitPredicate(expr string, sep string) []string {
+	parts := make([]string, 0)
+	depth := 0
+	last := 0
+	for i := 0; i < len(expr); i++ {
+		switch expr[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		default:
+			if depth == 0 && strings.HasPrefix(expr[i:], sep) {
+				parts = append(parts, expr[last:i])
+				last = i + len(sep)
+				i = last - 1
+			}
+		}
+	}
+	return append(parts, expr[last:])
+}
+
+// parsePredicateTerm parses a single comparison such as price>10, name!=foo or tag in (a,b).
+func parsePredicateTerm(expr string) (*predicateTerm, error) {
+	pos := -1
+	op := ""
+	for _, o := range predicateOps {
+		if i := strings.Index(expr, o); i >= 0 && (pos < 0 || i < pos) {
+			pos = i
+			op = o
+		}
+	}
+	if pos < 0 {
+		return nil, errors.New("missing operator in predicate: " + expr)
+	}
+	term := &predicateTerm{key: strings.TrimSpace(expr[:pos]), op: strings.TrimSpace(op)}
+	if term.key == "" {
+		return nil, errors.New("missing key in predicate: " + expr)
+	}
+	value := strings.TrimSpace(expr[pos+len(op):])
+	switch term.op {
+	case "==":
+		term.op = "="
+		term.values = []string{value}
+	case "in":
+		if !strings.HasPrefix(value, "(") || !strings.HasSuffix(value, ")") {
+			return nil, errors.New("expected list of values in brackets: " + expr)
+		}
+		for _, v := range strings.Split(value[1:len(value)-1], ",") {
+			term.values = append(term.values, strings.TrimSpace(v))
+		}
+	default:
+		term.values = []string{value}
+	}
+	return term, nil
+}
+
+// multi returns true if the selector may select multiple elements and therefore returns an array.
+func (s *arraySelector) multi() bool {
+	return s.typ == selectorSlice || s.typ == selectorWildcard || (s.typ == selectorPredicate && !s.first)
+}
+
+// selectElements returns the selected elements of array a.
+func (s *arraySelector) selectElements(ctx Context, a []interface{}, path string) []interface{} {
+	result := make([]interface{}, 0)
+	switch s.typ {
+	case selectorIndex:
+		idx := s.index
+		if idx < 0 {
+			idx += len(a)
+		}
+		if idx >= 0 && idx < len(a) {
+			result = append(result, a[idx])
+		}
+	case selectorWildcard:
+		result = append(result, a...)
+	case selectorSlice:
+		start, end := s.sliceBounds(len(a))
+		for i := start; (s.step > 0 && i < end) || (s.step < 0 && i > end); i += s.step {
+			result = append(result, a[i])
+		}
+	case selectorPredicate:
+		for i, e := range a {
+			if s.matches(ctx, e, i, path) {
+				result = append(result, e)
+				if s.first {
+					break
+				}
+			}
+		}
+		tracePath(ctx, fmt.Sprintf("%s: [%s] selected %d of %d elements", path, s.expr, len(result), len(a)))
+	}
+	return result
+}
+
+// sliceBounds returns start and end index of a slice for an array of length n (python semantics).
+func (s *arraySelector) sliceBounds(n int) (int, int) {
+	bound := func(v *int, def int, lower int, upper int) int {
+		if v == nil {
+			return def
+		}
+		i := *v
+		if i < 0 {
+			i += n
+		}
+		if i < lower {
+			return lower
+		}
+		if i > upper {
+			return upper
+		}
+		return i
+	}
+	if s.step > 0 {
+		return bound(s.start, 0, 0, n), bound(s.end, n, 0, n)
+	}
+	return bound(s.start, n-1, -1, n-1), bound(s.end, -1, -1, n-1)
+}
+
+// matches evaluates the predicate for array element e.
+func (s *arraySelector) matches(ctx Context, e interface{}, idx int, path string) bool {
+	for _, conjunction := range s.or {
+		result := true
+		for _, term := range conjunction {
+			actual := term.actual(e)
+			ok := term.eval(actual)
+			tracePath(ctx, fmt.Sprintf("%s: [%s] element %d: %s %s %s with %s=%s is %t", path, s.expr, idx, term.key, term.op, strings.Join(term.values, ","), term.key, ToFlatString(actual), ok))
+			if !ok {
+				result = false
+				break
+			}
+		}
+		if result {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *predicateTerm) actual(e interface{}) interface{} {
+	if t.key == "." {
+		return e
+	}
+	switch e.(type) {
+	case map[string]interface{}:
+		return e.(map[string]interface{})[t.key]
+	}
+	return nil
+}
+
+func (t *predicateTerm) eval(actual interface{}) bool {
+	switch t.op {
+	case "in":
+		for _, v := range t.values {
+			if c, ok := compareSelectorValues(actual, v); ok && c == 0 {
+				return true
+			}
+		}
+		return false
+	case "!=":
+		c, ok := compareSelectorValues(actual, t.values[0])
+		return !ok || c != 0
+	}
+	c, ok := compareSelectorValues(actual, t.values[0])
+	if !ok {
+		return false
+	}
+	if _, isBool := actual.(bool); isBool && t.op != "=" {
+		return false
+	}
+	switch t.op {
+	case "=":
+		return c == 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
+
+// compareSelectorValues compares an actual value with an expected value given as string. Numbers (and numeric strings)
+// are compared numerically, booleans are only comparable for equality and everything else is compared as string.
+// Returns false if the values are not comparable, e.g. if a number is expected but the actual value is not a number.
+func compareSelectorValues(actual interface{}, expected string) (int, bool) {
+	if actual == nil {
+		return 0, false
+	}
+	var a float64
+	isNumber := true
+	switch actual.(type) {
+	case int:
+		a = float64(actual.(int))
+	case float64:
+		a = actual.(float64)
+	case string:
+		var err error
+		a, err = strconv.ParseFloat(actual.(string), 64)
+		isNumber = err == nil
+	default:
+		isNumber = false
+	}
+	if e, err := strconv.ParseFloat(expected, 64); err == nil {
+		if !isNumber {
+			return 0, false
+		}
+		switch {
+		case a < e:
+			return -1, true
+		case a > e:
+			return 1, true
+		}
+		return 0, true
+	}
+	switch actual.(type) {
+	case bool:
+		e, err := strconv.ParseBool(expected)
+		if err != nil || e != actual.(bool) {
+			return 1, err == nil
+		}
+		return 0, true
+	}
+	return strings.Compare(ToFlatString(actual), expected), true
+}
+
+// tracePath adds an explanation of a path evaluation step to the path trace in the context (if tracing is enabled).
+func tracePath(ctx Context, msg string) {
+	if ctx == nil {
+		return
+	}
+	if trace, ok := ctx.Value(EelPathTrace).(*[]string); ok {
+		*trace = append(*trace, msg)
+	}
+}
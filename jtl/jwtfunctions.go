@@ -0,0 +1,401 @@
+/**
+ * Copyright 2015 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jtl
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	. "github.com/Comcast/eel/util"
+)
+
+// signature algorithms supported by jwtverify() and jwtsign()
+const (
+	jwtHS256 = "HS256"
+	jwtRS256 = "RS256"
+	jwtES256 = "ES256"
+)
+
+func init() {
+	// claims or header of a json web token without verification: jwtdecode('<token>', ['claims'|'header'])
+	RegisterTypedFunction("jwtdecode", fnJwtDecode, 1, 2, ResultObject)
+	// claims of a json web token after checking signature, exp and nbf: jwtverify('<token>', '<key reference>')
+	RegisterTypedFunction("jwtverify", fnJwtVerify, 2, 2, ResultObject)
+	// signed json web token: jwtsign('<claims>', '<key reference>', ['HS256'|'RS256'|'ES256'], ['<kid>'])
+	RegisterTypedFunction("jwtsign", fnJwtSign, 2, 4, ResultString)
+}
+
+// jwtError reports an error of a jwt function. Parameters are never logged since they contain tokens or keys.
+func jwtError(ctx Context, op string, cause string, msg string) {
+	stats := ctx.Value(EelTotalStats).(*ServiceStats)
+	ctx.Log().Error("error_type", "func_"+op, "op", op, "cause", cause)
+	stats.IncErrors()
+	AddError(ctx, RuntimeError{fmt.Sprintf("%s in call to %s function", msg, op), op, nil})
+}
+
+// jwtParamCount checks the number of parameters of a jwt function without logging them.
+func jwtParamCount(ctx Context, op string, params []string, min int, max int) bool {
+	if params != nil && len(params) >= min && len(params) <= max {
+		return true
+	}
+	stats := ctx.Value(EelTotalStats).(*ServiceStats)
+	ctx.Log().Error("error_type", "func_"+op, "op", op, "cause", "wrong_number_of_parameters")
+	stats.IncErrors()
+	AddError(ctx, SyntaxError{fmt.Sprintf("wrong number of parameters in call to %s function", op), op, nil})
+	return false
+}
+
+// jwtParts splits a compact json web token into its decoded header, claims and signature.
+func jwtParts(token string) (map[string]interface{}, map[string]interface{}, []byte, error) {
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return nil, nil, nil, errors.New("token must have three parts")
+	}
+	var header, claims map[string]interface{}
+	for i, v := range []*map[string]interface{}{&header, &claims} {
+		buf, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil {
+			return nil, nil, nil, errors.New("token is not base64url encoded")
+		}
+		if err := json.Unmarshal(buf, v); err != nil || *v == nil {
+			return nil, nil, nil, errors.New("token header and claims must be json objects")
+		}
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, nil, nil, errors.New("token signature is not base64url encoded")
+	}
+	return header, claims, sig, nil
+}
+
+// fnJwtDecode returns the claims (default) or the header of a json web token without verifying it. Use jwtverify()
+// when the claims are used for any kind of authorization.
+func fnJwtDecode(ctx Context, doc *JDoc, params []string) interface{} {
+	if !jwtParamCount(ctx, "jwtdecode", params, 1, 2) {
+		return nil
+	}
+	header, claims, _, err := jwtParts(extractStringParam(params[0]))
+	if err != nil {
+		jwtError(ctx, "jwtdecode", "invalid_token", "invalid token: "+err.Error())
+		return nil
+	}
+	part := "claims"
+	if len(params) == 2 {
+		part = strings.ToLower(strings.TrimSpace(extractStringParam(params[1])))
+	}
+	switch part {
+	case "claims":
+		return claims
+	case "header":
+		return header
+	}
+	stats := ctx.Value(EelTotalStats).(*ServiceStats)
+	ctx.Log().Error("error_type", "func_jwtdecode", "op", "jwtdecode", "cause", "unknown_part", "part", part)
+	stats.IncErrors()
+	AddError(ctx, SyntaxError{fmt.Sprintf("unknown part %s in call to jwtdecode function, expected claims or header", part), "jwtdecode", nil})
+	return nil
+}
+
+// fnJwtVerify checks the signature of a json web token as well as its exp and nbf claims and returns the claims. The key
+// reference (env:NAME, file:/path or a key obtained with prop()) resolves to a JWKS, a single JWK, a PEM encoded public
+// key or certificate, or the shared secret for HS256. The algorithm in the token header must match the type of the key,
+// so a token signed with a shared secret is never accepted for a public key.
+func fnJwtVerify(ctx Context, doc *JDoc, params []string) interface{} {
+	if !jwtParamCount(ctx, "jwtverify", params, 2, 2) {
+		return nil
+	}
+	token := strings.TrimSpace(extractStringParam(params[0]))
+	header, claims, sig, err := jwtParts(token)
+	if err != nil {
+		jwtError(ctx, "jwtverify", "invalid_token", "invalid token: "+err.Error())
+		return nil
+	}
+	secret, ok := secretParam(ctx, "jwtverify", params, 1)
+	if !ok {
+		return nil
+	}
+	alg, _ := header["alg"].(string)
+	kid, _ := header["kid"].(string)
+	keys, err := jwtVerificationKeys(secret, kid)
+	if err != nil {
+		jwtError(ctx, "jwtverify", "invalid_key", "invalid key: "+err.Error())
+		return nil
+	}
+	signingInput := []byte(token[:strings.LastIndex(token, ".")])
+	verified := false
+	for _, key := range keys {
+		if err = jwtVerifySignature(alg, key, signingInput, sig); err == nil {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		jwtError(ctx, "jwtverify", "invalid_signature", "token not verified: "+err.Error())
+		return nil
+	}
+	now := float64(time.Now().Unix())
+	if exp, ok := claims["exp"].(float64); ok && now >= exp {
+		jwtError(ctx, "jwtverify", "token_expired", "token expired")
+		return nil
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now < nbf {
+		jwtError(ctx, "jwtverify", "token_not_yet_valid", "token not yet valid")
+		return nil
+	}
+	return claims
+}
+
+// jwtVerificationKeys parses the candidate verification keys: a JWKS (filtered by kid if the token has one), a single
+// JWK, a PEM encoded public key or certificate, or otherwise the shared secret itself.
+func jwtVerificationKeys(secret string, kid string) ([]interface{}, error) {
+	s := strings.TrimSpace(secret)
+	switch {
+	case strings.HasPrefix(s, "{"):
+		var jwks struct {
+			Keys []map[string]interface{} `json:"keys"`
+		}
+		if err := json.Unmarshal([]byte(s), &jwks); err != nil {
+			return nil, errors.New("key is not valid json")
+		}
+		if jwks.Keys == nil {
+			var jwk map[string]interface{}
+			json.Unmarshal([]byte(s), &jwk)
+			jwks.Keys = []map[string]interface{}{jwk}
+		}
+		keys := make([]interface{}, 0)
+		err := fmt.Errorf("no key with kid %s", kid)
+		for _, jwk := range jwks.Keys {
+			if id, _ := jwk["kid"].(string); kid != "" && id != "" && id != kid {
+				continue
+			}
+			// skip keys of unsupported types, other keys in the set may still match
+			var key interface{}
+			if key, err = parseJwk(jwk); err == nil {
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) == 0 {
+			return nil, err
+		}
+		return keys, nil
+	case strings.HasPrefix(s, "-----BEGIN"):
+		block, _ := pem.Decode([]byte(s))
+		if block == nil {
+			return nil, errors.New("invalid pem block")
+		}
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			return []interface{}{key}, err
+		case "RSA PUBLIC KEY":
+			key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+			return []interface{}{key}, err
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{cert.PublicKey}, nil
+		}
+		return nil, fmt.Errorf("unsupported pem block %s", block.Type)
+	}
+	return []interface{}{[]byte(secret)}, nil
+}
+
+// parseJwk parses a RSA, P-256 or symmetric (oct) json web key.
+func parseJwk(jwk map[string]interface{}) (interface{}, error) {
+	param := func(name string) ([]byte, error) {
+		s, _ := jwk[name].(string)
+		buf, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+		if err != nil || len(buf) == 0 {
+			return nil, fmt.Errorf("invalid jwk parameter %s", name)
+		}
+		return buf, nil
+	}
+	switch jwk["kty"] {
+	case "RSA":
+		n, err := param("n")
+		if err != nil {
+			return nil, err
+		}
+		e, err := param("e")
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if jwk["crv"] != "P-256" {
+			return nil, fmt.Errorf("unsupported jwk curve %v", jwk["crv"])
+		}
+		x, err := param("x")
+		if err != nil {
+			return nil, err
+		}
+		y, err := param("y")
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("invalid jwk point")
+		}
+		return key, nil
+	case "oct":
+		return param("k")
+	}
+	return nil, fmt.Errorf("unsupported jwk key type %v", jwk["kty"])
+}
+
+// jwtVerifySignature verifies the signature of a token with a key matching the algorithm.
+func jwtVerifySignature(alg string, key interface{}, signingInput []byte, sig []byte) error {
+	sum := sha256.Sum256(signingInput)
+	switch alg {
+	case jwtHS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return errors.New("HS256 requires a shared secret")
+		}
+		h := hmac.New(sha256.New, secret)
+		h.Write(signingInput)
+		if !hmac.Equal(h.Sum(nil), sig) {
+			return errors.New("signature mismatch")
+		}
+		return nil
+	case jwtRS256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("RS256 requires a RSA key")
+		}
+		if rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig) != nil {
+			return errors.New("signature mismatch")
+		}
+		return nil
+	case jwtES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve != elliptic.P256() {
+			return errors.New("ES256 requires a P-256 key")
+		}
+		if len(sig) != 64 || !ecdsa.Verify(pub, sum[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+			return errors.New("signature mismatch")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm %s", alg)
+}
+
+// fnJwtSign returns a compact json web token for a json object of claims. HS256 (default) uses the shared secret the key
+// reference resolves to, RS256 and ES256 a PEM encoded private key. An optional kid is added to the token header.
+func fnJwtSign(ctx Context, doc *JDoc, params []string) interface{} {
+	if !jwtParamCount(ctx, "jwtsign", params, 2, 4) {
+		return nil
+	}
+	claims := []byte(extractStringParam(params[0]))
+	var obj map[string]interface{}
+	var buf bytes.Buffer
+	if json.Unmarshal(claims, &obj) != nil || obj == nil || json.Compact(&buf, claims) != nil {
+		jwtError(ctx, "jwtsign", "json_object_expected", "claims must be a json object")
+		return nil
+	}
+	alg := jwtHS256
+	if len(params) >= 3 {
+		alg = strings.ToUpper(strings.TrimSpace(extractStringParam(params[2])))
+	}
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if len(params) == 4 {
+		header["kid"] = extractStringParam(params[3])
+	}
+	secret, ok := secretParam(ctx, "jwtsign", params, 1)
+	if !ok {
+		return nil
+	}
+	h, _ := json.Marshal(header)
+	signingInput := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(buf.Bytes())
+	sig, err := jwtSignature(alg, secret, []byte(signingInput))
+	if err != nil {
+		jwtError(ctx, "jwtsign", "invalid_key", err.Error())
+		return nil
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// jwtSignature signs the signing input of a token with a shared secret or a PEM encoded private key.
+func jwtSignature(alg string, secret string, signingInput []byte) ([]byte, error) {
+	if alg == jwtHS256 {
+		if strings.HasPrefix(strings.TrimSpace(secret), "-----BEGIN") {
+			return nil, errors.New("HS256 requires a shared secret, not a pem key")
+		}
+		h := hmac.New(sha256.New, []byte(secret))
+		h.Write(signingInput)
+		return h.Sum(nil), nil
+	}
+	if alg != jwtRS256 && alg != jwtES256 {
+		return nil, fmt.Errorf("unsupported algorithm %s", alg)
+	}
+	block, _ := pem.Decode([]byte(strings.TrimSpace(secret)))
+	if block == nil {
+		return nil, fmt.Errorf("%s requires a pem encoded private key", alg)
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		err = fmt.Errorf("unsupported pem block %s", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(signingInput)
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		if alg == jwtRS256 {
+			return rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:])
+		}
+	case *ecdsa.PrivateKey:
+		if alg == jwtES256 && k.Curve == elliptic.P256() {
+			r, s, err := ecdsa.Sign(rand.Reader, k, sum[:])
+			if err != nil {
+				return nil, err
+			}
+			sig := make([]byte, 64)
+			r.FillBytes(sig[:32])
+			s.FillBytes(sig[32:])
+			return sig, nil
+		}
+	}
+	return nil, fmt.Errorf("%s requires a matching private key", alg)
+}
@@ -0,0 +1,232 @@
+/**
+ * Copyright 2015 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jtl
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/Comcast/eel/util"
+	"github.com/segmentio/kafka-go"
+)
+
+type (
+	// KafkaMessage is a single record consumed from or produced to a kafka topic.
+	KafkaMessage struct {
+		Topic     string
+		Partition int
+		Offset    int64
+		Key       []byte
+		Value     []byte
+		Headers   map[string]string
+	}
+	// KafkaConsumer is the minimal consumer group client needed by the kafka plugin.
+	KafkaConsumer interface {
+		// FetchMessage blocks until the next message is available or ctx is done.
+		FetchMessage(ctx context.Context) (*KafkaMessage, error)
+		// CommitMessage commits the offset of msg (and therefore of all previous messages in the same partition) for the consumer group.
+		CommitMessage(ctx context.Context, msg *KafkaMessage) error
+		Close() error
+	}
+	// KafkaProducer is the minimal producer client needed by the kafka publisher.
+	KafkaProducer interface {
+		// Produce synchronously writes messages and returns an error if any of them could not be delivered.
+		Produce(ctx context.Context, msgs ...*KafkaMessage) error
+	}
+	// KafkaDialer creates kafka clients, can be replaced with SetKafkaDialer, for example with an InMemoryKafkaBroker for testing.
+	KafkaDialer interface {
+		NewConsumer(ctx Context, brokers []string, groupId string, topics []string) (KafkaConsumer, error)
+		// GetProducer returns a producer for the given brokers and required acks (0 - none, 1 - leader, -1 - all),
+		// producers may be shared and must not be closed by the caller.
+		GetProducer(ctx Context, brokers []string, acks int) (KafkaProducer, error)
+	}
+	kafkaGoDialer struct {
+		producers map[string]*kafkaGoProducer
+		sync.Mutex
+	}
+	kafkaGoConsumer struct {
+		reader *kafka.Reader
+	}
+	kafkaGoProducer struct {
+		writer *kafka.Writer
+	}
+	// kafkaOffsetTracker keeps track of in-flight messages per partition so that an offset is only committed
+	// once the message and all messages before it in the same partition have been handled.
+	kafkaOffsetTracker struct {
+		sync.Mutex
+		partitions map[string][]*kafkaTrackedMessage
+	}
+	kafkaTrackedMessage struct {
+		msg  *KafkaMessage
+		done bool
+	}
+)
+
+var kafkaDialer KafkaDialer = &kafkaGoDialer{producers: make(map[string]*kafkaGoProducer, 0)}
+var kafkaDialerMutex sync.RWMutex
+
+// SetKafkaDialer replaces the kafka client implementation used by the kafka plugin.
+func SetKafkaDialer(dialer KafkaDialer) {
+	kafkaDialerMutex.Lock()
+	defer kafkaDialerMutex.Unlock()
+	kafkaDialer = dialer
+}
+
+// GetKafkaDialer returns the kafka client implementation currently in use.
+func GetKafkaDialer() KafkaDialer {
+	kafkaDialerMutex.RLock()
+	defer kafkaDialerMutex.RUnlock()
+	return kafkaDialer
+}
+
+func (d *kafkaGoDialer) NewConsumer(ctx Context, brokers []string, groupId string, topics []string) (KafkaConsumer, error) {
+	c := new(kafkaGoConsumer)
+	c.reader = kafka.NewReader(kafka.ReaderConfig{
+		Brokers:     brokers,
+		GroupID:     groupId,
+		GroupTopics: topics,
+		// commit synchronously, offsets are only committed once events have been handled
+		CommitInterval: 0,
+	})
+	return c, nil
+}
+
+func (c *kafkaGoConsumer) FetchMessage(ctx context.Context) (*KafkaMessage, error) {
+	m, err := c.reader.FetchMessage(ctx)
+	if err != nil {
+		return nil, err
+	}
+	msg := &KafkaMessage{
+		Topic:     m.Topic,
+		Partition: m.Partition,
+		Offset:    m.Offset,
+		Key:       m.Key,
+		Value:     m.Value,
+		Headers:   make(map[string]string, len(m.Headers)),
+	}
+	for _, h := range m.Headers {
+		msg.Headers[h.Key] = string(h.Value)
+	}
+	return msg, nil
+}
+
+func (c *kafkaGoConsumer) CommitMessage(ctx context.Context, msg *KafkaMessage) error {
+	return c.reader.CommitMessages(ctx, kafka.Message{Topic: msg.Topic, Partition: msg.Partition, Offset: msg.Offset})
+}
+
+func (c *kafkaGoConsumer) Close() error {
+	return c.reader.Close()
+}
+
+func (d *kafkaGoDialer) GetProducer(ctx Context, brokers []string, acks int) (KafkaProducer, error) {
+	d.Lock()
+	defer d.Unlock()
+	key := strings.Join(brokers, ",") + "/" + strconv.Itoa(acks)
+	if p, ok := d.producers[key]; ok {
+		return p, nil
+	}
+	p := new(kafkaGoProducer)
+	p.writer = &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequiredAcks(acks),
+		MaxAttempts:  GetConfig(ctx).MaxAttempts,
+		WriteTimeout: time.Duration(GetConfig(ctx).HttpTimeout) * time.Millisecond,
+	}
+	d.producers[key] = p
+	return p, nil
+}
+
+func (p *kafkaGoProducer) Produce(ctx context.Context, msgs ...*KafkaMessage) error {
+	kmsgs := make([]kafka.Message, 0, len(msgs))
+	for _, msg := range msgs {
+		km := kafka.Message{Topic: msg.Topic, Key: msg.Key, Value: msg.Value}
+		for k, v := range msg.Headers {
+			km.Headers = append(km.Headers, kafka.Header{Key: k, Value: []byte(v)})
+		}
+		kmsgs = append(kmsgs, km)
+	}
+	return p.writer.WriteMessages(ctx, kmsgs...)
+}
+
+func newKafkaOffsetTracker() *kafkaOffsetTracker {
+	t := new(kafkaOffsetTracker)
+	t.partitions = make(map[string][]*kafkaTrackedMessage, 0)
+	return t
+}
+
+func (t *kafkaOffsetTracker) key(msg *KafkaMessage) string {
+	return msg.Topic + "/" + strconv.Itoa(msg.Partition)
+}
+
+// add registers a fetched message as in-flight, messages must be added in the order they were fetched.
+func (t *kafkaOffsetTracker) add(msg *KafkaMessage) {
+	t.Lock()
+	defer t.Unlock()
+	k := t.key(msg)
+	t.partitions[k] = append(t.partitions[k], &kafkaTrackedMessage{msg: msg})
+}
+
+// done marks a message as handled and returns the last message that can safely be committed, or nil if
+// earlier messages in the same partition are still in flight.
+func (t *kafkaOffsetTracker) done(msg *KafkaMessage) *KafkaMessage {
+	t.Lock()
+	defer t.Unlock()
+	k := t.key(msg)
+	pending := t.partitions[k]
+	for _, tm := range pending {
+		if tm.msg == msg {
+			tm.done = true
+			break
+		}
+	}
+	var commit *KafkaMessage
+	for len(pending) > 0 && pending[0].done {
+		commit = pending[0].msg
+		pending = pending[1:]
+	}
+	t.partitions[k] = pending
+	return commit
+}
+
+// getStringListParam reads a plugin parameter given either as JSON array or as comma separated string.
+func getStringListParam(params map[string]interface{}, key string) []string {
+	list := make([]string, 0)
+	if params == nil {
+		return list
+	}
+	switch params[key].(type) {
+	case string:
+		for _, s := range strings.Split(params[key].(string), ",") {
+			if strings.TrimSpace(s) != "" {
+				list = append(list, strings.TrimSpace(s))
+			}
+		}
+	case []interface{}:
+		for _, s := range params[key].([]interface{}) {
+			if ToFlatString(s) != "" {
+				list = append(list, ToFlatString(s))
+			}
+		}
+	case []string:
+		list = append(list, params[key].([]string)...)
+	}
+	return list
+}
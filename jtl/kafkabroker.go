@@ -0,0 +1,190 @@
+/**
+ * Copyright 2015 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jtl
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	. "github.com/Comcast/eel/util"
+)
+
+type (
+	// InMemoryKafkaBroker is a minimal in-process stand-in for a kafka cluster, intended for testing without a real broker.
+	// Every topic has a single partition. Consumers of the same group share one read position, and when the last consumer of a
+	// group is closed the position falls back to the last committed offset, so uncommitted messages are delivered again.
+	InMemoryKafkaBroker struct {
+		sync.Mutex
+		topics map[string][]*KafkaMessage
+		groups map[string]*inMemoryKafkaGroup
+		notify chan bool
+		err    error
+	}
+	inMemoryKafkaGroup struct {
+		committed map[string]int64 // topic -> next offset to be consumed after restart
+		position  map[string]int64 // topic -> next offset to be fetched
+		consumers int
+	}
+	inMemoryKafkaProducer struct {
+		broker *InMemoryKafkaBroker
+	}
+	inMemoryKafkaConsumer struct {
+		broker  *InMemoryKafkaBroker
+		groupId string
+		topics  []string
+		closed  chan bool
+		once    sync.Once
+	}
+)
+
+var errKafkaConsumerClosed = errors.New("kafka consumer closed")
+
+// NewInMemoryKafkaBroker creates an empty in-memory broker, use SetKafkaDialer to make EEL use it.
+func NewInMemoryKafkaBroker() *InMemoryKafkaBroker {
+	b := new(InMemoryKafkaBroker)
+	b.topics = make(map[string][]*KafkaMessage, 0)
+	b.groups = make(map[string]*inMemoryKafkaGroup, 0)
+	b.notify = make(chan bool)
+	return b
+}
+
+// Produce appends messages to their topics and assigns offsets.
+func (b *InMemoryKafkaBroker) Produce(msgs ...*KafkaMessage) {
+	b.Lock()
+	defer b.Unlock()
+	for _, msg := range msgs {
+		m := *msg
+		m.Partition = 0
+		m.Offset = int64(len(b.topics[m.Topic]))
+		b.topics[m.Topic] = append(b.topics[m.Topic], &m)
+	}
+	close(b.notify)
+	b.notify = make(chan bool)
+}
+
+// SetProduceError makes all subsequent produce requests of producers obtained from this broker fail with err, use nil to reset.
+func (b *InMemoryKafkaBroker) SetProduceError(err error) {
+	b.Lock()
+	defer b.Unlock()
+	b.err = err
+}
+
+// Messages returns all messages of a topic.
+func (b *InMemoryKafkaBroker) Messages(topic string) []*KafkaMessage {
+	b.Lock()
+	defer b.Unlock()
+	msgs := make([]*KafkaMessage, len(b.topics[topic]))
+	copy(msgs, b.topics[topic])
+	return msgs
+}
+
+// CommittedOffset returns the next offset a restarted consumer group would read from a topic.
+func (b *InMemoryKafkaBroker) CommittedOffset(groupId string, topic string) int64 {
+	b.Lock()
+	defer b.Unlock()
+	if g, ok := b.groups[groupId]; ok {
+		return g.committed[topic]
+	}
+	return 0
+}
+
+// NewConsumer implements KafkaDialer.
+func (b *InMemoryKafkaBroker) NewConsumer(ctx Context, brokers []string, groupId string, topics []string) (KafkaConsumer, error) {
+	b.Lock()
+	defer b.Unlock()
+	g, ok := b.groups[groupId]
+	if !ok {
+		g = &inMemoryKafkaGroup{make(map[string]int64, 0), make(map[string]int64, 0), 0}
+		b.groups[groupId] = g
+	}
+	if g.consumers == 0 {
+		// first consumer of the group resumes from the last committed offsets
+		g.position = make(map[string]int64, len(g.committed))
+		for t, o := range g.committed {
+			g.position[t] = o
+		}
+	}
+	g.consumers++
+	c := &inMemoryKafkaConsumer{broker: b, groupId: groupId, topics: topics, closed: make(chan bool)}
+	return c, nil
+}
+
+// GetProducer implements KafkaDialer.
+func (b *InMemoryKafkaBroker) GetProducer(ctx Context, brokers []string, acks int) (KafkaProducer, error) {
+	return &inMemoryKafkaProducer{b}, nil
+}
+
+func (p *inMemoryKafkaProducer) Produce(ctx context.Context, msgs ...*KafkaMessage) error {
+	p.broker.Lock()
+	err := p.broker.err
+	p.broker.Unlock()
+	if err != nil {
+		return err
+	}
+	p.broker.Produce(msgs...)
+	return nil
+}
+
+func (c *inMemoryKafkaConsumer) FetchMessage(ctx context.Context) (*KafkaMessage, error) {
+	for {
+		c.broker.Lock()
+		g := c.broker.groups[c.groupId]
+		for _, t := range c.topics {
+			if g.position[t] < int64(len(c.broker.topics[t])) {
+				m := *c.broker.topics[t][g.position[t]]
+				g.position[t]++
+				c.broker.Unlock()
+				return &m, nil
+			}
+		}
+		notify := c.broker.notify
+		c.broker.Unlock()
+		select {
+		case <-notify:
+		case <-c.closed:
+			return nil, errKafkaConsumerClosed
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (c *inMemoryKafkaConsumer) CommitMessage(ctx context.Context, msg *KafkaMessage) error {
+	select {
+	case <-c.closed:
+		return errKafkaConsumerClosed
+	default:
+	}
+	c.broker.Lock()
+	defer c.broker.Unlock()
+	g := c.broker.groups[c.groupId]
+	if msg.Offset+1 > g.committed[msg.Topic] {
+		g.committed[msg.Topic] = msg.Offset + 1
+	}
+	return nil
+}
+
+func (c *inMemoryKafkaConsumer) Close() error {
+	c.once.Do(func() {
+		close(c.closed)
+		c.broker.Lock()
+		c.broker.groups[c.groupId].consumers--
+		c.broker.Unlock()
+	})
+	return nil
+}
@@ -0,0 +1,239 @@
+/**
+ * Copyright 2015 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jtl
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/Comcast/eel/util"
+)
+
+// KafkaPlugin consumes events from one or more kafka topics as member of a consumer group and places them on the
+// worker pool queue of the tenant given in the message headers. Offsets are only committed after an event has been
+// handled (including publishing), so events may be delivered more than once but are not lost on restart.
+//
+// Parameters:
+//   Brokers - list of broker addresses (JSON array or comma separated string)
+//   Topics  - list of topics to consume (JSON array or comma separated string)
+//   GroupId - consumer group id
+type KafkaPlugin struct {
+	Settings     *PluginSettings
+	shuttingDown int32 // accessed atomically, the consumer goroutine reads it while StopPlugin sets it
+	consumer     KafkaConsumer
+	cancel       context.CancelFunc
+	done         chan bool
+	sync.Mutex
+}
+
+func NewKafkaPlugin(settings *PluginSettings) InboundPlugin {
+	p := new(KafkaPlugin)
+	p.Settings = settings
+	return p
+}
+
+func (p *KafkaPlugin) GetSettings() *PluginSettings {
+	return p.Settings
+}
+
+func (p *KafkaPlugin) StartPlugin(ctx Context) {
+	p.Lock()
+	defer p.Unlock()
+	ctx.Log().Info("action", "starting_plugin", "op", "kafka")
+	brokers := getStringListParam(p.Settings.Parameters, "Brokers")
+	topics := getStringListParam(p.Settings.Parameters, "Topics")
+	groupId := ""
+	if p.Settings.Parameters != nil && p.Settings.Parameters["GroupId"] != nil {
+		groupId = ToFlatString(p.Settings.Parameters["GroupId"])
+	}
+	if len(topics) == 0 || groupId == "" {
+		ctx.Log().Error("error_type", "kafka_consumer_error", "cause", "missing_topics_or_group_id", "op", "kafka", "topics", topics, "group_id", groupId)
+		return
+	}
+	consumer, err := GetKafkaDialer().NewConsumer(ctx, brokers, groupId, topics)
+	if err != nil {
+		ctx.Log().Error("error_type", "kafka_consumer_error", "cause", "new_consumer", "op", "kafka", "error", err.Error())
+		return
+	}
+	cctx, cancel := context.WithCancel(context.Background())
+	p.consumer = consumer
+	p.cancel = cancel
+	p.done = make(chan bool)
+	atomic.StoreInt32(&p.shuttingDown, 0)
+	p.Settings.Active = true
+	go p.startKafkaConsumer(ctx, cctx, consumer, p.done)
+}
+
+func (p *KafkaPlugin) startKafkaConsumer(ctx Context, cctx context.Context, consumer KafkaConsumer, done chan bool) {
+	defer ctx.HandlePanic()
+	defer close(done)
+	stats := ctx.Value(EelTotalStats).(*ServiceStats)
+	tracker := newKafkaOffsetTracker()
+	for {
+		msg, err := consumer.FetchMessage(cctx)
+		if err != nil {
+			if cctx.Err() != nil || atomic.LoadInt32(&p.shuttingDown) == 1 {
+				break
+			}
+			ctx.Log().Error("error_type", "kafka_consumer_error", "cause", "fetch_message", "op", "kafka", "error", err.Error())
+			select {
+			case <-cctx.Done():
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+		tracker.add(msg)
+		commit := func() {
+			if m := tracker.done(msg); m != nil {
+				if err := consumer.CommitMessage(context.Background(), m); err != nil {
+					ctx.Log().Error("error_type", "kafka_consumer_error", "cause", "commit_message", "op", "kafka", "topic", m.Topic, "partition", m.Partition, "offset", m.Offset, "error", err.Error())
+				}
+			}
+		}
+		p.handleMessage(ctx, cctx, stats, msg, commit)
+	}
+	ctx.Log().Info("action", "stopping_plugin", "op", "kafka")
+}
+
+func (p *KafkaPlugin) handleMessage(ctx Context, cctx context.Context, stats *ServiceStats, msg *KafkaMessage, commit func()) {
+	conf := GetConfig(ctx)
+	stats.IncInCount()
+	sctx := ctx.SubContext()
+	header := make(http.Header, len(msg.Headers))
+	for k, v := range msg.Headers {
+		header.Set(k, v)
+	}
+	sctx.AddValue(EelRequestHeader, header)
+	// adopt trace header if present
+	traceHeaderKey := conf.HttpTransactionHeader
+	traceId := header.Get(traceHeaderKey)
+	if traceId == "" {
+		traceId = sctx.Id()
+	}
+	sctx.AddLogValue("tx.traceId", traceId)
+	sctx.AddValue("tx.traceId", traceId)
+	sctx.AddValue(traceHeaderKey, traceId)
+	// adopt tenant id if present
+	tenantId := ""
+	if ctx.Value(EelTenantId) != nil {
+		tenantId = ctx.Value(EelTenantId).(string)
+	}
+	tenantHeaderKey := conf.HttpTenantHeader
+	if header.Get(tenantHeaderKey) != "" {
+		tenantId = header.Get(tenantHeaderKey)
+		sctx.AddValue(EelTenantId, tenantId)
+		sctx.AddValue(tenantHeaderKey, tenantId)
+		sctx.AddLogValue(LogTenantId, ExtractAppId(tenantId, conf.AllowPartner))
+	}
+	// adopt partner id if present
+	partnerHeaderKey := conf.HttpPartnerHeader
+	if header.Get(partnerHeaderKey) != "" {
+		sctx.AddValue(EelPartnerId, header.Get(partnerHeaderKey))
+		sctx.AddValue(partnerHeaderKey, header.Get(partnerHeaderKey))
+		sctx.AddLogValue(LogPartnerId, header.Get(partnerHeaderKey))
+	}
+	sctx.AddLogValue("kafka.topic", msg.Topic)
+	sctx.AddLogValue("kafka.partition", msg.Partition)
+	sctx.AddLogValue("kafka.offset", msg.Offset)
+	body := string(msg.Value)
+	evt, err := NewJDocFromString(body)
+	if err != nil {
+		sctx.Log().Error("error_type", "rejected", "cause", "invalid_json", "error", err.Error(), "trace.in.data", body, "op", "kafka")
+		sctx.Log().Metric("rejected", M_Namespace, "xrs", M_Metric, "rejected", M_Unit, "Count", M_Dims, "app="+AppId+"&env="+EnvName+"&instance="+InstanceName, M_Val, 1.0)
+		stats.IncErrors()
+		// nothing to retry, skip message
+		commit()
+		return
+	}
+	if conf.LogParams != nil {
+		for k, v := range conf.LogParams {
+			ev := evt.ParseExpression(sctx, v)
+			sctx.AddLogValue(k, ev)
+		}
+	}
+	stats.IncBytesIn(len(body))
+	dp := GetWorkDispatcher(sctx, tenantId)
+	if dp == nil {
+		sctx.Log().Error("error_type", "rejected", "cause", "no_work_dispatcher", "op", "kafka", "tenant_id", tenantId)
+		sctx.Log().Metric("rejected", M_Namespace, "xrs", M_Metric, "rejected", M_Unit, "Count", M_Dims, "app="+AppId+"&env="+EnvName+"&instance="+InstanceName, M_Val, 1.0)
+		stats.IncErrors()
+		// there is no worker pool for the tenant, skip message so that later messages of the partition can be committed
+		commit()
+		return
+	}
+	work := WorkRequest{Raw: body, Event: evt, Ctx: sctx, Done: commit}
+	timeOutMS := time.Duration(conf.MessageQueueTimeout)
+	for {
+		// kafka consumers apply back pressure rather than rejecting events when the work queue is full
+		select {
+		case dp.WorkQueue <- &work:
+			sctx.Log().Info("action", "accepted", "op", "kafka")
+			atomic.AddUint64(&p.Settings.Stats.MessageCount, 1)
+			return
+		case <-time.After(time.Millisecond * timeOutMS):
+			sctx.Log().Error("error_type", "work_queue", "action", "waiting", "op", "kafka", "cause", "queue_full")
+		case <-cctx.Done():
+			// not committed, will be delivered again after restart
+			sctx.Log().Info("action", "abandoned", "op", "kafka", "cause", "shutting_down")
+			return
+		}
+	}
+}
+
+// StopFetching stops consuming messages. The consumer is kept open so that offsets of events still in the work queues
+// can be committed once they have been handled, it is closed by StopPlugin.
+func (p *KafkaPlugin) StopFetching(ctx Context) {
+	p.Lock()
+	defer p.Unlock()
+	p.stopFetching(ctx)
+}
+
+func (p *KafkaPlugin) stopFetching(ctx Context) {
+	if p.consumer == nil || atomic.LoadInt32(&p.shuttingDown) == 1 {
+		return
+	}
+	ctx.Log().Info("action", "stop_fetching", "op", "kafka")
+	atomic.StoreInt32(&p.shuttingDown, 1)
+	p.cancel()
+	<-p.done
+}
+
+func (p *KafkaPlugin) StopPlugin(ctx Context) {
+	p.Lock()
+	defer p.Unlock()
+	ctx.Log().Info("action", "shutdown_plugin", "op", "kafka")
+	if p.consumer == nil {
+		return
+	}
+	p.stopFetching(ctx)
+	p.Settings.Active = false
+	err := p.consumer.Close()
+	if err != nil {
+		ctx.Log().Error("error_type", "kafka_consumer_error", "cause", "close", "op", "kafka", "error", err.Error())
+	}
+	p.consumer = nil
+}
+
+// IsActive is synchronized with StartPlugin and StopPlugin which are the only places changing the active state.
+func (p *KafkaPlugin) IsActive() bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.Settings.Active
+}
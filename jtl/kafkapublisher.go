@@ -0,0 +1,206 @@
+/**
+ * Copyright 2015 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jtl
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	. "github.com/Comcast/eel/util"
+)
+
+type (
+	// KafkaPublisher publishes events to a kafka topic. The endpoint is a comma separated list of brokers, optionally
+	// prefixed with kafka://, and HTTP headers are sent as record headers. Publisher configs:
+	//   Topic - name of the topic (required)
+	//   Key   - optional partition key
+	//   Acks  - optional required acks: none, one or all (default)
+	KafkaPublisher struct {
+		endpoint string
+		path     string
+		payload  string
+		protocol string
+		api      string
+		verb     string
+		auth     map[string]string
+		headers  map[string]string
+		event    *JDoc
+		debug    bool
+		ctx      Context
+		configs  map[string]string
+		topic    string
+		key      string
+		acks     int
+	}
+)
+
+const kafkaScheme = "kafka://"
+
+// NewKafkaPublisher creates a new kafka publisher.
+func NewKafkaPublisher(ctx Context) EventPublisher {
+	kp := new(KafkaPublisher)
+	kp.ctx = ctx
+	kp.protocol = "kafka"
+	kp.api = "kafka"
+	kp.acks = -1
+	ctx.AddLogValue("destination", "main_kafka")
+	return kp
+}
+
+func (p *KafkaPublisher) Publish() (string, error) {
+	brokers := p.getBrokers()
+	if len(brokers) == 0 {
+		return "", errors.New("missing endpoint")
+	}
+	if p.topic == "" {
+		return "", errors.New("missing topic")
+	}
+	producer, err := GetKafkaDialer().GetProducer(p.ctx, brokers, p.acks)
+	if err != nil {
+		return "", NetworkError{p.GetUrl(), err.Error(), 0}
+	}
+	headers := make(map[string]string, len(p.headers))
+	for k, v := range p.headers {
+		headers[k] = v
+	}
+	InjectTraceContext(p.ctx, headers)
+	msg := &KafkaMessage{Topic: p.topic, Value: []byte(p.payload), Headers: headers}
+	if p.key != "" {
+		msg.Key = []byte(p.key)
+	}
+	err = producer.Produce(context.Background(), msg)
+	if err != nil {
+		return "", NetworkError{p.GetUrl(), err.Error(), 0}
+	}
+	return "", nil
+}
+
+func (p *KafkaPublisher) getBrokers() []string {
+	brokers := make([]string, 0)
+	for _, b := range strings.Split(strings.TrimPrefix(p.endpoint, kafkaScheme), ",") {
+		b = strings.TrimSuffix(strings.TrimSpace(b), "/")
+		if b != "" {
+			brokers = append(brokers, b)
+		}
+	}
+	return brokers
+}
+
+func (p *KafkaPublisher) GetUrl() string {
+	return kafkaScheme + strings.TrimPrefix(p.endpoint, kafkaScheme) + "/" + p.topic
+}
+
+func (p *KafkaPublisher) GetErrors() []error {
+	return GetErrors(p.ctx)
+}
+
+func (p *KafkaPublisher) SetDebug(debug bool) {
+	p.debug = debug
+}
+
+func (p *KafkaPublisher) GetDebug() bool {
+	return p.debug
+}
+
+func (p *KafkaPublisher) SetPath(path string) {
+	p.path = path
+}
+
+func (p *KafkaPublisher) GetPath() string {
+	return p.path
+}
+
+func (p *KafkaPublisher) SetEndpoint(endpoint string) {
+	p.endpoint = endpoint
+}
+
+func (p *KafkaPublisher) GetEndpoint() string {
+	return p.endpoint
+}
+
+func (p *KafkaPublisher) SetPayload(payload string) {
+	p.payload = payload
+}
+
+func (p *KafkaPublisher) GetPayload() string {
+	return p.payload
+}
+
+func (p *KafkaPublisher) SetVerb(verb string) {
+	p.verb = verb
+}
+
+func (p *KafkaPublisher) GetVerb() string {
+	return p.verb
+}
+
+func (p *KafkaPublisher) GetProtocol() string {
+	return p.protocol
+}
+
+func (p *KafkaPublisher) GetApi() string {
+	return p.api
+}
+
+func (p *KafkaPublisher) SetAuthInfo(auth map[string]string) {
+	p.auth = auth
+}
+
+func (p *KafkaPublisher) GetAuthInfo() map[string]string {
+	return p.auth
+}
+
+func (p *KafkaPublisher) SetHeaders(headers map[string]string) {
+	p.headers = headers
+}
+
+func (p *KafkaPublisher) GetHeaders() map[string]string {
+	return p.headers
+}
+
+func (p *KafkaPublisher) SetPayloadParsed(event *JDoc) {
+	p.event = event
+}
+
+func (p *KafkaPublisher) GetPayloadParsed() *JDoc {
+	return p.event
+}
+
+func (p *KafkaPublisher) SetPublisherConfigs(configs map[string]string) error {
+	p.configs = configs
+	if configs == nil || configs["Topic"] == "" {
+		return errors.New("missing topic in publisher configs")
+	}
+	p.topic = configs["Topic"]
+	p.key = configs["Key"]
+	switch strings.ToLower(configs["Acks"]) {
+	case "", "all", "-1":
+		p.acks = -1
+	case "one", "1":
+		p.acks = 1
+	case "none", "0":
+		p.acks = 0
+	default:
+		return errors.New("invalid acks in publisher configs: " + configs["Acks"])
+	}
+	return nil
+}
+
+func (p *KafkaPublisher) GetPublisherConfigs() map[string]string {
+	return p.configs
+}
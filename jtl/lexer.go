@@ -32,6 +32,7 @@ type stateFn func(*lexer) stateFn
 type lexItem struct {
 	typ lexItemType // type such as lexItemFunction.
 	val string      // value, such as "uuid".
+	pos int         // byte offset of the item in the input.
 }
 
 // lexItemType identifies the type of lex items.
@@ -50,6 +51,10 @@ const (
 	lexItemDot                             // 9 the cursor, spelled '.'
 	lexItemText                            // 10 plain text
 	lexItemInsideText                      // 11
+	lexItemOperator                        // 12 infix or prefix operator such as == or !, also ( and ) for grouping
+	lexItemNumber                          // 13 number literal
+	lexItemString                          // 14 string literal 'foo'
+	lexItemBool                            // 15 boolean literal true or false
 )
 
 func (i *lexItem) typeString() string {
@@ -78,6 +83,14 @@ func (i *lexItem) typeString() string {
 		return "TEXT"
 	case lexItemInsideText:
 		return "INTEXT"
+	case lexItemOperator:
+		return "OPERATOR"
+	case lexItemNumber:
+		return "NUMBER"
+	case lexItemString:
+		return "STRING"
+	case lexItemBool:
+		return "BOOL"
 	default:
 		return "UNKNOWN"
 	}
@@ -94,6 +107,16 @@ const (
 	escapedRightMeta = "$}}"
 )
 
+const (
+	// pathChars are the characters allowed in a path outside of array selector brackets
+	pathChars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890/-_,.:|!@#$%^&*+=<>?[]"
+	// operatorChars are the characters operators are made of
+	operatorChars = "=!<>&|+-*/()"
+)
+
+// operators in order of matching, two character operators must come first
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "+", "-", "*", "/", "!", "(", ")"}
+
 func isWhitespace(ch rune) bool { return ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r' }
 
 func isLetter(ch rune) bool { return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') }
@@ -123,6 +146,7 @@ type lexer struct {
 	pos   int          // current position in the input.
 	width int          // width of last rune read from input.
 	items chan lexItem // channel of scanned items.
+	last  lexItem      // last emitted item.
 }
 
 func lex(name, input string) (*lexer, chan lexItem) {
@@ -155,10 +179,23 @@ func (l *lexer) emit(t lexItemType) {
 	} else if token == escapedRightMeta {
 		token = rightMeta
 	}
-	l.items <- lexItem{t, token}
+	l.last = lexItem{t, token, l.start}
+	l.items <- l.last
 	l.start = l.pos
 }
 
+// afterOperand returns true if the last emitted item completes an operand, in which case a following / is a division
+// rather than the start of a path.
+func (l *lexer) afterOperand() bool {
+	switch l.last.typ {
+	case lexItemPath, lexItemDot, lexItemNumber, lexItemString, lexItemBool, lexItemRightBracket:
+		return true
+	case lexItemOperator:
+		return l.last.val == ")"
+	}
+	return false
+}
+
 func lexText(l *lexer) stateFn {
 	for {
 		if strings.HasPrefix(l.input[l.pos:], leftMeta) {
@@ -179,7 +216,7 @@ func lexText(l *lexer) stateFn {
 			return lexText
 		}
 		if strings.HasPrefix(l.input[l.pos:], rightMeta) {
-			return l.errorf("unexpected closing action at %d: %s\n", l.pos, l.input)
+			return l.errorf("unexpected closing action")
 		}
 		// escaped right meta
 		if strings.HasPrefix(l.input[l.pos:], escapedRightMeta) {
@@ -212,7 +249,7 @@ func lexLeftMeta(l *lexer) stateFn {
 		l.emit(lexItemLeftMeta)
 		return lexInsideAction
 	}
-	return l.errorf("expected left meta at %d: %s\n", l.pos, l.input)
+	return l.errorf("expected left meta")
 }
 
 func lexRightMeta(l *lexer) stateFn {
@@ -220,13 +257,55 @@ func lexRightMeta(l *lexer) stateFn {
 		l.emit(lexItemRightMeta)
 		return lexText
 	}
-	return l.errorf("expected right meta at %d: %s\n", l.pos, l.input)
+	return l.errorf("expected right meta")
 }
 
 func lexPath(l *lexer) stateFn {
-	l.acceptRun("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ1234567890/-_,.:|!@#$%^&*+=<>?[] ")
+	depth := 0 // nesting level of array selector brackets
+	for {
+		r := l.peek()
+		if r == eof {
+			break
+		}
+		if depth > 0 {
+			// array selectors may contain predicates such as [tag in (a,b) and price>10]
+			if r == '[' {
+				depth++
+			} else if r == ']' {
+				depth--
+			} else if !strings.ContainsRune(pathChars, r) && !strings.ContainsRune("() ", r) {
+				break
+			}
+			l.next()
+			continue
+		}
+		if r == ' ' && endsPath(l.input[l.pos:]) {
+			break
+		}
+		if r == '[' {
+			depth++
+		} else if r != ' ' && !strings.ContainsRune(pathChars, r) {
+			break
+		}
+		l.next()
+	}
 	l.emit(lexItemPath)
-	return lexRightMeta
+	return lexInsideAction
+}
+
+// endsPath returns true if the white space at the beginning of rest terminates a path, that is if it is followed by
+// the end of the action, a closing bracket or a binary operator surrounded by white space.
+func endsPath(rest string) bool {
+	t := strings.TrimLeft(rest, " \t")
+	if t == "" || strings.HasPrefix(t, rightMeta) || strings.HasPrefix(t, ")") {
+		return true
+	}
+	for _, op := range operators {
+		if op != "!" && op != "(" && op != ")" && strings.HasPrefix(t, op) && len(t) > len(op) && isWhitespace(rune(t[len(op)])) {
+			return true
+		}
+	}
+	return false
 }
 
 func lexParam(l *lexer) stateFn {
@@ -236,11 +315,11 @@ func lexParam(l *lexer) stateFn {
 		// recursive calls to the lexer by the parser - this may seem a bit unorthodox
 		// but it works very well
 		if bc < 0 {
-			return l.errorf("unbalanced brackets at %d: %s\n", l.pos, l.input)
+			return l.errorf("unbalanced brackets")
 		}
 		switch r := l.next(); {
 		case r == eof:
-			return l.errorf("unclosed param at %d: %s\n", l.pos, l.input)
+			return l.errorf("unclosed param")
 		case r == '{':
 			bc++
 			break
@@ -263,7 +342,7 @@ func lexOpenParamList(l *lexer) stateFn {
 	if l.next() == '(' {
 		l.emit(lexItemLeftBracket)
 	} else {
-		l.errorf("missing opening bracket at %d: %s\n", l.pos, l.input)
+		l.errorf("missing opening bracket")
 	}
 	return lexParamList
 }
@@ -272,13 +351,13 @@ func lexParamList(l *lexer) stateFn {
 	for {
 		switch r := l.next(); {
 		case r == eof:
-			return l.errorf("unclosed function at %d: %s\n", l.pos, l.input)
+			return l.errorf("unclosed function")
 		case isWhitespace(r):
 			l.ignore()
 			break
 		case r == ')':
 			l.emit(lexItemRightBracket)
-			return lexRightMeta
+			return lexInsideAction
 		case r == ',':
 			l.ignore()
 			break
@@ -295,25 +374,88 @@ func lexParamList(l *lexer) stateFn {
 
 func lexInsideAction(l *lexer) stateFn {
 	for {
+		if strings.HasPrefix(l.input[l.pos:], rightMeta) {
+			if l.last.typ == lexItemLeftMeta {
+				return l.errorf("empty action")
+			}
+			return lexRightMeta
+		}
 		switch r := l.next(); {
 		case r == eof:
-			return l.errorf("unclosed action at %d: %s\n", l.pos, l.input)
+			return l.errorf("unclosed action")
 		case isWhitespace(r):
 			l.ignore()
-		case r == '/':
+		case r == '/' && !l.afterOperand():
 			l.backup()
 			return lexPath
-		case r == '.':
+		case r == '.' && !l.afterOperand():
 			l.emit(lexItemDot)
-			return lexRightMeta
-		case r == '(':
+		case isDigit(r):
 			l.backup()
-			l.emit(lexItemFunction)
-			return lexOpenParamList
-		case r == '}':
-			l.errorf("illegal path at %d: %s\n", l.pos, l.input)
+			return lexNumber
+		case r == '\'':
+			return lexString
+		case isLetter(r):
+			l.backup()
+			return lexIdentifier
+		case strings.ContainsRune(operatorChars, r):
+			l.backup()
+			return lexOperator
+		default:
+			return l.errorf("illegal character %q", r)
+		}
+	}
+}
+
+// lexIdentifier scans a function name (followed by its parameter list) or a boolean literal.
+func lexIdentifier(l *lexer) stateFn {
+	for r := l.next(); isAlphaNumeric(r) || r == '_'; r = l.next() {
+	}
+	l.backup()
+	if l.peek() == '(' {
+		l.emit(lexItemFunction)
+		return lexOpenParamList
+	}
+	switch l.input[l.start:l.pos] {
+	case "true", "false":
+		l.emit(lexItemBool)
+		return lexInsideAction
+	}
+	return l.errorf("unknown identifier %s", l.input[l.start:l.pos])
+}
+
+func lexNumber(l *lexer) stateFn {
+	l.acceptRun("0123456789")
+	if l.accept(".") {
+		l.acceptRun("0123456789")
+	}
+	l.emit(lexItemNumber)
+	return lexInsideAction
+}
+
+func lexString(l *lexer) stateFn {
+	for {
+		switch r := l.next(); {
+		case r == eof:
+			return l.errorf("unclosed string")
+		case r == '\\':
+			l.next()
+		case r == '\'':
+			l.emit(lexItemString)
+			return lexInsideAction
+		}
+	}
+}
+
+func lexOperator(l *lexer) stateFn {
+	for _, op := range operators {
+		if strings.HasPrefix(l.input[l.pos:], op) {
+			l.pos += len(op)
+			l.emit(lexItemOperator)
+			return lexInsideAction
 		}
 	}
+	return l.errorf("unknown operator")
 }
 
 // next returns the next rune in the input.
@@ -362,13 +504,14 @@ func (l *lexer) acceptRun(valid string) {
 	l.backup()
 }
 
-// errorf returns an error token and terminates the scan
+// errorf returns an error token positioned at the start of the current item and terminates the scan
 // by passing back a nil pointer that will be the next
 // state, terminating l.run.
 func (l *lexer) errorf(format string, args ...interface{}) stateFn {
 	l.items <- lexItem{
 		lexItemError,
 		fmt.Sprintf(format, args...),
+		l.start,
 	}
 	return nil
 }
@@ -0,0 +1,205 @@
+/**
+ * Copyright 2015 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jtl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	. "github.com/Comcast/eel/util"
+)
+
+// linter walks the compiled AST of a single handler expression and collects findings.
+type linter struct {
+	ctx         Context
+	h           *HandlerConfiguration
+	pointer     string
+	expr        string
+	diagnostics []ParseDiagnostic
+}
+
+// VetHandlers loads all handler configs in the config folders and returns the warnings reported while loading them
+// together with the findings of the lint pass for each handler.
+func VetHandlers(ctx Context, configFolders []string) []error {
+	if configFolders == nil {
+		configFolders = []string{ConfigPath}
+	}
+	warnings := make([]error, 0)
+	hf := new(HandlerFactory)
+	for _, folder := range configFolders {
+		for _, configFile := range hf.getAllConfigurationFiles(ctx, folder) {
+			handler, w := GetHandlerConfigurationFromFile(ctx, configFile)
+			warnings = append(warnings, w...)
+			if handler != nil {
+				for _, d := range handler.Lint(ctx) {
+					warnings = append(warnings, d)
+				}
+			}
+		}
+	}
+	return warnings
+}
+
+// Lint walks the compiled ASTs of all expressions in a loaded handler config and reports ifte conditions that can never
+// be boolean, transform(), map(), filter() and count() calls naming a transformation missing from Transformations,
+// prop() keys that are neither in the CustomProperties of the handler nor in config.json, var() names missing from
+// Variables and keys passed inline to hmac(), jwtverify() and jwtsign(). Unknown functions and wrong number of
+// parameters are already reported when the handler is loaded.
+func (h *HandlerConfiguration) Lint(ctx Context) []ParseDiagnostic {
+	diagnostics := make([]ParseDiagnostic, 0)
+	exprs := h.expressions()
+	sorted := make([]string, 0, len(exprs))
+	for expr := range exprs {
+		sorted = append(sorted, expr)
+	}
+	sort.Strings(sorted)
+	for _, expr := range sorted {
+		ast := h.e[expr]
+		if ast == nil {
+			continue
+		}
+		l := &linter{ctx: ctx, h: h, pointer: exprs[expr], expr: expr}
+		l.walk(ast)
+		diagnostics = append(diagnostics, l.diagnostics...)
+	}
+	return diagnostics
+}
+
+func (l *linter) walk(a *JExprItem) {
+	if a.typ == astFunction && len(a.kids) > 0 {
+		switch a.val {
+		case "ifte":
+			if neverBoolean(a.kids[0]) {
+				l.report(a.kids[0], CheckIfteCondition, "ifte condition can never be boolean")
+			}
+		case "transform", "itransform":
+			if name, ok := literalParam(a.kids[0]); ok && (l.h.Transformations == nil || l.h.Transformations[name] == nil) {
+				l.report(a.kids[0], CheckUnknownTransformation, fmt.Sprintf("unknown transformation %s", name))
+			}
+		case "map", "filter", "count":
+			if len(a.kids) == 2 {
+				if name, ok := literalParam(a.kids[1]); ok && !l.isElementSpec(name, a.val != "map") {
+					l.report(a.kids[1], CheckUnknownTransformation, fmt.Sprintf("unknown transformation %s", name))
+				}
+			}
+		case "hmac":
+			if len(a.kids) >= 3 {
+				if key, ok := literalParam(a.kids[2]); ok && !isSecretReference(key) {
+					l.report(a.kids[2], CheckInlineSecret, "inline hmac key, use prop() or a secret reference such as env:NAME or file:/path")
+				}
+			}
+		case "jwtverify", "jwtsign":
+			if len(a.kids) >= 2 {
+				if key, ok := literalParam(a.kids[1]); ok && !isSecretReference(key) {
+					l.report(a.kids[1], CheckInlineSecret, fmt.Sprintf("inline %s key, use prop() or a secret reference such as env:NAME or file:/path", a.val))
+				}
+			}
+		case "prop":
+			if key, ok := literalParam(a.kids[0]); ok && !l.hasProperty(key) {
+				l.report(a.kids[0], CheckUnknownProperty, fmt.Sprintf("unknown property %s", key))
+			}
+		case "var":
+			if name, ok := literalParam(a.kids[0]); ok && !l.hasVariable(name) {
+				l.report(a.kids[0], CheckUnknownVariable, fmt.Sprintf("unknown variable %s", name))
+			}
+		}
+	}
+	for _, k := range a.kids {
+		l.walk(k)
+	}
+}
+
+func (l *linter) report(a *JExprItem, check string, msg string) {
+	d := NewParseDiagnostic(l.h.File, l.pointer, l.expr, &ExprError{Message: msg, Expr: l.expr, Pos: a.pos})
+	d.Check = check
+	l.diagnostics = append(l.diagnostics, d)
+}
+
+// isElementSpec returns true if spec is a path, an escaped expression, a named transformation or, if predicates are
+// allowed, a predicate as accepted by map(), filter() and count().
+func (l *linter) isElementSpec(spec string, predicates bool) bool {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "/") || strings.Contains(spec, "{{") || l.h.Transformations[spec] != nil {
+		return true
+	}
+	if predicates {
+		s, err := parseArraySelector(spec)
+		return err == nil && s.typ == selectorPredicate
+	}
+	return false
+}
+
+func (l *linter) hasProperty(key string) bool {
+	if _, ok := l.h.CustomProperties[key]; ok {
+		return true
+	}
+	config := GetConfig(l.ctx)
+	return config != nil && config.CustomProperties[key] != nil
+}
+
+func (l *linter) hasVariable(name string) bool {
+	for _, v := range l.h.Variables {
+		if v != nil && v.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// literalParam returns the value of a quoted parameter without any embedded expressions.
+func literalParam(a *JExprItem) (string, bool) {
+	if a.typ != astParam {
+		return "", false
+	}
+	s, ok := a.val.(string)
+	if !ok {
+		return "", false
+	}
+	return extractStringParam(s), true
+}
+
+// neverBoolean returns true if the condition node of an ifte can never evaluate to true or false.
+func neverBoolean(a *JExprItem) bool {
+	if s, ok := literalParam(a); ok {
+		return s != "true" && s != "false"
+	}
+	if a.typ != astAgg {
+		return false
+	}
+	if len(a.kids) == 1 {
+		k := a.kids[0]
+		switch k.typ {
+		case astFunction:
+			result := GetFunctionResult(ToFlatString(k.val))
+			return result != ResultAny && result != ResultBoolean
+		case astOperator:
+			return k.val == "-" || k.val == "*" || k.val == "/"
+		case astText:
+			s, ok := k.val.(string)
+			return ok && s != "true" && s != "false"
+		}
+		return false
+	}
+	// concatenation of literal text and expressions can only be boolean if all literal text is part of true or false
+	for _, k := range a.kids {
+		if s, ok := k.val.(string); ok && k.typ == astText && strings.Trim(s, "truefals") != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -111,6 +111,10 @@ func (p *NullPublisher) SetAuthInfo(auth map[string]string) {
 	p.auth = auth
 }
 
+func (p *NullPublisher) GetAuthInfo() map[string]string {
+	return p.auth
+}
+
 func (p *NullPublisher) SetHeaders(headers map[string]string) {
 	p.headers = headers
 }
@@ -0,0 +1,410 @@
+/**
+ * Copyright 2015 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jtl
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	. "github.com/Comcast/eel/util"
+)
+
+// conflict strategies of deepmerge()
+const (
+	mergeLeftWins     = "left"
+	mergeRightWins    = "right"
+	mergeConcatArrays = "concat"
+)
+
+func init() {
+	// sorted keys of object: keys('<object>')
+	RegisterTypedFunction("keys", fnKeys, 1, 1, ResultArray)
+	// values of object in order of their keys: values('<object>')
+	RegisterTypedFunction("values", fnValues, 1, 1, ResultArray)
+	// array of {"key":...,"value":...} entries in order of keys: entries('<object>')
+	RegisterTypedFunction("entries", fnEntries, 1, 1, ResultArray)
+	// object from array of entries or [key, value] pairs: fromentries('<array>')
+	RegisterTypedFunction("fromentries", fnFromEntries, 1, 1, ResultObject)
+	// copy of document containing only the given paths: pick('<doc>', '<path>', ...)
+	RegisterTypedFunction("pick", fnPick, 2, 100, ResultObject)
+	// copy of document without the given paths: omit('<doc>', '<path>', ...)
+	RegisterTypedFunction("omit", fnOmit, 2, 100, ResultObject)
+	// move values to new paths: rename('<doc>', '{"<old path>":"<new path>", ...}')
+	RegisterTypedFunction("rename", fnRename, 2, 2, ResultObject)
+	// set value at path creating objects as needed: setpath('<doc>', '<path>', '<value>')
+	RegisterTypedFunction("setpath", fnSetPath, 3, 3, ResultObject)
+	// delete value at path: delpath('<doc>', '<path>')
+	RegisterTypedFunction("delpath", fnDelPath, 2, 2, ResultObject)
+	// deterministic deep merge of two documents: deepmerge('<doc>', '<doc>', ['right'|'left'|'concat'])
+	RegisterTypedFunction("deepmerge", fnDeepMerge, 2, 3, ResultObject)
+}
+
+// objectParam parses a json object parameter, reporting an error if the parameter is not an object.
+func objectParam(ctx Context, op string, params []string, param string) (map[string]interface{}, bool) {
+	var m map[string]interface{}
+	err := json.Unmarshal([]byte(extractStringParam(param)), &m)
+	if err != nil || m == nil {
+		stats := ctx.Value(EelTotalStats).(*ServiceStats)
+		ctx.Log().Error("error_type", "func_"+op, "op", op, "cause", "param_not_object", "params", params)
+		stats.IncErrors()
+		AddError(ctx, SyntaxError{fmt.Sprintf("non json object parameters in call to %s function", op), op, params})
+		return nil, false
+	}
+	return m, true
+}
+
+// pathParam splits a simple path such as /a/b/c into its keys, reporting an error for wild cards, array selectors and
+// the root path.
+func pathParam(ctx Context, op string, params []string, path string) ([]string, bool) {
+	path = strings.TrimSpace(path)
+	keys := make([]string, 0)
+	for _, k := range strings.Split(path, "/") {
+		if k != "" {
+			keys = append(keys, k)
+		}
+	}
+	if !strings.HasPrefix(path, "/") || len(keys) == 0 || strings.ContainsAny(path, "[]") || strings.Contains(path, "//") || isWildcardPath(path) {
+		stats := ctx.Value(EelTotalStats).(*ServiceStats)
+		ctx.Log().Error("error_type", "func_"+op, "op", op, "cause", "invalid_path", "params", params, "path", path)
+		stats.IncErrors()
+		AddError(ctx, SyntaxError{fmt.Sprintf("invalid path %s in call to %s function, expected simple path such as /a/b", path, op), op, params})
+		return nil, false
+	}
+	return keys, true
+}
+
+// sortedKeys returns the keys of map m in sorted order.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// getPath returns the value at the path given by keys and true if the path exists.
+func getPath(m map[string]interface{}, keys []string) (interface{}, bool) {
+	var cur interface{} = m
+	for _, k := range keys {
+		cm, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = cm[k]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// setPath sets the value at the path given by keys, creating objects as needed and replacing values along the path
+// which are not objects.
+func setPath(m map[string]interface{}, keys []string, value interface{}) {
+	for _, k := range keys[:len(keys)-1] {
+		next, ok := m[k].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{}, 0)
+			m[k] = next
+		}
+		m = next
+	}
+	m[keys[len(keys)-1]] = value
+}
+
+// deletePath deletes the value at the path given by keys and returns true if it existed.
+func deletePath(m map[string]interface{}, keys []string) bool {
+	parent, ok := getPath(m, keys[:len(keys)-1])
+	if !ok {
+		return false
+	}
+	pm, ok := parent.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	if _, ok = pm[keys[len(keys)-1]]; !ok {
+		return false
+	}
+	delete(pm, keys[len(keys)-1])
+	return true
+}
+
+// fnKeys returns the sorted keys of an object.
+func fnKeys(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "keys", params, 1, 1) {
+		return nil
+	}
+	m, ok := objectParam(ctx, "keys", params, params[0])
+	if !ok {
+		return nil
+	}
+	res := make([]interface{}, 0, len(m))
+	for _, k := range sortedKeys(m) {
+		res = append(res, k)
+	}
+	return res
+}
+
+// fnValues returns the values of an object in order of their keys.
+func fnValues(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "values", params, 1, 1) {
+		return nil
+	}
+	m, ok := objectParam(ctx, "values", params, params[0])
+	if !ok {
+		return nil
+	}
+	res := make([]interface{}, 0, len(m))
+	for _, k := range sortedKeys(m) {
+		res = append(res, m[k])
+	}
+	return res
+}
+
+// fnEntries returns an array of key value pairs of an object in order of their keys.
+func fnEntries(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "entries", params, 1, 1) {
+		return nil
+	}
+	m, ok := objectParam(ctx, "entries", params, params[0])
+	if !ok {
+		return nil
+	}
+	res := make([]interface{}, 0, len(m))
+	for _, k := range sortedKeys(m) {
+		res = append(res, map[string]interface{}{"key": k, "value": m[k]})
+	}
+	return res
+}
+
+// fnFromEntries creates an object from an array of {"key":...,"value":...} entries or [key, value] pairs. Later entries
+// overwrite earlier entries with the same key.
+func fnFromEntries(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "fromentries", params, 1, 1) {
+		return nil
+	}
+	a, ok := arrayParam(ctx, "fromentries", params, params[0])
+	if !ok {
+		return nil
+	}
+	res := make(map[string]interface{}, len(a))
+	for _, e := range a {
+		var key, value interface{}
+		valid := false
+		switch entry := e.(type) {
+		case map[string]interface{}:
+			key, valid = entry["key"]
+			value = entry["value"]
+		case []interface{}:
+			if len(entry) == 2 {
+				key, value, valid = entry[0], entry[1], true
+			}
+		}
+		if !valid || key == nil {
+			stats := ctx.Value(EelTotalStats).(*ServiceStats)
+			ctx.Log().Error("error_type", "func_fromentries", "op", "fromentries", "cause", "invalid_entry", "params", params)
+			stats.IncErrors()
+			AddError(ctx, SyntaxError{fmt.Sprintf("invalid entry %s in call to fromentries function", ToFlatString(e)), "fromentries", params})
+			return nil
+		}
+		res[ToFlatString(key)] = value
+	}
+	return res
+}
+
+// fnPick returns a copy of a document containing only the values at the given paths. Missing paths are ignored.
+func fnPick(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "pick", params, 2, 100) {
+		return nil
+	}
+	m, ok := objectParam(ctx, "pick", params, params[0])
+	if !ok {
+		return nil
+	}
+	res := make(map[string]interface{}, 0)
+	for _, p := range params[1:] {
+		keys, ok := pathParam(ctx, "pick", params, extractStringParam(p))
+		if !ok {
+			return nil
+		}
+		if v, ok := getPath(m, keys); ok {
+			setPath(res, keys, v)
+		}
+	}
+	return res
+}
+
+// fnOmit returns a copy of a document without the values at the given paths. Missing paths are ignored.
+func fnOmit(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "omit", params, 2, 100) {
+		return nil
+	}
+	m, ok := objectParam(ctx, "omit", params, params[0])
+	if !ok {
+		return nil
+	}
+	for _, p := range params[1:] {
+		keys, ok := pathParam(ctx, "omit", params, extractStringParam(p))
+		if !ok {
+			return nil
+		}
+		deletePath(m, keys)
+	}
+	return m
+}
+
+// fnRename moves values to new paths. The mapping is an object of old paths to new paths, keys without leading slash
+// are top level keys. Renames are applied in order of the old paths, missing paths are ignored.
+func fnRename(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "rename", params, 2, 2) {
+		return nil
+	}
+	m, ok := objectParam(ctx, "rename", params, params[0])
+	if !ok {
+		return nil
+	}
+	mapping, ok := objectParam(ctx, "rename", params, params[1])
+	if !ok {
+		return nil
+	}
+	asPath := func(p string) string {
+		if !strings.HasPrefix(p, "/") {
+			return "/" + p
+		}
+		return p
+	}
+	for _, from := range sortedKeys(mapping) {
+		fromKeys, ok := pathParam(ctx, "rename", params, asPath(from))
+		if !ok {
+			return nil
+		}
+		toKeys, ok := pathParam(ctx, "rename", params, asPath(ToFlatString(mapping[from])))
+		if !ok {
+			return nil
+		}
+		if v, ok := getPath(m, fromKeys); ok {
+			deletePath(m, fromKeys)
+			setPath(m, toKeys, v)
+		}
+	}
+	return m
+}
+
+// fnSetPath sets the value at a path creating objects as needed. The value is parsed as json if possible, otherwise
+// it is used as string.
+func fnSetPath(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "setpath", params, 3, 3) {
+		return nil
+	}
+	m, ok := objectParam(ctx, "setpath", params, params[0])
+	if !ok {
+		return nil
+	}
+	keys, ok := pathParam(ctx, "setpath", params, extractStringParam(params[1]))
+	if !ok {
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal([]byte(extractStringParam(params[2])), &value); err != nil {
+		value = extractStringParam(params[2])
+	}
+	setPath(m, keys, value)
+	return m
+}
+
+// fnDelPath deletes the value at a path.
+func fnDelPath(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "delpath", params, 2, 2) {
+		return nil
+	}
+	m, ok := objectParam(ctx, "delpath", params, params[0])
+	if !ok {
+		return nil
+	}
+	keys, ok := pathParam(ctx, "delpath", params, extractStringParam(params[1]))
+	if !ok {
+		return nil
+	}
+	deletePath(m, keys)
+	return m
+}
+
+// fnDeepMerge merges two documents. Objects are always merged recursively, other conflicts are resolved by the
+// strategy: right (default) or left side wins, or concat which concatenates arrays and otherwise lets the right side
+// win. Unlike join() the result does not depend on map iteration order.
+func fnDeepMerge(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "deepmerge", params, 2, 3) {
+		return nil
+	}
+	stats := ctx.Value(EelTotalStats).(*ServiceStats)
+	docs := make([]interface{}, 2)
+	for i := range docs {
+		if err := json.Unmarshal([]byte(extractStringParam(params[i])), &docs[i]); err != nil {
+			ctx.Log().Error("error_type", "func_deepmerge", "op", "deepmerge", "cause", "non_json_parameter", "params", params, "error", err.Error())
+			stats.IncErrors()
+			AddError(ctx, SyntaxError{fmt.Sprintf("non json parameters in call to deepmerge function"), "deepmerge", params})
+			return nil
+		}
+	}
+	strategy := mergeRightWins
+	if len(params) == 3 {
+		strategy = strings.ToLower(strings.TrimSpace(extractStringParam(params[2])))
+	}
+	switch strategy {
+	case mergeLeftWins, mergeRightWins, mergeConcatArrays:
+	default:
+		ctx.Log().Error("error_type", "func_deepmerge", "op", "deepmerge", "cause", "unknown_strategy", "params", params)
+		stats.IncErrors()
+		AddError(ctx, SyntaxError{fmt.Sprintf("unknown strategy %s in call to deepmerge function, expected left, right or concat", strategy), "deepmerge", params})
+		return nil
+	}
+	return deepMerge(docs[0], docs[1], strategy)
+}
+
+func deepMerge(a interface{}, b interface{}, strategy string) interface{} {
+	am, aok := a.(map[string]interface{})
+	bm, bok := b.(map[string]interface{})
+	if aok && bok {
+		res := make(map[string]interface{}, len(am)+len(bm))
+		for k, v := range am {
+			res[k] = v
+		}
+		for k, v := range bm {
+			if av, ok := res[k]; ok {
+				res[k] = deepMerge(av, v, strategy)
+			} else {
+				res[k] = v
+			}
+		}
+		return res
+	}
+	if strategy == mergeConcatArrays {
+		aa, aok := a.([]interface{})
+		ba, bok := b.([]interface{})
+		if aok && bok {
+			res := make([]interface{}, 0, len(aa)+len(ba))
+			res = append(res, aa...)
+			return append(res, ba...)
+		}
+	}
+	if strategy == mergeLeftWins {
+		return a
+	}
+	return b
+}
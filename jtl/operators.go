@@ -0,0 +1,480 @@
+/**
+ * Copyright 2015 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jtl
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+
+	. "github.com/Comcast/eel/util"
+)
+
+// infix and prefix operators used in jpath expressions such as {{/a == 'foo' && /b * 2 > 10}}
+
+// operatorPrecedence binding strength of binary operators, higher binds stronger
+var operatorPrecedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3,
+	"<": 4, "<=": 4, ">": 4, ">=": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6,
+}
+
+// actionParser parses the lex items of a single action {{...}} into an AST using precedence climbing.
+type actionParser struct {
+	items  []lexItem
+	pos    int
+	offset int // offset of the parsed expression in the complete expression
+	end    int // position of the right meta closing the action
+}
+
+// parseAction parses the lex items between left and right meta into an AST sub tree.
+func parseAction(items []lexItem, offset int, end int) (*JExprItem, error) {
+	p := &actionParser{items: items, offset: offset, end: end}
+	if len(items) == 0 {
+		return nil, p.errorf(nil, "empty action")
+	}
+	e, err := p.parseBinary(1)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.items) {
+		return nil, p.errorf(&p.items[p.pos], "unexpected "+p.items[p.pos].val+" in expression")
+	}
+	return e, nil
+}
+
+// errorf returns a syntax error positioned at item, or at the end of the action if item is nil.
+func (p *actionParser) errorf(item *lexItem, msg string) error {
+	pos := p.end
+	if item != nil {
+		pos = item.pos
+	}
+	return &ExprError{Message: msg, Pos: p.offset + pos}
+}
+
+// newItem creates an AST node for a lex item.
+func (p *actionParser) newItem(typ astType, val string, item *lexItem) *JExprItem {
+	a := newJExprItem(typ, val, nil)
+	a.pos = p.offset + item.pos
+	return a
+}
+
+// newPathItem creates an AST node for a path, the path is compiled once here rather than on every evaluation.
+func (p *actionParser) newPathItem(item *lexItem) *JExprItem {
+	a := p.newItem(astPath, item.val, item)
+	a.path = compilePath(item.val)
+	return a
+}
+
+func (p *actionParser) peek() *lexItem {
+	if p.pos < len(p.items) {
+		return &p.items[p.pos]
+	}
+	return nil
+}
+
+func (p *actionParser) next() *lexItem {
+	item := p.peek()
+	if item != nil {
+		p.pos++
+	}
+	return item
+}
+
+func (p *actionParser) parseBinary(minPrecedence int) (*JExprItem, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		item := p.peek()
+		if item == nil || item.typ != lexItemOperator {
+			return left, nil
+		}
+		precedence, ok := operatorPrecedence[item.val]
+		if !ok || precedence < minPrecedence {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseBinary(precedence + 1)
+		if err != nil {
+			return nil, err
+		}
+		op := p.newItem(astOperator, item.val, item)
+		op.addKids(left, right)
+		left = op
+	}
+}
+
+func (p *actionParser) parseUnary() (*JExprItem, error) {
+	item := p.peek()
+	if item != nil && item.typ == lexItemOperator && (item.val == "!" || item.val == "-") {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		op := p.newItem(astOperator, item.val, item)
+		op.addKids(operand)
+		return op, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *actionParser) parsePrimary() (*JExprItem, error) {
+	item := p.next()
+	if item == nil {
+		return nil, p.errorf(nil, "missing operand")
+	}
+	switch item.typ {
+	case lexItemPath:
+		if !strings.HasPrefix(item.val, "/") {
+			return nil, p.errorf(item, "invalid path "+item.val)
+		}
+		return p.newPathItem(item), nil
+	case lexItemDot:
+		return p.newPathItem(item), nil
+	case lexItemNumber:
+		t := p.newItem(astText, "", item)
+		if i, err := strconv.Atoi(item.val); err == nil {
+			t.val = i
+		} else if f, err := strconv.ParseFloat(item.val, 64); err == nil {
+			t.val = f
+		} else {
+			return nil, p.errorf(item, "invalid number "+item.val)
+		}
+		return t, nil
+	case lexItemString:
+		return p.newItem(astText, strings.Replace(extractStringParam(item.val), "\\'", "'", -1), item), nil
+	case lexItemBool:
+		t := p.newItem(astText, "", item)
+		t.val = item.val == "true"
+		return t, nil
+	case lexItemFunction:
+		return p.parseFunction(item)
+	case lexItemOperator:
+		if item.val == "(" {
+			e, err := p.parseBinary(1)
+			if err != nil {
+				return nil, err
+			}
+			closing := p.next()
+			if closing == nil || closing.typ != lexItemOperator || closing.val != ")" {
+				return nil, p.errorf(closing, "missing closing bracket in expression")
+			}
+			return e, nil
+		}
+		return nil, p.errorf(item, "missing operand before "+item.val)
+	}
+	return nil, p.errorf(item, "unexpected "+item.val+" in expression")
+}
+
+func (p *actionParser) parseFunction(fi *lexItem) (*JExprItem, error) {
+	name := fi.val
+	fnc := NewFunction(name)
+	if fnc == nil {
+		return nil, p.errorf(fi, "unknown function "+name)
+	}
+	f := p.newItem(astFunction, name, fi)
+	if item := p.next(); item == nil || item.typ != lexItemLeftBracket {
+		return nil, p.errorf(item, "missing opening bracket for function "+name)
+	}
+	for {
+		item := p.next()
+		if item == nil {
+			return nil, p.errorf(fi, "unclosed function "+name)
+		}
+		switch item.typ {
+		case lexItemParam:
+			param := p.newItem(astParam, item.val, item)
+			param.literal = true
+			f.addKids(param)
+		case lexItemRightBracket:
+			if len(f.kids) < fnc.minNumParams || len(f.kids) > fnc.maxNumParams {
+				return nil, p.errorf(fi, "wrong number of params for function "+name)
+			}
+			return f, nil
+		default:
+			return nil, p.errorf(item, "unexpected "+item.val+" in params of function "+name)
+		}
+	}
+}
+
+func (a *JExprItem) addKids(kids ...*JExprItem) {
+	for _, k := range kids {
+		k.mom = a
+		a.kids = append(a.kids, k)
+	}
+}
+
+// evalOperator evaluates an operator node once all of its operands have been collapsed.
+func (a *JExprItem) evalOperator(ctx Context) bool {
+	operands := make([]interface{}, 0, len(a.kids))
+	for _, k := range a.kids { // only evaluate if all operands are ready
+		if k.typ != astText {
+			return false
+		}
+		operands = append(operands, k.val)
+	}
+	op := ToFlatString(a.val)
+	val, err := applyOperator(op, operands)
+	if err != nil {
+		params := make([]string, 0, len(operands))
+		for _, o := range operands {
+			params = append(params, ToFlatString(o))
+		}
+		ctx.Log().Error("error_type", "parser", "cause", "invalid_operand", "op", op, "operands", params, "error", err.Error())
+		ctx.Value(EelTotalStats).(*ServiceStats).IncErrors()
+		AddError(ctx, RuntimeError{err.Error(), op, params})
+		val = ""
+	}
+	a.val = val // retain type of result
+	a.kids = make([]*JExprItem, 0)
+	if a.mom != nil && a.mom.typ == astFunction {
+		a.typ = astParam
+	} else {
+		a.typ = astText
+	}
+	return true
+}
+
+// optimizeLogicalOperator evaluates && and || with short circuit, i.e. the right operand is only evaluated if the left
+// operand does not determine the result already. The operator is replaced with its boolean result.
+func (a *JExprItem) optimizeLogicalOperator(ctx Context, doc *JDoc) (*JExprItem, error) {
+	stats := ctx.Value(EelTotalStats).(*ServiceStats)
+	op := ToFlatString(a.val)
+	if len(a.kids) != 2 {
+		ctx.Log().Error("error_type", "parser", "cause", "wrong_number_of_operands", "op", op, "num_operands", len(a.kids), "error", "wrong number of operands")
+		stats.IncErrors()
+		AddError(ctx, RuntimeError{fmt.Sprintf("wrong number of operands"), op, nil})
+		return nil, errors.New(op + " has wrong number of operands")
+	}
+	if a.mom == nil {
+		ctx.Log().Error("error_type", "parser", "cause", "conditional_orphan", "type", a.typ, "val", a.val, "error", "conditional orphan")
+		stats.IncErrors()
+		AddError(ctx, RuntimeError{fmt.Sprintf("conditional orphan"), op, nil})
+		return nil, errors.New("conditional orphan")
+	}
+	childIdx := a.getMotherIdx()
+	result := newJExprItem(astText, "", a.mom)
+	a.mom.kids[childIdx] = result
+	var err error
+	for _, operand := range a.kids {
+		val := operand.collapseOperand(ctx, doc)
+		b, ok := toBoolOperand(val)
+		if !ok {
+			ctx.Log().Error("error_type", "parser", "cause", "non_boolean_operand", "op", op, "val", val, "error", "non boolean operand")
+			stats.IncErrors()
+			AddError(ctx, RuntimeError{fmt.Sprintf("non-boolean operand"), op, []string{ToFlatString(val)}})
+			err = errors.New("non-boolean operand")
+			result.val = "" // same as a failed operator, the result of a preceding operand must not leak
+			break
+		}
+		result.val = b
+		if (op == "&&" && !b) || (op == "||" && b) {
+			break
+		}
+	}
+	result.print(0, "CHOSENCHILD")
+	return result, err
+}
+
+// collapseOperand evaluates a detached operand sub tree and returns its value.
+func (a *JExprItem) collapseOperand(ctx Context, doc *JDoc) interface{} {
+	agg := newJExprParser()
+	agg.addKids(a)
+	agg.collapseIntoSingleNode(ctx, doc)
+	return agg.val
+}
+
+// applyOperator applies operator op to the given operands (one for prefix operators, two for infix operators).
+func applyOperator(op string, operands []interface{}) (interface{}, error) {
+	if len(operands) == 1 {
+		switch op {
+		case "!":
+			b, ok := toBoolOperand(operands[0])
+			if !ok {
+				return nil, errors.New("non-boolean operand")
+			}
+			return !b, nil
+		case "-":
+			return arithmetic("*", -1, operands[0])
+		}
+		return nil, errors.New("unsupported prefix operator " + op)
+	}
+	if len(operands) != 2 {
+		return nil, errors.New("wrong number of operands")
+	}
+	x, y := operands[0], operands[1]
+	switch op {
+	case "&&", "||":
+		bx, okx := toBoolOperand(x)
+		by, oky := toBoolOperand(y)
+		if !okx || !oky {
+			return nil, errors.New("non-boolean operand")
+		}
+		if op == "&&" {
+			return bx && by, nil
+		}
+		return bx || by, nil
+	case "==":
+		return equalOperands(x, y), nil
+	case "!=":
+		return !equalOperands(x, y), nil
+	case "<":
+		return compareOperands(x, y) < 0, nil
+	case "<=":
+		return compareOperands(x, y) <= 0, nil
+	case ">":
+		return compareOperands(x, y) > 0, nil
+	case ">=":
+		return compareOperands(x, y) >= 0, nil
+	case "+":
+		if isNumber(x) && isNumber(y) {
+			return arithmetic(op, x, y)
+		}
+		// string concatenation
+		return ToFlatString(x) + ToFlatString(y), nil
+	case "-", "*", "/":
+		return arithmetic(op, x, y)
+	}
+	return nil, errors.New("unsupported operator " + op)
+}
+
+// arithmetic applies +, -, * or / to numbers or numeric strings. The result is an int if both operands are whole
+// numbers (and for division if there is no remainder), otherwise a float64.
+func arithmetic(op string, x interface{}, y interface{}) (interface{}, error) {
+	fx, okx := toNumberOperand(x)
+	fy, oky := toNumberOperand(y)
+	if !okx || !oky {
+		return nil, errors.New("non-numeric operand")
+	}
+	var r float64
+	switch op {
+	case "+":
+		r = fx + fy
+	case "-":
+		r = fx - fy
+	case "*":
+		r = fx * fy
+	case "/":
+		if fy == 0 {
+			return nil, errors.New("division by zero")
+		}
+		r = fx / fy
+	}
+	// whole numbers outside of the int64 range stay float64, int(r) would overflow
+	if isInt(x) && isInt(y) && r == math.Trunc(r) && r >= math.MinInt64 && r < -math.MinInt64 {
+		return int(r), nil
+	}
+	return r, nil
+}
+
+// equalOperands compares operands numerically if at least one of them is a number and the other one is a number or
+// numeric string, compares objects and arrays structurally and everything else by string value.
+func equalOperands(x interface{}, y interface{}) bool {
+	if isNumber(x) || isNumber(y) {
+		fx, okx := toNumberOperand(x)
+		fy, oky := toNumberOperand(y)
+		if okx && oky {
+			return fx == fy
+		}
+	}
+	switch x.(type) {
+	case map[string]interface{}, []interface{}:
+		return reflect.DeepEqual(x, y)
+	}
+	switch y.(type) {
+	case map[string]interface{}, []interface{}:
+		return false
+	}
+	return ToFlatString(x) == ToFlatString(y)
+}
+
+// compareOperands compares numerically if both operands are numbers or numeric strings, otherwise by string value.
+func compareOperands(x interface{}, y interface{}) int {
+	fx, okx := toNumberOperand(x)
+	fy, oky := toNumberOperand(y)
+	if okx && oky {
+		switch {
+		case fx < fy:
+			return -1
+		case fx > fy:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(ToFlatString(x), ToFlatString(y))
+}
+
+func isNumber(x interface{}) bool {
+	switch x.(type) {
+	case int, int64, float64:
+		return true
+	}
+	return false
+}
+
+func isInt(x interface{}) bool {
+	switch x.(type) {
+	case int, int64:
+		return true
+	case float64:
+		// json numbers are always float64
+		return x.(float64) == math.Trunc(x.(float64))
+	case string:
+		_, err := strconv.Atoi(x.(string))
+		return err == nil
+	}
+	return false
+}
+
+func toNumberOperand(x interface{}) (float64, bool) {
+	switch x.(type) {
+	case int:
+		return float64(x.(int)), true
+	case int64:
+		return float64(x.(int64)), true
+	case float64:
+		return x.(float64), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(x.(string)), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func toBoolOperand(x interface{}) (bool, bool) {
+	switch x.(type) {
+	case bool:
+		return x.(bool), true
+	case string:
+		switch x.(string) {
+		case "true", "'true'":
+			return true, true
+		case "false", "'false'":
+			return false, true
+		}
+	}
+	return false, false
+}
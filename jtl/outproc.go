@@ -18,6 +18,7 @@ package jtl
 
 import (
 	"encoding/json"
+	"net/url"
 	"sync"
 	"time"
 
@@ -28,6 +29,7 @@ import (
 func handleEvent(ctx Context, stats *ServiceStats, event *JDoc, raw string, debug bool, syncExec bool) interface{} {
 	debuginfo := make([]interface{}, 0)
 	ctx.AddLogValue("destination", "unknown")
+	Record(ctx, MessageIn, nil, 1)
 	handlers := GetHandlerFactory(ctx).GetHandlersForEvent(ctx, event)
 	if len(handlers) == 0 {
 		// ctx.Log().Info("action", "no_matching_handlers")
@@ -43,6 +45,7 @@ func handleEvent(ctx Context, stats *ServiceStats, event *JDoc, raw string, debu
 			attrs := map[string]string{
 				TopicKey:   handler.Topic,
 				HandlerKey: handler.Name,
+				TenantKey:  handler.TenantId,
 			}
 			ctx = Start(ctx, MessageProcess, attrs)
 			start := time.Now()
@@ -60,6 +63,7 @@ func handleEvent(ctx Context, stats *ServiceStats, event *JDoc, raw string, debu
 				ctx.Log().Error("error_type", "transformation", "cause", "bad_transformation", "trace.in.data", event.GetOriginalObject(), "error", err.Error())
 				ctx.Log().Metric("bad_transformation", M_Namespace, "xrs", M_Metric, "bad_transformation", M_Unit, "Count", M_Dims, "app="+AppId+"&env="+EnvName+"&instance="+InstanceName+"&destination="+ctx.LogValue("destination").(string), M_Val, 1.0)
 				stats.IncErrors()
+				Record(ctx, MessageError, attrs, 1)
 				return
 			}
 
@@ -105,10 +109,13 @@ func handleEvent(ctx Context, stats *ServiceStats, event *JDoc, raw string, debu
 						ctx.Log().Error("error_type", "publish_event", "error", err.Error(), "cause", "publish_event")
 						ctx.Log().Metric("publish_failed", M_Namespace, "xrs", M_Metric, "publish_failed", M_Unit, "Count", M_Dims, "app="+AppId+"&env="+EnvName+"&instance="+InstanceName+"&destination="+ctx.LogValue("destination").(string), M_Val, 1.0)
 						stats.IncErrors()
+						Record(ctx, MessageError, publisherAttrs(attrs, publisher), 1)
+						addDeadLetter(ctx, handler, publisher, err)
 					} else {
 						ctx.Log().Info("action", "published_event")
 						ctx.Log().Metric("published_event", M_Namespace, "xrs", M_Metric, "published_event", M_Unit, "Count", M_Dims, "app="+AppId+"&env="+EnvName+"&instance="+InstanceName+"&destination="+ctx.LogValue("destination").(string), M_Val, 1.0)
 						stats.IncOutCount()
+						Record(ctx, MessageOut, publisherAttrs(attrs, publisher), 1)
 					}
 					de := make(map[string]interface{}, 0)
 					de["trace.out.endpoint"] = publisher.GetEndpoint()
@@ -143,7 +150,7 @@ func handleEvent(ctx Context, stats *ServiceStats, event *JDoc, raw string, debu
 					//c := ctx
 					//p := publisher
 					wg.Add(1)
-					go func(c Context, p EventPublisher) {
+					go func(c Context, p EventPublisher, h *HandlerConfiguration) {
 						defer wg.Done()
 						defer c.HandlePanic()
 						_, err := p.Publish()
@@ -154,14 +161,17 @@ func handleEvent(ctx Context, stats *ServiceStats, event *JDoc, raw string, debu
 							c.Log().Error("error_type", "publish_event", "error", err.Error(), "cause", "publish_event")
 							c.Log().Metric("publish_failed", M_Namespace, "xrs", M_Metric, "publish_failed", M_Unit, "Count", M_Dims, "app="+AppId+"&env="+EnvName+"&instance="+InstanceName+"&destination="+ctx.LogValue("destination").(string), M_Val, 1.0)
 							stats.IncErrors()
+							Record(c, MessageError, publisherAttrs(attrs, p), 1)
+							addDeadLetter(c, h, p, err)
 						} else {
 							if p.GetProtocol() != "null" {
 								c.Log().Info("action", "published_event")
 								c.Log().Metric("published_event", M_Namespace, "xrs", M_Metric, "published_event", M_Unit, "Count", M_Dims, "app="+AppId+"&env="+EnvName+"&instance="+InstanceName+"&destination="+ctx.LogValue("destination").(string), M_Val, 1.0)
 								stats.IncOutCount()
+								Record(c, MessageOut, publisherAttrs(attrs, p), 1)
 							}
 						}
-					}(ctx.SubContext(), publisher)
+					}(ctx.SubContext(), publisher, handler)
 				}
 			}
 		}(ctx)
@@ -169,3 +179,27 @@ func handleEvent(ctx Context, stats *ServiceStats, event *JDoc, raw string, debu
 	wg.Wait()
 	return debuginfo
 }
+
+// publisherAttrs adds the destination host of a publisher to the handler attributes for recording metrics.
+func publisherAttrs(attrs map[string]string, p EventPublisher) map[string]string {
+	pattrs := make(map[string]string, len(attrs)+1)
+	for k, v := range attrs {
+		pattrs[k] = v
+	}
+	if u, err := url.Parse(p.GetUrl()); err == nil && u.Host != "" {
+		pattrs[HTTPHostKey] = u.Scheme + "://" + u.Host
+	}
+	return pattrs
+}
+
+// addDeadLetter saves a publisher that failed to deliver an event to the dead letter store (if enabled).
+func addDeadLetter(ctx Context, handler *HandlerConfiguration, p EventPublisher, err error) {
+	dls := GetDeadLetterStore(ctx)
+	if dls == nil {
+		return
+	}
+	dl := NewDeadLetter(ctx, handler, p, err)
+	if dls.Add(ctx, dl) == nil {
+		ctx.Log().Info("action", "added_dead_letter", "id", dl.Id)
+	}
+}
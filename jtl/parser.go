@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	. "github.com/Comcast/eel/util"
 )
@@ -33,6 +34,7 @@ const (
 	astParam                   // 2 'foo'
 	astText                    // 3 plain text
 	astAgg                     // 4 concatenation of sub elements
+	astOperator                // 5 infix or prefix operator such as == or !
 )
 
 const (
@@ -46,7 +48,43 @@ type JExprItem struct {
 	kids     []*JExprItem
 	exploded bool
 	mom      *JExprItem
-	level    int // level in AST
+	level    int           // level in AST
+	pos      int           // byte offset in expression
+	path     *compiledPath // path nodes only, compiled once and shared by all clones
+	literal  bool          // function parameter given literally in the expression rather than evaluated
+}
+
+// ExprError is a syntax error in a jpath expression with the position of the offending token.
+type ExprError struct {
+	Message string
+	Expr    string // complete expression
+	Pos     int    // byte offset of the error in Expr
+}
+
+func (e *ExprError) Error() string {
+	return e.Message
+}
+
+// Line returns the 1-based line of the error in the expression.
+func (e *ExprError) Line() int {
+	return strings.Count(e.Expr[:e.pos()], "\n") + 1
+}
+
+// Column returns the 1-based column of the error within its line of the expression.
+func (e *ExprError) Column() int {
+	pos := e.pos()
+	start := strings.LastIndex(e.Expr[:pos], "\n") + 1
+	return utf8.RuneCountInString(e.Expr[start:pos]) + 1
+}
+
+func (e *ExprError) pos() int {
+	if e.Pos > len(e.Expr) {
+		return len(e.Expr)
+	}
+	if e.Pos < 0 {
+		return 0
+	}
+	return e.Pos
 }
 
 // JExprD3Node represents a simplified version of a node on the abstract syntax tree for debug and visulaization purposes.
@@ -57,7 +95,7 @@ type JExprD3Node struct {
 }
 
 func newJExprItem(typ astType, val string, mom *JExprItem) *JExprItem {
-	return &JExprItem{typ, val, make([]*JExprItem, 0), false, mom, 0}
+	return &JExprItem{typ, val, make([]*JExprItem, 0), false, mom, 0, 0, nil, false}
 }
 
 func newJExprParser() *JExprItem {
@@ -67,21 +105,30 @@ func newJExprParser() *JExprItem {
 // NewJExpr parses (but does not execute) a jpath expression and returns handle.
 func NewJExpr(expr string) (*JExprItem, error) {
 	ast := newJExprParser()
-	err := ast.parse(expr)
-	if err == nil {
-		for {
-			exploded, err := ast.explodeParams()
-			if err != nil {
-				return ast, err
-			}
-			if !exploded {
-				break
-			}
+	err := ast.parse(expr, 0)
+	for err == nil {
+		var exploded bool
+		exploded, err = ast.explodeParams()
+		if !exploded {
+			break
 		}
 	}
+	if e, ok := err.(*ExprError); ok {
+		e.Expr = expr
+	}
 	return ast, err
 }
 
+// clone returns a deep copy of the AST. Compiled ASTs are never executed directly because execution collapses the tree,
+// instead a clone is made for every evaluation.
+func (a *JExprItem) clone(mom *JExprItem) *JExprItem {
+	c := &JExprItem{a.typ, a.val, make([]*JExprItem, 0, len(a.kids)), a.exploded, mom, a.level, a.pos, a.path, a.literal}
+	for _, k := range a.kids {
+		c.kids = append(c.kids, k.clone(c))
+	}
+	return c
+}
+
 // GetD3Json returns a simplified AST for display with D3
 func (a *JExprItem) GetD3Json(cur *JExprD3Node) *JExprD3Node {
 	if cur == nil {
@@ -102,48 +149,35 @@ func (a *JExprItem) GetD3Json(cur *JExprD3Node) *JExprD3Node {
 	return cur
 }
 
-// parse parses a single jpath expression. Returns an error (if any) or nil.
-func (a *JExprItem) parse(expr string) error {
+// parse parses a single jpath expression located at offset in the complete expression. Returns an error (if any) or nil.
+func (a *JExprItem) parse(expr string, offset int) error {
 	_, c := lex("", expr)
-	var f *JExprItem
-	var fnc *JFunction
+	// drain lexer in case we bail out early
+	defer func() {
+		for range c {
+		}
+	}()
+	action := make([]lexItem, 0)
 	for item := range c {
 		switch item.typ {
-		case lexItemFunction:
-			fnc = NewFunction(item.val)
-			if fnc == nil {
-				return errors.New("unknown function " + item.val)
+		case lexItemLeftMeta:
+			action = action[:0]
+		case lexItemRightMeta:
+			e, err := parseAction(action, offset, item.pos)
+			if err != nil {
+				return err
 			}
-			f = newJExprItem(astFunction, item.val, a)
-			a.kids = append(a.kids, f)
+			e.mom = a
+			a.kids = append(a.kids, e)
 		case lexItemText:
 			t := newJExprItem(astText, item.val, a)
+			t.pos = offset + item.pos
 			a.kids = append(a.kids, t)
-		case lexItemParam:
-			if f != nil {
-				p := newJExprItem(astParam, item.val, f) //strings.Replace(item.val, "\\", "", -1), f)
-				f.kids = append(f.kids, p)
-			} else {
-				return errors.New("param without function")
-			}
-		case lexItemRightBracket:
-			if f != nil {
-				if len(f.kids) < fnc.minNumParams || len(f.kids) > fnc.maxNumParams {
-					return errors.New("wrong number of params for function " + f.val.(string))
-				}
-				f = nil
-			} else {
-				return errors.New("closing bracket without function")
-			}
-		case lexItemPath:
-			if strings.HasPrefix(item.val, "/") || item.val == "." {
-				p := newJExprItem(astPath, item.val, a)
-				a.kids = append(a.kids, p)
-			} else {
-				return errors.New("invalid path " + item.val)
-			}
 		case lexItemError:
-			return errors.New(item.val)
+			return &ExprError{Message: item.val, Pos: offset + item.pos}
+		case lexItemEOF:
+		default:
+			action = append(action, item)
 		}
 	}
 	return nil
@@ -161,6 +195,8 @@ func (a *JExprItem) typeString() string {
 		return "TEXT"
 	case astAgg:
 		return "AGG"
+	case astOperator:
+		return "OPERATOR"
 	default:
 		return "UNKNOWN"
 	}
@@ -174,12 +210,18 @@ func (a *JExprItem) updateLevels(level int) {
 	}
 }
 
-// getDeepestConditional gets the lowest level conditional (ifte(), case(), alt()) for AST optimization
+// isConditional returns true for conditionals (ifte(), case(), alt(), && and ||) which only evaluate some of their sub trees
+func (a *JExprItem) isConditional() bool {
+	return (a.typ == astFunction && (a.val == "ifte" || a.val == "alt" || a.val == "case")) ||
+		(a.typ == astOperator && (a.val == "&&" || a.val == "||"))
+}
+
+// getDeepestConditional gets the lowest level conditional (ifte(), case(), alt(), && and ||) for AST optimization
 func (a *JExprItem) getDeepestConditional(cond **JExprItem) *JExprItem {
 	if cond == nil {
 		cond = new(*JExprItem)
 	}
-	if a.typ == astFunction && (a.val == "ifte" || a.val == "alt" || a.val == "case") {
+	if a.isConditional() {
 		if *cond == nil {
 			*cond = a
 		} else if a.level > (*cond).level {
@@ -192,9 +234,9 @@ func (a *JExprItem) getDeepestConditional(cond **JExprItem) *JExprItem {
 	return *cond
 }
 
-// getHighestConditional gets the highest level conditional (ifte(), case(), alt()) for AST optimization
+// getHighestConditional gets the highest level conditional (ifte(), case(), alt(), && and ||) for AST optimization
 func (a *JExprItem) getHighestConditional() *JExprItem {
-	if a.typ == astFunction && (a.val == "ifte" || a.val == "alt" || a.val == "case") {
+	if a.isConditional() {
 		return a
 	}
 	for _, k := range a.kids {
@@ -402,6 +444,8 @@ func (a *JExprItem) optimizeConditional(ctx Context, doc *JDoc) (*JExprItem, err
 		a.mom.kids[childIdx] = chosenChild
 		chosenChild.print(0, "CHOSENCHILD")
 		return a.mom.kids[childIdx], nil
+	} else if a.typ == astOperator && (a.val == "&&" || a.val == "||") {
+		return a.optimizeLogicalOperator(ctx, doc)
 	} else {
 		ctx.Log().Error("error_type", "parser", "cause", "unsupported_conditional", "type", a.typ, "val", a.val, "error", "unsupported conditional")
 		stats.IncErrors()
@@ -464,12 +508,13 @@ func (a *JExprItem) explodeParams() (bool, error) {
 		valStr := ToFlatString(a.val)
 		if strings.Contains(valStr, leftMeta) || strings.Contains(valStr, rightMeta) {
 			a.typ = astAgg
-			err = a.parse(extractStringParam(valStr))
+			err = a.parse(extractStringParam(valStr), a.pos+1)
 			if err != nil {
 				return false, err
 			}
 			a.val = ""
 			a.exploded = true
+			a.literal = false
 			exploded = true
 		}
 	}
@@ -508,11 +553,15 @@ func (a *JExprItem) collapseLeaf(ctx Context, doc *JDoc) bool {
 			//ctx.Log().Debug("action", "ast_collapse_failure", "reason", "mom_is_nil", "val", a.val, "type", a.typeString())
 			return false
 		}
-		a.val = doc.EvalPath(ctx, ToFlatString(a.val)) // retain type of selected path
+		if a.path != nil {
+			a.val = doc.evalCompiledPath(ctx, a.path) // retain type of selected path
+		} else {
+			a.val = doc.EvalPath(ctx, ToFlatString(a.val))
+		}
 		if a.val == nil {
 			a.val = ""
 		}
-		if a.mom.typ == astAgg {
+		if a.mom.typ == astAgg || a.mom.typ == astOperator {
 			a.typ = astText
 		} else if a.mom.typ == astFunction {
 			a.typ = astParam
@@ -535,7 +584,7 @@ func (a *JExprItem) collapseLeaf(ctx Context, doc *JDoc) bool {
 		if a.val == nil {
 			a.val = ""
 		}
-		if a.mom.typ == astAgg {
+		if a.mom.typ == astAgg || a.mom.typ == astOperator {
 			a.typ = astText
 		} else if a.mom.typ == astFunction {
 			a.typ = astParam
@@ -560,17 +609,32 @@ func (a *JExprItem) collapseLeaf(ctx Context, doc *JDoc) bool {
 			return false
 		}
 		params := make([]string, 0)
-		for _, k := range a.mom.kids { // only execute if all parameters are ready
+		// secret references given literally, only those are resolved by secretParam
+		var secrets []bool
+		for i, k := range a.mom.kids { // only execute if all parameters are ready
 			if k.typ != astParam {
 				return false
 			}
 			params = append(params, ToFlatString(k.val))
+			if k.literal && isSecretReference(extractStringParam(params[i])) {
+				if secrets == nil {
+					secrets = make([]bool, len(a.mom.kids))
+				}
+				secrets[i] = true
+			}
+		}
+		if secrets != nil {
+			outer := ctx.Value(EelLiteralSecrets)
+			ctx.AddValue(EelLiteralSecrets, secrets)
+			a.mom.val = f.ExecuteFunction(ctx, doc, params) // retain type of function return values
+			ctx.AddValue(EelLiteralSecrets, outer)
+		} else {
+			a.mom.val = f.ExecuteFunction(ctx, doc, params) // retain type of function return values
 		}
-		a.mom.val = f.ExecuteFunction(ctx, doc, params) // retain type of function return values
 		a.mom.kids = make([]*JExprItem, 0)
 		if a.mom.mom.typ == astFunction {
 			a.mom.typ = astParam
-		} else if a.mom.mom.typ == astAgg {
+		} else if a.mom.mom.typ == astAgg || a.mom.mom.typ == astOperator {
 			a.mom.typ = astText
 		} else {
 			//ctx.Log().Debug("action", "ast_collapse_failure", "reason", "mom_must_be_function_or_aggregation", "val", a.val, "type", a.typeString())
@@ -581,13 +645,16 @@ func (a *JExprItem) collapseLeaf(ctx Context, doc *JDoc) bool {
 			//ctx.Log().Debug("action", "ast_collapse_failure", "reason", "mom_is_nil", "val", a.val, "type", a.typeString())
 			return false
 		}
+		if a.mom.typ == astOperator { // operands
+			return a.mom.evalOperator(ctx)
+		}
 		if a.mom.typ != astAgg {
 			//ctx.Log().Debug("action", "ast_collapse_failure", "reason", "mom_must_be_aggregation", "val", a.val, "type", a.typeString())
 			return false
 		}
 		if len(a.mom.kids) == 1 { // retain type for single value aggregations, except for nil which is converted to ""
 			if a.mom.mom != nil && a.mom.mom.typ == astFunction {
-				a.mom.val = "'" + ToFlatString(a.val) + "'"
+				a.mom.val = "'" + paramString(ToFlatString(a.mom.mom.val), a.val) + "'"
 				a.mom.typ = astParam
 			} else {
 				a.mom.val = a.val
@@ -598,15 +665,28 @@ func (a *JExprItem) collapseLeaf(ctx Context, doc *JDoc) bool {
 			}
 		} else { // for multiple value aggregations, convert to string and concatenate
 			txt := ""
+			// text concatenated with escaped strings has its escape indicators removed right away
+			unescaped := ""
+			escaped := false
 			for _, k := range a.mom.kids { // only aggregate if all texts ready
 				if k.typ != astText {
 					return false
 				}
-				txt += ToFlatString(k.val)
+				if s, ok := k.val.(escapedString); ok {
+					escaped = true
+					txt += string(s)
+					unescaped += string(s)
+				} else {
+					txt += ToFlatString(k.val)
+					unescaped += strings.Replace(ToFlatString(k.val), "\\", "", -1)
+				}
 			}
 			if a.mom.mom != nil && a.mom.mom.typ == astFunction {
 				a.mom.val = "'" + txt + "'"
 				a.mom.typ = astParam
+			} else if escaped {
+				a.mom.val = escapedString(unescaped)
+				a.mom.typ = astText
 			} else {
 				a.mom.val = txt
 				a.mom.typ = astText
@@ -668,11 +748,37 @@ func (a *JExprItem) Execute(ctx Context, doc *JDoc) interface{} {
 	}
 	a.print(0, "AST")
 	// remove escape indicators in final step
-	switch a.val.(type) {
+	a.val = removeEscapeIndicators(a.val)
+	return a.val
+}
+
+// paramString converts a single value passed as parameter to function fn to string. Floats are rounded to two
+// decimals by ToFlatString, except for functions registered with RegisterExactFunction which see the full value.
+func paramString(fn string, v interface{}) string {
+	if s, ok := v.(escapedString); ok {
+		return string(s)
+	}
+	if f, ok := v.(float64); ok {
+		if jf := NewFunction(fn); jf != nil && jf.exactFloats {
+			return strconv.FormatFloat(f, 'f', -1, 64)
+		}
+	}
+	return ToFlatString(v)
+}
+
+// escapedString is a function result which already contains escape sequences, for example the result of
+// jsonescape(). Its back slashes are not removed as escape indicators.
+type escapedString string
+
+// removeEscapeIndicators removes back slashes used as escape indicators from the result of an expression.
+func removeEscapeIndicators(v interface{}) interface{} {
+	switch v.(type) {
 	case string:
-		a.val = strings.Replace(a.val.(string), "\\", "", -1)
+		return strings.Replace(v.(string), "\\", "", -1)
+	case escapedString:
+		return string(v.(escapedString))
 	}
-	return a.val
+	return v
 }
 
 // ExecuteDebug executes parsed jpath expression in debug mode and returns result as interface as well as detailed tabular debug information.
@@ -688,9 +794,6 @@ func (a *JExprItem) ExecuteDebug(ctx Context, doc *JDoc) (interface{}, [][][]str
 	}
 	a.print(0, "AST")
 	// remove escape indicators in final step
-	switch a.val.(type) {
-	case string:
-		a.val = strings.Replace(a.val.(string), "\\", "", -1)
-	}
+	a.val = removeEscapeIndicators(a.val)
 	return a.val, trees
 }
@@ -16,8 +16,8 @@
 
 package jtl
 
-// inbound plugins, currently only supported plugins are webhook and stdin,
-// other plugins could be provided for websocket, kafka, sqs etc.
+// inbound plugins, currently supported plugins are webhook, stdin and kafka,
+// other plugins could be provided for websocket, sqs etc.
 
 import (
 	"encoding/json"
@@ -41,6 +41,14 @@ type InboundPlugin interface {
 	IsActive() bool
 }
 
+// FetchingInboundPlugin is implemented by inbound plugins that acknowledge events at their source once they have been
+// handled. StopFetching stops taking in new events but keeps the connection to the source open, so that events still
+// in the work queues can be acknowledged before StopPlugin is called.
+type FetchingInboundPlugin interface {
+	InboundPlugin
+	StopFetching(Context)
+}
+
 type PluginTemplateParams struct {
 	BasePath string
 	PluginSettings
@@ -96,6 +104,33 @@ func GetInboundPluginByName(name string) InboundPlugin {
 	return inboundPluginMap[name]
 }
 
+// StopFetchingInboundPlugins stops all active inbound plugins from accepting new events. Plugins acknowledging events
+// at their source keep their connection open until StopInboundPlugins is called.
+func StopFetchingInboundPlugins(ctx Context) {
+	for k, v := range inboundPluginMap {
+		if !v.IsActive() {
+			continue
+		}
+		if fp, ok := v.(FetchingInboundPlugin); ok {
+			ctx.Log().Info("action", "stopping_inbound_plugin_fetch", "plugin_name", k, "pugin_type", v.GetSettings().Type)
+			fp.StopFetching(ctx)
+		} else {
+			ctx.Log().Info("action", "stopping_inbound_plugin", "plugin_name", k, "pugin_type", v.GetSettings().Type)
+			v.StopPlugin(ctx)
+		}
+	}
+}
+
+// StopInboundPlugins stops all active inbound plugins so that no new events are accepted
+func StopInboundPlugins(ctx Context) {
+	for k, v := range inboundPluginMap {
+		if v.IsActive() {
+			ctx.Log().Info("action", "stopping_inbound_plugin", "plugin_name", k, "pugin_type", v.GetSettings().Type)
+			v.StopPlugin(ctx)
+		}
+	}
+}
+
 func PluginConfigHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := Gctx.SubContext()
 	w.Header().Set("Content-Type", "application/json")
@@ -166,7 +201,7 @@ func ManagePluginsHandler(w http.ResponseWriter, r *http.Request) {
 			fmt.Fprintf(w, `{"error":"%s"}`, "plugin cannot restart "+pName)
 			return
 		}
-		if p.GetSettings().Active == true {
+		if p.IsActive() {
 			ctx.Log().Error("error_type", "manage_plugins", "cause", "plugin_already_running", "error", "plugin already running "+pName)
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
@@ -185,7 +220,7 @@ func ManagePluginsHandler(w http.ResponseWriter, r *http.Request) {
 			fmt.Fprintf(w, `{"error":"%s"}`, "plugin cannot restart "+pName)
 			return
 		}
-		if p.GetSettings().Active == false {
+		if !p.IsActive() {
 			ctx.Log().Error("error_type", "manage_plugins", "cause", "plugin_stopped", "error", "plugin already stopped "+pName)
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusInternalServerError)
@@ -0,0 +1,90 @@
+/**
+ * Copyright 2015 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jtl
+
+import (
+	"time"
+
+	. "github.com/Comcast/eel/util"
+)
+
+const defaultShutdownTimeout = 30000
+
+// GetShutdownTimeout returns the configured drain deadline for graceful shutdown.
+func GetShutdownTimeout(ctx Context) time.Duration {
+	timeout := GetConfig(ctx).ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	return time.Duration(timeout) * time.Millisecond
+}
+
+// Shutdown gracefully stops EEL. Inbound plugins stop accepting events, the work queues of all tenants are drained
+// through the workers (including in flight publishes) until the drain deadline has passed. Events that could not be
+// handled in time are written to the spill-over queue if one is configured, otherwise they are logged.
+func Shutdown(ctx Context) {
+	start := time.Now()
+	deadline := start.Add(GetShutdownTimeout(ctx))
+	ctx.Log().Info("action", "shutting_down", "deadline", deadline.Format(time.RFC3339))
+	if hw := GetHandlerWatcher(ctx); hw != nil {
+		hw.Stop(ctx)
+	}
+	// plugins acknowledging events at their source stay connected until the work queues have been drained
+	StopFetchingInboundPlugins(ctx)
+	sq := GetSpillOverQueue(ctx)
+	if sq != nil {
+		// spilled over events stay on disk for the next start
+		sq.Stop(ctx)
+	}
+	tenantIds := make([]string, 0)
+	if ctx.Value(EelTenantIds) != nil {
+		tenantIds = ctx.Value(EelTenantIds).([]string)
+	}
+	drained := make(map[*WorkDispatcher]bool, 0)
+	leftovers := make([]*WorkRequest, 0)
+	for _, tenantId := range append([]string{""}, tenantIds...) {
+		if ctx.Value(EelDispatcher+"_"+tenantId) == nil {
+			continue
+		}
+		dp := ctx.Value(EelDispatcher + "_" + tenantId).(*WorkDispatcher)
+		if drained[dp] {
+			continue
+		}
+		drained[dp] = true
+		leftovers = append(leftovers, dp.Drain(ctx, deadline)...)
+	}
+	for _, work := range leftovers {
+		if work.Done != nil {
+			// not acknowledged at its source, will be delivered again after restart
+			work.Ctx.Log().Info("action", "abandoned", "cause", "shutting_down")
+			continue
+		}
+		if sq != nil {
+			if err := sq.Push(work.Ctx, work.Raw); err == nil {
+				work.Ctx.Log().Info("action", "spilled_over", "cause", "shutting_down")
+				continue
+			}
+		}
+		work.Ctx.Log().Error("error_type", "shutdown", "cause", "drain_deadline_exceeded", "action", "dropped", "trace.in.data", work.Raw)
+	}
+	StopInboundPlugins(ctx)
+	if sq != nil {
+		sq.Close(ctx)
+	}
+	ShutdownObservers(ctx)
+	ctx.Log().Info("action", "shut_down", "leftovers", len(leftovers), "elapsed", time.Since(start).String())
+}
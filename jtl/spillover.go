@@ -0,0 +1,578 @@
+/**
+ * Copyright 2015 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jtl
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/Comcast/eel/util"
+)
+
+type (
+	// SpillOverQueue is a persistent FIFO queue for events that could not be added to a full work queue. Events are
+	// appended to a segmented write-ahead log on disk and drained back into the work dispatcher of their tenant as soon
+	// as there is capacity. The position up to which all events have been handled is kept in a cursor file, segments
+	// before the cursor are deleted. Events are delivered at least once, events that were handed to a worker but not
+	// yet handled before a crash are delivered again after restart.
+	SpillOverQueue struct {
+		dir         string
+		segmentSize int64
+		maxSize     int64
+		segments    []int64 // ids of all segment files in order, the last one is being written to
+		writer      *os.File
+		writeSize   int64
+		size        int64 // size of the events waiting to be drained
+		readSegment int64 // segment of the next event
+		readOffset  int64 // offset of the next event in the read segment
+		reader      *os.File
+		head        *spillOverRecord
+		headSize    int64
+		depth       int
+		inflight    []*spillOverPosition // events handed to workers in order, the cursor advances once they are handled
+		notify      chan bool
+		quit        chan bool
+		done        chan bool
+		sync.Mutex
+	}
+	// SpillOverStats describes the backlog of a spill-over queue.
+	SpillOverStats struct {
+		Depth            int   // number of events waiting to be drained
+		InFlight         int   // number of drained events that have not been handled yet
+		OldestEventAgeMs int64 // time the oldest waiting event has spent in the queue
+		Segments         int
+		Size             int64 // size of the events waiting to be drained
+	}
+	spillOverRecord struct {
+		Time     int64 // unix time in ns when the event was spilled over
+		TenantId string
+		Header   http.Header
+		Query    url.Values
+		Raw      string
+	}
+	spillOverCursor struct {
+		Segment int64
+		Offset  int64
+	}
+	// spillOverPosition is the position after an event that has been handed to a worker
+	spillOverPosition struct {
+		spillOverCursor
+		handled bool
+	}
+)
+
+const (
+	spillOverSegmentExt         = ".wal"
+	spillOverCursorFile         = "cursor"
+	defaultSpillOverSegmentSize = 16 * 1024 * 1024
+)
+
+var ErrSpillOverQueueFull = errors.New("spill-over queue full")
+
+// NewSpillOverQueue opens (or creates) a spill-over queue in dir. Segments are rolled over once they exceed segmentSize bytes,
+// if maxSize is > 0 events are rejected once the size of the events waiting to be drained exceeds maxSize bytes. Call Start to begin draining.
+func NewSpillOverQueue(ctx Context, dir string, segmentSize int64, maxSize int64) (*SpillOverQueue, error) {
+	q := new(SpillOverQueue)
+	q.dir = dir
+	q.segmentSize = segmentSize
+	if q.segmentSize <= 0 {
+		q.segmentSize = defaultSpillOverSegmentSize
+	}
+	q.maxSize = maxSize
+	q.notify = make(chan bool, 1)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	err = q.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx.Log().Info("action", "open_spill_over_queue", "dir", dir, "depth", q.depth, "segments", len(q.segments))
+	return q, nil
+}
+
+// InitSpillOverQueue opens the spill-over queue configured in config.json (if any), adds it to the context and starts draining it.
+func InitSpillOverQueue(ctx Context) {
+	dir := GetConfig(ctx).SpillOverDir
+	if dir == "" {
+		return
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(BasePath, dir)
+	}
+	q, err := NewSpillOverQueue(ctx, dir, GetConfig(ctx).SpillOverSegmentSize, GetConfig(ctx).SpillOverMaxSize)
+	if err != nil {
+		ctx.Log().Error("error_type", "spill_over_queue", "cause", "open_failed", "dir", dir, "error", err.Error())
+		return
+	}
+	ctx.AddValue(EelSpillOverQueue, q)
+	q.Start(ctx)
+}
+
+// GetSpillOverQueue returns the spill-over queue from context or nil if spill-over is not enabled.
+func GetSpillOverQueue(ctx Context) *SpillOverQueue {
+	if ctx.Value(EelSpillOverQueue) != nil {
+		return ctx.Value(EelSpillOverQueue).(*SpillOverQueue)
+	}
+	return nil
+}
+
+func (q *SpillOverQueue) segmentFile(id int64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", id, spillOverSegmentExt))
+}
+
+func (q *SpillOverQueue) load(ctx Context) error {
+	files, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		return err
+	}
+	cursor := spillOverCursor{}
+	buf, err := ioutil.ReadFile(filepath.Join(q.dir, spillOverCursorFile))
+	if err == nil {
+		if jerr := json.Unmarshal(buf, &cursor); jerr != nil {
+			return jerr
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	q.segments = make([]int64, 0)
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), spillOverSegmentExt) {
+			continue
+		}
+		id, err := strconv.ParseInt(strings.TrimSuffix(f.Name(), spillOverSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		if id < cursor.Segment {
+			// drained but not yet deleted before shutdown
+			os.Remove(filepath.Join(q.dir, f.Name()))
+			continue
+		}
+		q.segments = append(q.segments, id)
+		q.size += f.Size()
+	}
+	sort.Slice(q.segments, func(i, j int) bool { return q.segments[i] < q.segments[j] })
+	if len(q.segments) > 0 && q.segments[0] == cursor.Segment {
+		q.readOffset = cursor.Offset
+		q.size -= cursor.Offset
+	}
+	for i, id := range q.segments {
+		offset := int64(0)
+		if i == 0 {
+			offset = q.readOffset
+		}
+		n, err := q.countRecords(ctx, id, offset)
+		if err != nil {
+			return err
+		}
+		q.depth += n
+	}
+	// never append to a segment written before a restart, its last line may be incomplete
+	id := cursor.Segment
+	if len(q.segments) > 0 {
+		id = q.segments[len(q.segments)-1] + 1
+	}
+	err = q.openSegment(id)
+	if err != nil {
+		return err
+	}
+	q.readSegment = q.segments[0]
+	return nil
+}
+
+func (q *SpillOverQueue) countRecords(ctx Context, id int64, offset int64) (int, error) {
+	file, err := os.Open(q.segmentFile(id))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	_, err = file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			count++
+		}
+		if err != nil {
+			break
+		}
+	}
+	return count, nil
+}
+
+func (q *SpillOverQueue) openSegment(id int64) error {
+	file, err := os.OpenFile(q.segmentFile(id), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if q.writer != nil {
+		q.writer.Close()
+	}
+	q.writer = file
+	q.writeSize = 0
+	q.segments = append(q.segments, id)
+	return nil
+}
+
+func (q *SpillOverQueue) saveCursor(cursor spillOverCursor) error {
+	buf, err := json.Marshal(&cursor)
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(q.dir, spillOverCursorFile+".tmp")
+	err = ioutil.WriteFile(tmp, buf, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(q.dir, spillOverCursorFile))
+}
+
+// Push appends an incoming event to the queue, the event is persisted when Push returns without error.
+func (q *SpillOverQueue) Push(ctx Context, raw string) error {
+	rec := &spillOverRecord{Time: time.Now().UnixNano(), Raw: raw}
+	if ctx.Value(EelTenantId) != nil {
+		rec.TenantId = ctx.Value(EelTenantId).(string)
+	}
+	if ctx.Value(EelRequestHeader) != nil {
+		rec.Header = ctx.Value(EelRequestHeader).(http.Header)
+	}
+	if ctx.Value(EelRequestQuery) != nil {
+		rec.Query = ctx.Value(EelRequestQuery).(url.Values)
+	}
+	buf, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	buf = append(buf, '\n')
+	q.Lock()
+	defer q.Unlock()
+	if q.writer == nil {
+		return errors.New("spill-over queue closed")
+	}
+	if q.maxSize > 0 && q.size+int64(len(buf)) > q.maxSize {
+		return ErrSpillOverQueueFull
+	}
+	if q.writeSize > 0 && q.writeSize+int64(len(buf)) > q.segmentSize {
+		err = q.openSegment(q.segments[len(q.segments)-1] + 1)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = q.writer.Write(buf)
+	if err != nil {
+		return err
+	}
+	err = q.writer.Sync()
+	if err != nil {
+		return err
+	}
+	q.writeSize += int64(len(buf))
+	q.size += int64(len(buf))
+	q.depth++
+	select {
+	case q.notify <- true:
+	default:
+	}
+	return nil
+}
+
+// peek returns the oldest event without removing it from the queue, or nil if the queue is empty.
+func (q *SpillOverQueue) peek(ctx Context) *spillOverRecord {
+	for q.head == nil && q.depth > 0 {
+		if q.reader == nil {
+			file, err := os.Open(q.segmentFile(q.readSegment))
+			if err != nil {
+				ctx.Log().Error("error_type", "spill_over_queue", "cause", "open_segment_failed", "dir", q.dir, "error", err.Error())
+				return nil
+			}
+			q.reader = file
+		}
+		_, err := q.reader.Seek(q.readOffset, io.SeekStart)
+		if err != nil {
+			ctx.Log().Error("error_type", "spill_over_queue", "cause", "read_failed", "dir", q.dir, "error", err.Error())
+			return nil
+		}
+		line, _ := bufio.NewReader(q.reader).ReadBytes('\n')
+		if len(line) == 0 || line[len(line)-1] != '\n' {
+			// end of segment (or incomplete line after a crash), continue with next segment, it is deleted once all
+			// of its events have been handled
+			next := q.nextSegment(q.readSegment)
+			if next < 0 {
+				return nil
+			}
+			q.reader.Close()
+			q.reader = nil
+			q.size -= int64(len(line))
+			q.readSegment = next
+			q.readOffset = 0
+			continue
+		}
+		var rec spillOverRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			ctx.Log().Error("error_type", "spill_over_queue", "cause", "invalid_record", "dir", q.dir, "error", err.Error())
+			q.head = &rec
+			q.headSize = int64(len(line))
+			q.handled(ctx, q.pop(ctx))
+			continue
+		}
+		q.head = &rec
+		q.headSize = int64(len(line))
+	}
+	return q.head
+}
+
+// nextSegment returns the id of the segment following id or -1 if id is the last segment.
+func (q *SpillOverQueue) nextSegment(id int64) int64 {
+	for i, s := range q.segments {
+		if s == id && i+1 < len(q.segments) {
+			return q.segments[i+1]
+		}
+	}
+	return -1
+}
+
+// pop removes the event returned by peek from the backlog. The event remains on disk until handled is called with the
+// returned position.
+func (q *SpillOverQueue) pop(ctx Context) *spillOverPosition {
+	if q.head == nil {
+		return nil
+	}
+	q.readOffset += q.headSize
+	q.size -= q.headSize
+	pos := &spillOverPosition{spillOverCursor: spillOverCursor{q.readSegment, q.readOffset}}
+	q.inflight = append(q.inflight, pos)
+	q.head = nil
+	q.headSize = 0
+	q.depth--
+	return pos
+}
+
+// unpop puts the event popped last back into the backlog because it could not be handed to a worker.
+func (q *SpillOverQueue) unpop(pos *spillOverPosition, rec *spillOverRecord, size int64) {
+	q.inflight = q.inflight[:len(q.inflight)-1]
+	q.readSegment = pos.Segment
+	q.readOffset = pos.Offset - size
+	q.size += size
+	q.head = rec
+	q.headSize = size
+	q.depth++
+}
+
+// handled marks a popped event as handled. The cursor is advanced past all events handled so far without gaps and
+// segments before the cursor are deleted.
+func (q *SpillOverQueue) handled(ctx Context, pos *spillOverPosition) {
+	if pos == nil {
+		return
+	}
+	pos.handled = true
+	var cursor *spillOverCursor
+	for len(q.inflight) > 0 && q.inflight[0].handled {
+		cursor = &q.inflight[0].spillOverCursor
+		q.inflight = q.inflight[1:]
+	}
+	if cursor == nil {
+		return
+	}
+	// a fully handled segment that is no longer written to can be deleted right away
+	if cursor.Segment != q.segments[len(q.segments)-1] {
+		if info, err := os.Stat(q.segmentFile(cursor.Segment)); err == nil && info.Size() <= cursor.Offset {
+			cursor = &spillOverCursor{q.nextSegment(cursor.Segment), 0}
+		}
+	}
+	err := q.saveCursor(*cursor)
+	if err != nil {
+		ctx.Log().Error("error_type", "spill_over_queue", "cause", "save_cursor_failed", "dir", q.dir, "error", err.Error())
+		return
+	}
+	for len(q.segments) > 1 && q.segments[0] < cursor.Segment {
+		os.Remove(q.segmentFile(q.segments[0]))
+		q.segments = q.segments[1:]
+	}
+}
+
+// Stats returns depth and age of the backlog.
+func (q *SpillOverQueue) Stats(ctx Context) *SpillOverStats {
+	q.Lock()
+	defer q.Unlock()
+	stats := &SpillOverStats{Depth: q.depth, InFlight: len(q.inflight), Segments: len(q.segments), Size: q.size}
+	if head := q.peek(ctx); head != nil {
+		stats.OldestEventAgeMs = int64(time.Since(time.Unix(0, head.Time)) / time.Millisecond)
+	}
+	return stats
+}
+
+// Start starts draining the queue into the work dispatchers.
+func (q *SpillOverQueue) Start(ctx Context) {
+	q.Lock()
+	defer q.Unlock()
+	if q.quit != nil {
+		return
+	}
+	q.quit = make(chan bool)
+	q.done = make(chan bool)
+	go q.drain(ctx, q.quit, q.done)
+}
+
+func (q *SpillOverQueue) drain(ctx Context, quit chan bool, done chan bool) {
+	defer close(done)
+	defer ctx.HandlePanic()
+	for {
+		q.Lock()
+		rec := q.peek(ctx)
+		q.Unlock()
+		if rec == nil {
+			select {
+			case <-q.notify:
+				continue
+			case <-quit:
+				return
+			}
+		}
+		work := q.newWorkRequest(ctx, rec)
+		if work == nil {
+			q.Lock()
+			q.handled(ctx, q.pop(ctx))
+			q.Unlock()
+			continue
+		}
+		dp := GetWorkDispatcher(work.Ctx, rec.TenantId)
+		if dp == nil {
+			work.Ctx.Log().Error("error_type", "spill_over_queue", "cause", "no_work_dispatcher", "tenant_id", rec.TenantId)
+			q.Lock()
+			q.handled(ctx, q.pop(ctx))
+			q.Unlock()
+			continue
+		}
+		// the event is removed from the backlog before it is handed over, a worker may already be done with it before
+		// this goroutine gets the lock again
+		q.Lock()
+		size := q.headSize
+		pos := q.pop(ctx)
+		q.Unlock()
+		work.Done = func() {
+			q.Lock()
+			defer q.Unlock()
+			q.handled(ctx, pos)
+		}
+		select {
+		case dp.WorkQueue <- work:
+			work.Ctx.Log().Info("action", "drained", "op", "spill_over")
+		case <-time.After(time.Millisecond * time.Duration(GetConfig(ctx).MessageQueueTimeout)):
+			// still no capacity, look up the dispatcher again in case it was replaced by a config reload
+			q.Lock()
+			q.unpop(pos, rec, size)
+			q.Unlock()
+		case <-quit:
+			q.Lock()
+			q.unpop(pos, rec, size)
+			q.Unlock()
+			return
+		}
+	}
+}
+
+// newWorkRequest restores the context of a spilled over event the same way HandleEvent does for incoming requests.
+func (q *SpillOverQueue) newWorkRequest(ctx Context, rec *spillOverRecord) *WorkRequest {
+	sctx := ctx.SubContext()
+	conf := GetConfig(sctx)
+	header := rec.Header
+	if header == nil {
+		header = http.Header{}
+	}
+	sctx.AddValue("start_ts", rec.Time)
+	sctx.AddValue(EelRequestHeader, header)
+	sctx.AddValue(EelRequestQuery, rec.Query)
+	traceId := header.Get(conf.HttpTransactionHeader)
+	if traceId == "" {
+		traceId = sctx.Id()
+	}
+	sctx.AddLogValue("tx.traceId", traceId)
+	sctx.AddValue("tx.traceId", traceId)
+	sctx.AddValue(conf.HttpTransactionHeader, traceId)
+	if rec.TenantId != "" {
+		sctx.AddValue(EelTenantId, rec.TenantId)
+		sctx.AddLogValue(LogTenantId, ExtractAppId(rec.TenantId, conf.AllowPartner))
+	}
+	if header.Get(conf.HttpTenantHeader) != "" {
+		sctx.AddValue(conf.HttpTenantHeader, header.Get(conf.HttpTenantHeader))
+	}
+	if header.Get(conf.HttpPartnerHeader) != "" {
+		sctx.AddValue(EelPartnerId, header.Get(conf.HttpPartnerHeader))
+		sctx.AddValue(conf.HttpPartnerHeader, header.Get(conf.HttpPartnerHeader))
+		sctx.AddLogValue(LogPartnerId, header.Get(conf.HttpPartnerHeader))
+	}
+	evt, err := NewJDocFromString(rec.Raw)
+	if err != nil {
+		sctx.Log().Error("error_type", "spill_over_queue", "cause", "invalid_json", "error", err.Error(), "trace.in.data", rec.Raw)
+		return nil
+	}
+	if conf.LogParams != nil {
+		for k, v := range conf.LogParams {
+			ev := evt.ParseExpression(sctx, v)
+			sctx.AddLogValue(k, ev)
+		}
+	}
+	return &WorkRequest{Raw: rec.Raw, Event: evt, Ctx: sctx}
+}
+
+// Stop stops draining the queue, events can still be added until the queue is closed.
+func (q *SpillOverQueue) Stop(ctx Context) {
+	q.Lock()
+	quit := q.quit
+	done := q.done
+	q.quit = nil
+	q.Unlock()
+	if quit != nil {
+		close(quit)
+		<-done
+	}
+}
+
+// Close stops draining and closes the queue, events that have not been drained yet remain on disk.
+func (q *SpillOverQueue) Close(ctx Context) {
+	q.Stop(ctx)
+	q.Lock()
+	defer q.Unlock()
+	if q.writer != nil {
+		q.writer.Close()
+		q.writer = nil
+	}
+	if q.reader != nil {
+		q.reader.Close()
+		q.reader = nil
+	}
+	ctx.Log().Info("action", "close_spill_over_queue", "dir", q.dir, "depth", q.depth)
+}
@@ -0,0 +1,374 @@
+/**
+ * Copyright 2015 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jtl
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	. "github.com/Comcast/eel/util"
+)
+
+// maxPadLength is the maximum length of strings created by padleft() and padright()
+const maxPadLength = 100000
+
+func init() {
+	// split string into array of strings: split('<string>', '<separator>')
+	RegisterTypedFunction("split", fnSplit, 2, 2, ResultArray)
+	// replace first match of regex: replace('<string>', '<regex>', '<replacement>'), replacement may contain $1 etc.
+	RegisterTypedFunction("replace", fnReplace, 3, 3, ResultString)
+	// replace all matches of regex: replaceall('<string>', '<regex>', '<replacement>'), replacement may contain $1 etc.
+	RegisterTypedFunction("replaceall", fnReplaceAll, 3, 3, ResultString)
+	// trim leading and trailing white space or characters in cutset: trim('<string>', ['<cutset>'])
+	RegisterTypedFunction("trim", fnTrim, 1, 2, ResultString)
+	// trim leading white space or characters in cutset: trimleft('<string>', ['<cutset>'])
+	RegisterTypedFunction("trimleft", fnTrimLeft, 1, 2, ResultString)
+	// trim trailing white space or characters in cutset: trimright('<string>', ['<cutset>'])
+	RegisterTypedFunction("trimright", fnTrimRight, 1, 2, ResultString)
+	// check whether or not a string has prefix: hasprefix('<string>', '<prefix>')
+	RegisterTypedFunction("hasprefix", fnHasPrefix, 2, 2, ResultBoolean)
+	// index of first occurrence of substring or -1: indexof('<string>', '<substring>')
+	RegisterTypedFunction("indexof", fnIndexOf, 2, 2, ResultNumber)
+	// pad string on the left to length: padleft('<string>', '<length>', ['<pad>'])
+	RegisterTypedFunction("padleft", fnPadLeft, 2, 3, ResultString)
+	// pad string on the right to length: padright('<string>', '<length>', ['<pad>'])
+	RegisterTypedFunction("padright", fnPadRight, 2, 3, ResultString)
+	// format string: sprintf('<format>', ['<arg>'], ...), example sprintf('%05d-%s', '42', 'foo')
+	RegisterTypedFunction("sprintf", fnSprintf, 1, 100, ResultString)
+	// url encode string for use in query parameters: urlencode('<string>')
+	RegisterTypedFunction("urlencode", fnUrlEncode, 1, 1, ResultString)
+	// url decode string: urldecode('<string>')
+	RegisterTypedFunction("urldecode", fnUrlDecode, 1, 1, ResultString)
+	// base64 encode input string, example base64encode('foo')
+	RegisterTypedFunction("base64encode", fnBase64Encode, 1, 1, ResultString)
+	// escape string for use inside of a json string: jsonescape('<string>')
+	RegisterTypedFunction("jsonescape", fnJsonEscape, 1, 1, ResultString)
+	// concatenate strings: concat('<string>', '<string>', ...)
+	RegisterTypedFunction("concat", fnConcat, 1, 100, ResultString)
+}
+
+// fnSplit splits a string into an array of strings.
+func fnSplit(ctx Context, doc *JDoc, params []string) interface{} {
+	stats := ctx.Value(EelTotalStats).(*ServiceStats)
+	if params == nil || len(params) != 2 {
+		ctx.Log().Error("error_type", "func_split", "op", "split", "cause", "wrong_number_of_parameters", "params", params)
+		stats.IncErrors()
+		AddError(ctx, SyntaxError{fmt.Sprintf("wrong number of parameters in call to split function"), "split", params})
+		return nil
+	}
+	parts := strings.Split(extractStringParam(params[0]), extractStringParam(params[1]))
+	res := make([]interface{}, 0, len(parts))
+	for _, p := range parts {
+		res = append(res, p)
+	}
+	return res
+}
+
+// fnReplace replaces the first match of a regular expression.
+func fnReplace(ctx Context, doc *JDoc, params []string) interface{} {
+	stats := ctx.Value(EelTotalStats).(*ServiceStats)
+	if params == nil || len(params) != 3 {
+		ctx.Log().Error("error_type", "func_replace", "op", "replace", "cause", "wrong_number_of_parameters", "params", params)
+		stats.IncErrors()
+		AddError(ctx, SyntaxError{fmt.Sprintf("wrong number of parameters in call to replace function"), "replace", params})
+		return nil
+	}
+	reg, err := regexp.Compile(extractStringParam(params[1]))
+	if err != nil {
+		ctx.Log().Error("error_type", "func_replace", "op", "replace", "cause", "invalid_regex", "params", params, "error", err.Error())
+		stats.IncErrors()
+		AddError(ctx, RuntimeError{fmt.Sprintf("invalid regex in call to replace function: %s", err.Error()), "replace", params})
+		return nil
+	}
+	s := extractStringParam(params[0])
+	loc := reg.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return s
+	}
+	return s[:loc[0]] + string(reg.ExpandString(nil, extractStringParam(params[2]), s, loc)) + s[loc[1]:]
+}
+
+// fnReplaceAll replaces all matches of a regular expression.
+func fnReplaceAll(ctx Context, doc *JDoc, params []string) interface{} {
+	stats := ctx.Value(EelTotalStats).(*ServiceStats)
+	if params == nil || len(params) != 3 {
+		ctx.Log().Error("error_type", "func_replaceall", "op", "replaceall", "cause", "wrong_number_of_parameters", "params", params)
+		stats.IncErrors()
+		AddError(ctx, SyntaxError{fmt.Sprintf("wrong number of parameters in call to replaceall function"), "replaceall", params})
+		return nil
+	}
+	reg, err := regexp.Compile(extractStringParam(params[1]))
+	if err != nil {
+		ctx.Log().Error("error_type", "func_replaceall", "op", "replaceall", "cause", "invalid_regex", "params", params, "error", err.Error())
+		stats.IncErrors()
+		AddError(ctx, RuntimeError{fmt.Sprintf("invalid regex in call to replaceall function: %s", err.Error()), "replaceall", params})
+		return nil
+	}
+	return reg.ReplaceAllString(extractStringParam(params[0]), extractStringParam(params[2]))
+}
+
+// fnTrim trims leading and trailing white space or characters in cutset.
+func fnTrim(ctx Context, doc *JDoc, params []string) interface{} {
+	return trimString(ctx, "trim", params, strings.TrimSpace, strings.Trim)
+}
+
+// fnTrimLeft trims leading white space or characters in cutset.
+func fnTrimLeft(ctx Context, doc *JDoc, params []string) interface{} {
+	return trimString(ctx, "trimleft", params, func(s string) string {
+		return strings.TrimLeft(s, " \t\r\n\v\f")
+	}, strings.TrimLeft)
+}
+
+// fnTrimRight trims trailing white space or characters in cutset.
+func fnTrimRight(ctx Context, doc *JDoc, params []string) interface{} {
+	return trimString(ctx, "trimright", params, func(s string) string {
+		return strings.TrimRight(s, " \t\r\n\v\f")
+	}, strings.TrimRight)
+}
+
+func trimString(ctx Context, op string, params []string, trimSpace func(string) string, trimCutset func(string, string) string) interface{} {
+	stats := ctx.Value(EelTotalStats).(*ServiceStats)
+	if params == nil || len(params) == 0 || len(params) > 2 {
+		ctx.Log().Error("error_type", "func_"+op, "op", op, "cause", "wrong_number_of_parameters", "params", params)
+		stats.IncErrors()
+		AddError(ctx, SyntaxError{fmt.Sprintf("wrong number of parameters in call to %s function", op), op, params})
+		return ""
+	}
+	if len(params) == 1 {
+		return trimSpace(extractStringParam(params[0]))
+	}
+	return trimCutset(extractStringParam(params[0]), extractStringParam(params[1]))
+}
+
+// fnHasPrefix check whether a string starts with prefix.
+func fnHasPrefix(ctx Context, doc *JDoc, params []string) interface{} {
+	stats := ctx.Value(EelTotalStats).(*ServiceStats)
+	if params == nil || len(params) != 2 {
+		ctx.Log().Error("error_type", "func_hasprefix", "op", "hasprefix", "cause", "wrong_number_of_parameters", "params", params)
+		stats.IncErrors()
+		AddError(ctx, SyntaxError{fmt.Sprintf("wrong number of parameters in call to hasprefix function"), "hasprefix", params})
+		return nil
+	}
+	return strings.HasPrefix(extractStringParam(params[0]), extractStringParam(params[1]))
+}
+
+// fnIndexOf returns the index of the first occurrence of a substring or -1. Like substr() the index is a byte index.
+func fnIndexOf(ctx Context, doc *JDoc, params []string) interface{} {
+	stats := ctx.Value(EelTotalStats).(*ServiceStats)
+	if params == nil || len(params) != 2 {
+		ctx.Log().Error("error_type", "func_indexof", "op", "indexof", "cause", "wrong_number_of_parameters", "params", params)
+		stats.IncErrors()
+		AddError(ctx, SyntaxError{fmt.Sprintf("wrong number of parameters in call to indexof function"), "indexof", params})
+		return nil
+	}
+	return strings.Index(extractStringParam(params[0]), extractStringParam(params[1]))
+}
+
+// fnPadLeft pads a string on the left to the given length (in characters).
+func fnPadLeft(ctx Context, doc *JDoc, params []string) interface{} {
+	return padString(ctx, "padleft", params, true)
+}
+
+// fnPadRight pads a string on the right to the given length (in characters).
+func fnPadRight(ctx Context, doc *JDoc, params []string) interface{} {
+	return padString(ctx, "padright", params, false)
+}
+
+func padString(ctx Context, op string, params []string, left bool) interface{} {
+	stats := ctx.Value(EelTotalStats).(*ServiceStats)
+	if params == nil || len(params) < 2 || len(params) > 3 {
+		ctx.Log().Error("error_type", "func_"+op, "op", op, "cause", "wrong_number_of_parameters", "params", params)
+		stats.IncErrors()
+		AddError(ctx, SyntaxError{fmt.Sprintf("wrong number of parameters in call to %s function", op), op, params})
+		return ""
+	}
+	s := extractStringParam(params[0])
+	length, err := strconv.Atoi(extractStringParam(params[1]))
+	if err != nil {
+		ctx.Log().Error("error_type", "func_"+op, "op", op, "cause", "param_not_int", "params", params, "error", err.Error())
+		stats.IncErrors()
+		AddError(ctx, SyntaxError{fmt.Sprintf("non int parameters in call to %s function", op), op, params})
+		return ""
+	}
+	if length > maxPadLength {
+		ctx.Log().Error("error_type", "func_"+op, "op", op, "cause", "length_too_long", "params", params)
+		stats.IncErrors()
+		AddError(ctx, RuntimeError{fmt.Sprintf("length of more than %d in call to %s function", maxPadLength, op), op, params})
+		return ""
+	}
+	pad := " "
+	if len(params) == 3 {
+		pad = extractStringParam(params[2])
+	}
+	if pad == "" {
+		ctx.Log().Error("error_type", "func_"+op, "op", op, "cause", "blank_pad", "params", params)
+		stats.IncErrors()
+		AddError(ctx, SyntaxError{fmt.Sprintf("blank pad in call to %s function", op), op, params})
+		return ""
+	}
+	missing := length - utf8.RuneCountInString(s)
+	if missing <= 0 {
+		return s
+	}
+	padding := []rune(strings.Repeat(pad, missing/utf8.RuneCountInString(pad)+1))[:missing]
+	if left {
+		return string(padding) + s
+	}
+	return s + string(padding)
+}
+
+// sprintfArg is a string parameter of sprintf() which is formatted as integer, float or string depending on the verb.
+type sprintfArg string
+
+func (a sprintfArg) Format(f fmt.State, verb rune) {
+	format := "%"
+	for _, flag := range "+-# 0" {
+		if f.Flag(int(flag)) {
+			format += string(flag)
+		}
+	}
+	if w, ok := f.Width(); ok {
+		format += strconv.Itoa(w)
+	}
+	if p, ok := f.Precision(); ok {
+		format += "." + strconv.Itoa(p)
+	}
+	format += string(verb)
+	var val interface{} = string(a)
+	switch verb {
+	case 'd', 'b', 'o', 'x', 'X', 'c':
+		if i, err := strconv.ParseInt(string(a), 10, 64); err == nil {
+			val = i
+		} else if fl, err := strconv.ParseFloat(string(a), 64); err == nil {
+			val = int64(fl)
+		}
+	case 'e', 'E', 'f', 'F', 'g', 'G':
+		if fl, err := strconv.ParseFloat(string(a), 64); err == nil {
+			val = fl
+		}
+	case 't':
+		if b, err := strconv.ParseBool(string(a)); err == nil {
+			val = b
+		}
+	}
+	fmt.Fprintf(f, format, val)
+}
+
+// fnSprintf formats a string like fmt.Sprintf. Parameters are converted to numbers or booleans as required by the verbs.
+func fnSprintf(ctx Context, doc *JDoc, params []string) interface{} {
+	stats := ctx.Value(EelTotalStats).(*ServiceStats)
+	if params == nil || len(params) == 0 {
+		ctx.Log().Error("error_type", "func_sprintf", "op", "sprintf", "cause", "wrong_number_of_parameters", "params", params)
+		stats.IncErrors()
+		AddError(ctx, SyntaxError{fmt.Sprintf("wrong number of parameters in call to sprintf function"), "sprintf", params})
+		return ""
+	}
+	args := make([]interface{}, 0, len(params)-1)
+	for _, p := range params[1:] {
+		args = append(args, sprintfArg(extractStringParam(p)))
+	}
+	res := fmt.Sprintf(extractStringParam(params[0]), args...)
+	if strings.Contains(res, "%!") {
+		ctx.Log().Error("error_type", "func_sprintf", "op", "sprintf", "cause", "bad_format", "params", params, "result", res)
+		stats.IncErrors()
+		AddError(ctx, RuntimeError{fmt.Sprintf("bad format or parameters in call to sprintf function: %s", res), "sprintf", params})
+	}
+	return res
+}
+
+// fnUrlEncode url encodes a string for use in query parameters.
+func fnUrlEncode(ctx Context, doc *JDoc, params []string) interface{} {
+	stats := ctx.Value(EelTotalStats).(*ServiceStats)
+	if params == nil || len(params) != 1 {
+		ctx.Log().Error("error_type", "func_urlencode", "op", "urlencode", "cause", "wrong_number_of_parameters", "params", params)
+		stats.IncErrors()
+		AddError(ctx, SyntaxError{fmt.Sprintf("wrong number of parameters in call to urlencode function"), "urlencode", params})
+		return ""
+	}
+	return url.QueryEscape(extractStringParam(params[0]))
+}
+
+// fnUrlDecode decodes an url encoded string.
+func fnUrlDecode(ctx Context, doc *JDoc, params []string) interface{} {
+	stats := ctx.Value(EelTotalStats).(*ServiceStats)
+	if params == nil || len(params) != 1 {
+		ctx.Log().Error("error_type", "func_urldecode", "op", "urldecode", "cause", "wrong_number_of_parameters", "params", params)
+		stats.IncErrors()
+		AddError(ctx, SyntaxError{fmt.Sprintf("wrong number of parameters in call to urldecode function"), "urldecode", params})
+		return ""
+	}
+	s, err := url.QueryUnescape(extractStringParam(params[0]))
+	if err != nil {
+		ctx.Log().Error("error_type", "func_urldecode", "op", "urldecode", "cause", "error_decode", "params", params, "error", err.Error())
+		stats.IncErrors()
+		AddError(ctx, RuntimeError{err.Error(), "urldecode", params})
+		return ""
+	}
+	return s
+}
+
+// fnBase64Encode function to base64 encode a string.
+func fnBase64Encode(ctx Context, doc *JDoc, params []string) interface{} {
+	stats := ctx.Value(EelTotalStats).(*ServiceStats)
+	if params == nil || len(params) != 1 {
+		ctx.Log().Error("error_type", "func_base64encode", "op", "base64encode", "cause", "wrong_number_of_parameters", "params", params)
+		stats.IncErrors()
+		AddError(ctx, SyntaxError{fmt.Sprintf("wrong number of parameters in call to base64encode function"), "base64encode", params})
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString([]byte(extractStringParam(params[0])))
+}
+
+// fnJsonEscape escapes quotes, back slashes and control characters for use inside of a json string.
+func fnJsonEscape(ctx Context, doc *JDoc, params []string) interface{} {
+	stats := ctx.Value(EelTotalStats).(*ServiceStats)
+	if params == nil || len(params) != 1 {
+		ctx.Log().Error("error_type", "func_jsonescape", "op", "jsonescape", "cause", "wrong_number_of_parameters", "params", params)
+		stats.IncErrors()
+		AddError(ctx, SyntaxError{fmt.Sprintf("wrong number of parameters in call to jsonescape function"), "jsonescape", params})
+		return ""
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(extractStringParam(params[0]))
+	s := strings.TrimSuffix(buf.String(), "\n")
+	return escapedString(s[1 : len(s)-1])
+}
+
+// fnConcat concatenates all parameters.
+func fnConcat(ctx Context, doc *JDoc, params []string) interface{} {
+	stats := ctx.Value(EelTotalStats).(*ServiceStats)
+	if params == nil || len(params) == 0 {
+		ctx.Log().Error("error_type", "func_concat", "op", "concat", "cause", "wrong_number_of_parameters", "params", params)
+		stats.IncErrors()
+		AddError(ctx, SyntaxError{fmt.Sprintf("wrong number of parameters in call to concat function"), "concat", params})
+		return ""
+	}
+	res := ""
+	for _, p := range params {
+		res += extractStringParam(p)
+	}
+	return res
+}
@@ -0,0 +1,414 @@
+/**
+ * Copyright 2015 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jtl
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	// embedded IANA time zone database in case the host does not provide one
+	_ "time/tzdata"
+
+	. "github.com/Comcast/eel/util"
+)
+
+var (
+	// named layouts which may be used instead of go layouts by example
+	namedTimeLayouts = map[string]string{
+		"ANSIC":       time.ANSIC,
+		"UnixDate":    time.UnixDate,
+		"RubyDate":    time.RubyDate,
+		"RFC822":      time.RFC822,
+		"RFC822Z":     time.RFC822Z,
+		"RFC850":      time.RFC850,
+		"RFC1123":     time.RFC1123,
+		"RFC1123Z":    time.RFC1123Z,
+		"RFC3339":     time.RFC3339,
+		"RFC3339Nano": time.RFC3339Nano,
+		"ISO8601":     "2006-01-02T15:04:05Z0700",
+		"Kitchen":     time.Kitchen,
+		"DateTime":    "2006-01-02 15:04:05",
+		"DateOnly":    "2006-01-02",
+		"TimeOnly":    "15:04:05",
+	}
+	// layouts tried in order when parsing time stamps without layout
+	autoTimeLayouts = []string{
+		time.RFC3339Nano,
+		"2006-01-02T15:04:05.999999999Z0700",
+		"2006-01-02T15:04:05.999999999",
+		"2006-01-02T15:04Z07:00",
+		"2006-01-02T15:04",
+		"2006-01-02 15:04:05.999999999Z07:00",
+		"2006-01-02 15:04:05.999999999Z0700",
+		"2006-01-02 15:04:05.999999999 Z0700",
+		"2006-01-02 15:04:05.999999999",
+		"20060102T150405.999999999Z0700",
+		"20060102T150405.999999999",
+		"2006-01-02",
+		"20060102",
+		time.RFC1123Z,
+		time.RFC1123,
+		time.RFC850,
+		time.RFC822Z,
+		time.RFC822,
+		time.RubyDate,
+		time.UnixDate,
+		time.ANSIC,
+	}
+	// units of timediff() and epoch()
+	timeUnits = map[string]time.Duration{
+		"ns":      time.Nanosecond,
+		"nanos":   time.Nanosecond,
+		"us":      time.Microsecond,
+		"micros":  time.Microsecond,
+		"ms":      time.Millisecond,
+		"millis":  time.Millisecond,
+		"s":       time.Second,
+		"seconds": time.Second,
+		"m":       time.Minute,
+		"minutes": time.Minute,
+		"h":       time.Hour,
+		"hours":   time.Hour,
+		"d":       24 * time.Hour,
+		"days":    24 * time.Hour,
+		"w":       7 * 24 * time.Hour,
+		"weeks":   7 * 24 * time.Hour,
+	}
+	epochReg    = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+	durationReg = regexp.MustCompile(`([0-9]*\.?[0-9]+)([dw])`)
+)
+
+func init() {
+	// current time: now(['<layout>'], ['<time zone>']), RFC3339 in UTC by default
+	RegisterTypedFunction("now", fnNow, 0, 2, ResultString)
+	// parse time stamp and normalize to RFC3339: parsetime('<time>', ['<layout>'], ['<time zone>']), layout is auto detected if omitted
+	RegisterTypedFunction("parsetime", fnParseTime, 1, 3, ResultString)
+	// add duration such as 1h30m, -15m or 2d to time stamp: addduration('<time>', '<duration>')
+	RegisterTypedFunction("addduration", fnAddDuration, 2, 2, ResultString)
+	// difference a - b in unit (default s): timediff('<time a>', '<time b>', ['<unit>'])
+	RegisterTypedFunction("timediff", fnTimeDiff, 2, 3, ResultNumber)
+	// truncate time stamp to start of second, minute, hour, day, week, month or year: truncate('<time>', '<unit>', ['<time zone>'])
+	RegisterTypedFunction("truncate", fnTruncate, 2, 3, ResultString)
+	// day of the week such as Monday: weekday('<time>', ['<time zone>'])
+	RegisterTypedFunction("weekday", fnWeekday, 1, 2, ResultString)
+	// unix time in s (default), ms, us or ns: epoch('<time>', ['<unit>'])
+	RegisterTypedFunction("epoch", fnEpoch, 1, 2, ResultNumber)
+}
+
+// timeFunctionError logs and reports a runtime error of a time function.
+func timeFunctionError(ctx Context, op string, params []string, cause string, msg string) {
+	stats := ctx.Value(EelTotalStats).(*ServiceStats)
+	ctx.Log().Error("error_type", "func_"+op, "op", op, "cause", cause, "params", params)
+	stats.IncErrors()
+	AddError(ctx, RuntimeError{fmt.Sprintf("%s in call to %s function", msg, op), op, params})
+}
+
+// locationParam loads an IANA time zone such as America/New_York. Blank is UTC.
+func locationParam(ctx Context, op string, params []string, param string) (*time.Location, bool) {
+	name := strings.TrimSpace(extractStringParam(param))
+	if name == "" {
+		return time.UTC, true
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		timeFunctionError(ctx, op, params, "unknown_time_zone", fmt.Sprintf("unknown time zone %s", name))
+		return nil, false
+	}
+	return loc, true
+}
+
+// timeLayout returns the go layout for a named layout such as RFC3339 or the layout itself.
+func timeLayout(layout string) string {
+	if l, ok := namedTimeLayouts[layout]; ok {
+		return l
+	}
+	return layout
+}
+
+// parseTime parses a time stamp with the given layout or, if the layout is blank, with auto detection of RFC3339,
+// ISO-8601, RFC1123 and similar formats and of unix time stamps in seconds, milliseconds, microseconds or
+// nanoseconds. Time stamps without zone are in location loc.
+func parseTime(s string, layout string, loc *time.Location) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if layout != "" {
+		return time.ParseInLocation(timeLayout(layout), s, loc)
+	}
+	if epochReg.MatchString(s) {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		// guess the unit from the magnitude, 1e11 seconds is in the year 5138
+		var perSecond int64
+		switch abs := math.Abs(f); {
+		case abs < 1e11:
+			perSecond = 1
+		case abs < 1e14:
+			perSecond = 1e3
+		case abs < 1e17:
+			perSecond = 1e6
+		default:
+			perSecond = 1e9
+		}
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return time.Unix(i/perSecond, (i%perSecond)*(1e9/perSecond)).In(loc), nil
+		}
+		sec, frac := math.Modf(f / float64(perSecond))
+		return time.Unix(int64(sec), int64(math.Round(frac*1e9))).In(loc), nil
+	}
+	for _, l := range autoTimeLayouts {
+		if t, err := time.ParseInLocation(l, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unknown time format %s", s)
+}
+
+// timeParam parses a time stamp parameter with auto detection of the format.
+func timeParam(ctx Context, op string, params []string, param string) (time.Time, bool) {
+	t, err := parseTime(extractStringParam(param), "", time.UTC)
+	if err != nil {
+		timeFunctionError(ctx, op, params, "invalid_time", fmt.Sprintf("invalid time %s", extractStringParam(param)))
+		return t, false
+	}
+	return t, true
+}
+
+// formatTime formats time stamps returned by time functions.
+func formatTime(t time.Time) string {
+	return t.Format(time.RFC3339Nano)
+}
+
+// parseDuration parses go durations such as 1h30m or -15m and additionally supports days (d) and weeks (w).
+func parseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	sign := time.Duration(1)
+	if strings.HasPrefix(s, "-") {
+		sign = -1
+		s = s[1:]
+	}
+	var days time.Duration
+	var err error
+	s = durationReg.ReplaceAllStringFunc(s, func(m string) string {
+		n, e := strconv.ParseFloat(m[:len(m)-1], 64)
+		if e != nil {
+			err = e
+		}
+		unit := 24 * time.Hour
+		if strings.HasSuffix(m, "w") {
+			unit *= 7
+		}
+		days += time.Duration(n * float64(unit))
+		return ""
+	})
+	if err != nil {
+		return 0, err
+	}
+	if s == "" {
+		return sign * days, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	return sign * (days + d), nil
+}
+
+// fnNow returns the current time formatted with layout (default RFC3339) in time zone (default UTC).
+func fnNow(ctx Context, doc *JDoc, params []string) interface{} {
+	// parameter-less functions are called with a single blank parameter
+	if len(params) == 1 && params[0] == "" {
+		params = []string{}
+	}
+	if !checkParamCount(ctx, "now", params, 0, 2) {
+		return nil
+	}
+	layout := time.RFC3339Nano
+	if len(params) >= 1 && extractStringParam(params[0]) != "" {
+		layout = timeLayout(extractStringParam(params[0]))
+	}
+	loc := time.UTC
+	if len(params) == 2 {
+		var ok bool
+		if loc, ok = locationParam(ctx, "now", params, params[1]); !ok {
+			return nil
+		}
+	}
+	return time.Now().In(loc).Format(layout)
+}
+
+// fnParseTime parses a time stamp with layout or auto detection and returns it as RFC3339 time stamp. Time stamps
+// without zone are interpreted in the time zone parameter (default UTC) and all time stamps are converted to it.
+func fnParseTime(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "parsetime", params, 1, 3) {
+		return nil
+	}
+	layout := ""
+	if len(params) >= 2 {
+		layout = extractStringParam(params[1])
+	}
+	loc := time.UTC
+	if len(params) == 3 {
+		var ok bool
+		if loc, ok = locationParam(ctx, "parsetime", params, params[2]); !ok {
+			return nil
+		}
+	}
+	t, err := parseTime(extractStringParam(params[0]), layout, loc)
+	if err != nil {
+		timeFunctionError(ctx, "parsetime", params, "invalid_time", fmt.Sprintf("invalid time %s", extractStringParam(params[0])))
+		return nil
+	}
+	return formatTime(t.In(loc))
+}
+
+// fnAddDuration adds a duration to a time stamp, keeping the zone of the time stamp.
+func fnAddDuration(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "addduration", params, 2, 2) {
+		return nil
+	}
+	t, ok := timeParam(ctx, "addduration", params, params[0])
+	if !ok {
+		return nil
+	}
+	d, err := parseDuration(extractStringParam(params[1]))
+	if err != nil {
+		timeFunctionError(ctx, "addduration", params, "invalid_duration", fmt.Sprintf("invalid duration %s", extractStringParam(params[1])))
+		return nil
+	}
+	return formatTime(t.Add(d))
+}
+
+// fnTimeDiff returns the difference a - b of two time stamps in the given unit (default s). The result is an integer if
+// the difference is a whole number of units.
+func fnTimeDiff(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "timediff", params, 2, 3) {
+		return nil
+	}
+	a, ok := timeParam(ctx, "timediff", params, params[0])
+	if !ok {
+		return nil
+	}
+	b, ok := timeParam(ctx, "timediff", params, params[1])
+	if !ok {
+		return nil
+	}
+	unit := time.Second
+	if len(params) == 3 {
+		name := strings.TrimSpace(extractStringParam(params[2]))
+		if unit, ok = timeUnits[name]; !ok {
+			timeFunctionError(ctx, "timediff", params, "unknown_unit", fmt.Sprintf("unknown unit %s", name))
+			return nil
+		}
+	}
+	d := a.Sub(b)
+	if d%unit == 0 {
+		return int(d / unit)
+	}
+	return float64(d) / float64(unit)
+}
+
+// fnTruncate truncates a time stamp to the start of the second, minute, hour, day, week (Monday), month or year in the
+// time zone parameter or, if omitted, in the zone of the time stamp.
+func fnTruncate(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "truncate", params, 2, 3) {
+		return nil
+	}
+	t, ok := timeParam(ctx, "truncate", params, params[0])
+	if !ok {
+		return nil
+	}
+	if len(params) == 3 {
+		loc, ok := locationParam(ctx, "truncate", params, params[2])
+		if !ok {
+			return nil
+		}
+		t = t.In(loc)
+	}
+	y, mo, d := t.Date()
+	h, mi, s := t.Clock()
+	switch unit := strings.TrimSpace(extractStringParam(params[1])); unit {
+	case "second":
+		t = time.Date(y, mo, d, h, mi, s, 0, t.Location())
+	case "minute":
+		t = time.Date(y, mo, d, h, mi, 0, 0, t.Location())
+	case "hour":
+		t = time.Date(y, mo, d, h, 0, 0, 0, t.Location())
+	case "day":
+		t = time.Date(y, mo, d, 0, 0, 0, 0, t.Location())
+	case "week":
+		t = time.Date(y, mo, d-(int(t.Weekday())+6)%7, 0, 0, 0, 0, t.Location())
+	case "month":
+		t = time.Date(y, mo, 1, 0, 0, 0, 0, t.Location())
+	case "year":
+		t = time.Date(y, time.January, 1, 0, 0, 0, 0, t.Location())
+	default:
+		timeFunctionError(ctx, "truncate", params, "unknown_unit", fmt.Sprintf("unknown unit %s", unit))
+		return nil
+	}
+	return formatTime(t)
+}
+
+// fnWeekday returns the english name of the day of the week of a time stamp, in the time zone parameter or, if
+// omitted, in the zone of the time stamp.
+func fnWeekday(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "weekday", params, 1, 2) {
+		return nil
+	}
+	t, ok := timeParam(ctx, "weekday", params, params[0])
+	if !ok {
+		return nil
+	}
+	if len(params) == 2 {
+		loc, ok := locationParam(ctx, "weekday", params, params[1])
+		if !ok {
+			return nil
+		}
+		t = t.In(loc)
+	}
+	return t.Weekday().String()
+}
+
+// fnEpoch returns the unix time of a time stamp in s (default), ms, us or ns.
+func fnEpoch(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "epoch", params, 1, 2) {
+		return nil
+	}
+	t, ok := timeParam(ctx, "epoch", params, params[0])
+	if !ok {
+		return nil
+	}
+	unit := "s"
+	if len(params) == 2 {
+		unit = strings.TrimSpace(extractStringParam(params[1]))
+	}
+	switch unit {
+	case "s", "seconds":
+		return int(t.Unix())
+	case "ms", "millis":
+		return int(t.UnixNano() / int64(time.Millisecond))
+	case "us", "micros":
+		return int(t.UnixNano() / int64(time.Microsecond))
+	case "ns", "nanos":
+		return int(t.UnixNano())
+	}
+	timeFunctionError(ctx, "epoch", params, "unknown_unit", fmt.Sprintf("unknown unit %s", unit))
+	return nil
+}
@@ -0,0 +1,340 @@
+/**
+ * Copyright 2015 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jtl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	. "github.com/Comcast/eel/util"
+)
+
+// maxDecimals is the maximum number of decimals for round() and numberformat()
+const maxDecimals = 17
+
+func init() {
+	// convert to integer, floats are truncated: toint('<value>')
+	RegisterExactFunction("toint", fnToInt, 1, 1, ResultNumber)
+	// convert to float: tofloat('<value>')
+	RegisterExactFunction("tofloat", fnToFloat, 1, 1, ResultNumber)
+	// convert true, false, yes, no, on, off, 1 and 0 to boolean: tobool('<value>')
+	RegisterExactFunction("tobool", fnToBool, 1, 1, ResultBoolean)
+	// convert to string, numbers are formatted without rounding: tostring('<value>')
+	RegisterExactFunction("tostring", fnToString, 1, 1, ResultString)
+	// encode value as json string: tojson('<value>')
+	RegisterExactFunction("tojson", fnToJson, 1, 1, ResultString)
+	// json type of value at path or of value: typeof('<path>', ['<doc>']) or typeof('<value>')
+	RegisterExactFunction("typeof", fnTypeOf, 1, 2, ResultString)
+	// round half away from zero to number of decimals: round('<number>', ['<decimals>'])
+	RegisterExactFunction("round", fnRound, 1, 2, ResultNumber)
+	// largest integer less than or equal to number: floor('<number>')
+	RegisterExactFunction("floor", fnFloor, 1, 1, ResultNumber)
+	// smallest integer greater than or equal to number: ceil('<number>')
+	RegisterExactFunction("ceil", fnCeil, 1, 1, ResultNumber)
+	// absolute value: abs('<number>')
+	RegisterExactFunction("abs", fnAbs, 1, 1, ResultNumber)
+	// locale-free number formatting: numberformat('<number>', ['<decimals>'], ['<decimal separator>'], ['<thousands separator>'])
+	RegisterExactFunction("numberformat", fnNumberFormat, 1, 4, ResultString)
+}
+
+// numberParam parses a numeric parameter, reporting an error if the parameter is not a number.
+func numberParam(ctx Context, op string, params []string, param string) (float64, bool) {
+	f, ok := toNumberOperand(extractStringParam(param))
+	if !ok || math.IsNaN(f) || math.IsInf(f, 0) {
+		stats := ctx.Value(EelTotalStats).(*ServiceStats)
+		ctx.Log().Error("error_type", "func_"+op, "op", op, "cause", "param_not_number", "params", params)
+		stats.IncErrors()
+		AddError(ctx, RuntimeError{fmt.Sprintf("non numeric parameters in call to %s function", op), op, params})
+		return 0, false
+	}
+	return f, true
+}
+
+// fnToInt converts a number or numeric string to an integer, truncating the fractional part.
+func fnToInt(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "toint", params, 1, 1) {
+		return nil
+	}
+	if i, err := strconv.Atoi(strings.TrimSpace(extractStringParam(params[0]))); err == nil {
+		return i
+	}
+	f, ok := numberParam(ctx, "toint", params, params[0])
+	if !ok {
+		return nil
+	}
+	return intResult(ctx, "toint", params, math.Trunc(f))
+}
+
+// intResult converts an integral float to an integer, reporting an error if it is out of the range of int.
+func intResult(ctx Context, op string, params []string, f float64) interface{} {
+	if f < -(1<<63) || f >= 1<<63 {
+		stats := ctx.Value(EelTotalStats).(*ServiceStats)
+		ctx.Log().Error("error_type", "func_"+op, "op", op, "cause", "int_overflow", "params", params)
+		stats.IncErrors()
+		AddError(ctx, RuntimeError{fmt.Sprintf("integer result out of range in call to %s function", op), op, params})
+		return nil
+	}
+	return int(f)
+}
+
+// decimalsParam parses the number of decimals for round() and numberformat(), reporting an error if there are more
+// than maxDecimals.
+func decimalsParam(ctx Context, op string, params []string, param string) (int, bool) {
+	decimals, ok := intParam(ctx, op, params, param)
+	if !ok {
+		return 0, false
+	}
+	if decimals > maxDecimals || decimals < -maxDecimals {
+		stats := ctx.Value(EelTotalStats).(*ServiceStats)
+		ctx.Log().Error("error_type", "func_"+op, "op", op, "cause", "too_many_decimals", "params", params)
+		stats.IncErrors()
+		AddError(ctx, SyntaxError{fmt.Sprintf("more than %d decimals in call to %s function", maxDecimals, op), op, params})
+		return 0, false
+	}
+	return decimals, true
+}
+
+// fnToFloat converts a number or numeric string to a float.
+func fnToFloat(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "tofloat", params, 1, 1) {
+		return nil
+	}
+	f, ok := numberParam(ctx, "tofloat", params, params[0])
+	if !ok {
+		return nil
+	}
+	return f
+}
+
+// fnToBool converts true, false, yes, no, on, off, 1 and 0 (case insensitive) to a boolean.
+func fnToBool(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "tobool", params, 1, 1) {
+		return nil
+	}
+	switch strings.ToLower(strings.TrimSpace(extractStringParam(params[0]))) {
+	case "true", "yes", "on", "1":
+		return true
+	case "false", "no", "off", "0":
+		return false
+	}
+	stats := ctx.Value(EelTotalStats).(*ServiceStats)
+	ctx.Log().Error("error_type", "func_tobool", "op", "tobool", "cause", "param_not_bool", "params", params)
+	stats.IncErrors()
+	AddError(ctx, RuntimeError{fmt.Sprintf("non boolean parameters in call to tobool function"), "tobool", params})
+	return nil
+}
+
+// fnToString returns the parameter as string so that numbers and booleans end up as json strings.
+func fnToString(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "tostring", params, 1, 1) {
+		return nil
+	}
+	return extractStringParam(params[0])
+}
+
+// fnToJson encodes a value as json string. Parameters which are valid json are compacted, all other parameters are
+// encoded as json strings.
+func fnToJson(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "tojson", params, 1, 1) {
+		return nil
+	}
+	s := extractStringParam(params[0])
+	var buf bytes.Buffer
+	if json.Valid([]byte(s)) {
+		json.Compact(&buf, []byte(s))
+		return buf.String()
+	}
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// jsonType returns the json type name of a value.
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case int, int64, float32, float64:
+		return "number"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	}
+	return "string"
+}
+
+// fnTypeOf returns the json type (string, number, boolean, object, array or null) of the value at a path, like eval()
+// optionally in a different document. Since values lose their type when passed as parameters, the type of parameters
+// not starting with / is guessed from their text.
+func fnTypeOf(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "typeof", params, 1, 2) {
+		return nil
+	}
+	s := extractStringParam(params[0])
+	if !strings.HasPrefix(s, "/") {
+		var v interface{}
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			return "string"
+		}
+		return jsonType(v)
+	}
+	if len(params) == 2 {
+		var err error
+		doc, err = NewJDocFromString(extractStringParam(params[1]))
+		if err != nil {
+			stats := ctx.Value(EelTotalStats).(*ServiceStats)
+			ctx.Log().Error("error_type", "func_typeof", "op", "typeof", "cause", "json_expected", "params", params, "error", err.Error())
+			stats.IncErrors()
+			AddError(ctx, SyntaxError{fmt.Sprintf("non json parameters in call to typeof function"), "typeof", params})
+			return nil
+		}
+	}
+	return jsonType(doc.EvalPath(ctx, s))
+}
+
+// fnRound rounds half away from zero to the given number of decimals (default 0). The result is an integer if no
+// decimals are requested.
+func fnRound(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "round", params, 1, 2) {
+		return nil
+	}
+	f, ok := numberParam(ctx, "round", params, params[0])
+	if !ok {
+		return nil
+	}
+	decimals := 0
+	if len(params) == 2 {
+		if decimals, ok = decimalsParam(ctx, "round", params, params[1]); !ok {
+			return nil
+		}
+	}
+	if decimals <= 0 {
+		p := math.Pow(10, float64(-decimals))
+		return intResult(ctx, "round", params, math.Round(f/p)*p)
+	}
+	r, _ := strconv.ParseFloat(formatDecimal(f, decimals), 64)
+	return r
+}
+
+// formatDecimal formats f with the given number of decimals, rounding half away from zero. Rounding is applied to the
+// shortest decimal representation of f rather than to its binary approximation, so 2.675 becomes 2.68.
+func formatDecimal(f float64, decimals int) string {
+	r, _ := new(big.Rat).SetString(strconv.FormatFloat(f, 'f', -1, 64))
+	return r.FloatString(decimals)
+}
+
+// fnFloor returns the largest integer less than or equal to a number.
+func fnFloor(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "floor", params, 1, 1) {
+		return nil
+	}
+	f, ok := numberParam(ctx, "floor", params, params[0])
+	if !ok {
+		return nil
+	}
+	return intResult(ctx, "floor", params, math.Floor(f))
+}
+
+// fnCeil returns the smallest integer greater than or equal to a number.
+func fnCeil(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "ceil", params, 1, 1) {
+		return nil
+	}
+	f, ok := numberParam(ctx, "ceil", params, params[0])
+	if !ok {
+		return nil
+	}
+	return intResult(ctx, "ceil", params, math.Ceil(f))
+}
+
+// fnAbs returns the absolute value of a number, as integer if the number is an integer.
+func fnAbs(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "abs", params, 1, 1) {
+		return nil
+	}
+	f, ok := numberParam(ctx, "abs", params, params[0])
+	if !ok {
+		return nil
+	}
+	if isInt(strings.TrimSpace(extractStringParam(params[0]))) {
+		return intResult(ctx, "abs", params, math.Abs(f))
+	}
+	return math.Abs(f)
+}
+
+// fnNumberFormat formats a number with a fixed number of decimals (default 0) and the given decimal separator
+// (default .) and thousands separator (default ,), independent of any locale.
+func fnNumberFormat(ctx Context, doc *JDoc, params []string) interface{} {
+	if !checkParamCount(ctx, "numberformat", params, 1, 4) {
+		return nil
+	}
+	f, ok := numberParam(ctx, "numberformat", params, params[0])
+	if !ok {
+		return nil
+	}
+	decimals := 0
+	if len(params) >= 2 {
+		if decimals, ok = decimalsParam(ctx, "numberformat", params, params[1]); !ok {
+			return nil
+		}
+		if decimals < 0 {
+			decimals = 0
+		}
+	}
+	decimalSep := "."
+	if len(params) >= 3 {
+		decimalSep = extractStringParam(params[2])
+	}
+	thousandsSep := ","
+	if len(params) == 4 {
+		thousandsSep = extractStringParam(params[3])
+	}
+	s := formatDecimal(f, decimals)
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign = "-"
+		s = s[1:]
+	}
+	intPart := s
+	fracPart := ""
+	if i := strings.Index(s, "."); i >= 0 {
+		intPart = s[:i]
+		fracPart = s[i+1:]
+	}
+	var b strings.Builder
+	b.WriteString(sign)
+	for i, c := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			b.WriteString(thousandsSep)
+		}
+		b.WriteRune(c)
+	}
+	if fracPart != "" {
+		b.WriteString(decimalSep)
+		b.WriteString(fracPart)
+	}
+	return b.String()
+}
@@ -0,0 +1,210 @@
+/**
+ * Copyright 2015 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package jtl
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/Comcast/eel/util"
+)
+
+// HandlerWatcher polls the handler folders and config.json for changes and hot-reloads the handler factory once
+// changes have settled for the debounce period.
+type HandlerWatcher struct {
+	interval      time.Duration
+	debounce      time.Duration
+	snapshot      map[string]string
+	lastChange    time.Time
+	pending       bool
+	configChanged bool
+	quit          chan bool
+	done          chan bool
+}
+
+const defaultHandlerWatchDebounce = 1000
+
+// NewHandlerWatcher creates a watcher polling every interval and reloading after no further changes were seen for debounce.
+func NewHandlerWatcher(ctx Context, interval time.Duration, debounce time.Duration) *HandlerWatcher {
+	hw := new(HandlerWatcher)
+	hw.interval = interval
+	hw.debounce = debounce
+	hw.snapshot = hw.takeSnapshot()
+	return hw
+}
+
+// StartHandlerWatcher starts the handler watcher configured in config.json (if any) and adds it to the context.
+func StartHandlerWatcher(ctx Context) {
+	interval := GetConfig(ctx).HandlerWatchInterval
+	if interval <= 0 {
+		return
+	}
+	debounce := GetConfig(ctx).HandlerWatchDebounce
+	if debounce <= 0 {
+		debounce = defaultHandlerWatchDebounce
+	}
+	hw := NewHandlerWatcher(ctx, time.Duration(interval)*time.Millisecond, time.Duration(debounce)*time.Millisecond)
+	ctx.AddValue(EelHandlerWatcher, hw)
+	hw.Start(ctx)
+}
+
+// GetHandlerWatcher returns the handler watcher from context or nil if handlers are not watched.
+func GetHandlerWatcher(ctx Context) *HandlerWatcher {
+	if ctx.Value(EelHandlerWatcher) != nil {
+		return ctx.Value(EelHandlerWatcher).(*HandlerWatcher)
+	}
+	return nil
+}
+
+// Start starts polling for changes.
+func (hw *HandlerWatcher) Start(ctx Context) {
+	if hw.quit != nil {
+		return
+	}
+	hw.quit = make(chan bool)
+	hw.done = make(chan bool)
+	ctx.Log().Info("action", "watching_handlers", "folders", HandlerPaths, "config", ConfigPath)
+	go func(quit chan bool, done chan bool) {
+		defer close(done)
+		defer ctx.HandlePanic()
+		ticker := time.NewTicker(hw.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				hw.poll(ctx)
+			case <-quit:
+				return
+			}
+		}
+	}(hw.quit, hw.done)
+}
+
+// Stop stops polling for changes.
+func (hw *HandlerWatcher) Stop(ctx Context) {
+	if hw.quit == nil {
+		return
+	}
+	close(hw.quit)
+	<-hw.done
+	hw.quit = nil
+}
+
+func (hw *HandlerWatcher) poll(ctx Context) {
+	snapshot := hw.takeSnapshot()
+	if !hw.sameSnapshot(snapshot) {
+		if snapshot[ConfigPath] != hw.snapshot[ConfigPath] {
+			hw.configChanged = true
+		}
+		hw.snapshot = snapshot
+		hw.pending = true
+		hw.lastChange = time.Now()
+		return
+	}
+	if hw.pending && time.Since(hw.lastChange) >= hw.debounce {
+		hw.pending = false
+		HotReload(ctx, hw.configChanged)
+		hw.configChanged = false
+	}
+}
+
+func (hw *HandlerWatcher) sameSnapshot(snapshot map[string]string) bool {
+	if len(snapshot) != len(hw.snapshot) {
+		return false
+	}
+	for k, v := range snapshot {
+		if hw.snapshot[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// takeSnapshot returns modification time and size of config.json and all handler files.
+func (hw *HandlerWatcher) takeSnapshot() map[string]string {
+	snapshot := make(map[string]string, 0)
+	sig := func(f os.FileInfo) string {
+		return strconv.FormatInt(f.ModTime().UnixNano(), 10) + "/" + strconv.FormatInt(f.Size(), 10)
+	}
+	if f, err := os.Stat(ConfigPath); err == nil {
+		snapshot[ConfigPath] = sig(f)
+	}
+	for _, folder := range HandlerPaths {
+		filepath.Walk(folder, func(path string, f os.FileInfo, err error) error {
+			if err == nil && !f.IsDir() && strings.HasSuffix(path, ".json") {
+				snapshot[path] = sig(f)
+			}
+			return nil
+		})
+	}
+	return snapshot
+}
+
+// HotReload vets all handlers (and config.json if reloadConfig is true) with the same checks as /vet, including the
+// lint checks, and only swaps in the new handler factory if there are no warnings. Otherwise the current handlers are
+// kept and the warnings are logged per file and returned.
+func HotReload(ctx Context, reloadConfig bool) []error {
+	handlerMutex.Lock()
+	defer handlerMutex.Unlock()
+	vctx := ctx.SubContext()
+	var config *EelSettings
+	if reloadConfig {
+		var err error
+		config, err = ReadConfigFromFile(ctx)
+		if err != nil {
+			ctx.Log().Error("error_type", "hot_reload", "cause", "invalid_config", "file", ConfigPath, "error", err.Error(), "action", "keeping_current_handlers")
+			return []error{err}
+		}
+		config.Version = GetConfig(ctx).Version
+		vctx.AddConfigValue(EelConfig, config)
+	}
+	hf, warnings := NewHandlerFactory(vctx, HandlerPaths)
+	if len(warnings) == 0 {
+		// the handlers load, also apply the lint checks of /vet
+		for _, h := range hf.GetAllHandlers(vctx) {
+			for _, d := range h.Lint(vctx) {
+				warnings = append(warnings, d)
+			}
+		}
+	}
+	if len(warnings) > 0 {
+		for _, folder := range HandlerPaths {
+			for _, file := range hf.getAllConfigurationFiles(vctx, folder) {
+				handler, fw := GetHandlerConfigurationFromFile(vctx, file)
+				if handler != nil {
+					for _, d := range handler.Lint(vctx) {
+						fw = append(fw, d)
+					}
+				}
+				for _, w := range fw {
+					ctx.Log().Error("error_type", "hot_reload", "cause", "vet_failed", "file", file, "warning", w.Error())
+				}
+			}
+		}
+		ctx.Log().Error("error_type", "hot_reload", "cause", "vet_failed", "warnings", len(warnings), "action", "keeping_current_handlers")
+		return warnings
+	}
+	if config != nil {
+		ctx.AddConfigValue(EelConfig, config)
+	}
+	ctx.AddConfigValue(EelHandlerFactory, hf)
+	ctx.Log().Info("action", "hot_reloaded_handlers", "config", reloadConfig)
+	return nil
+}
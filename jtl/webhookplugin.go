@@ -16,9 +16,11 @@
 package jtl
 
 import (
+	"context"
 	"net/http"
 	"os"
 	"strconv"
+	"sync"
 
 	. "github.com/Comcast/eel/util"
 )
@@ -26,6 +28,8 @@ import (
 type WebhookPlugin struct {
 	Settings     *PluginSettings
 	ShuttingDown bool
+	server       *http.Server
+	sync.Mutex
 }
 
 var apiBasePath = ""
@@ -72,19 +76,37 @@ func (p *WebhookPlugin) startWebhookServices(ctx Context) {
 	http.HandleFunc(apiBasePath+"/elementsevent", EventHandler) // hard coded during transition period
 	http.HandleFunc(apiBasePath+"/notify", EventHandler)        // hard coded during transition period
 	ctx.Log().Info("action", "listening_for_events", "port", eventProxyPort, "proxy_path", eventProxyPath, "proc_path", eventProcPath, "op", "webhook")
-	err := http.ListenAndServe(":"+strconv.Itoa(eventProxyPort), nil)
-	if err != nil {
+	p.Lock()
+	p.server = &http.Server{Addr: ":" + strconv.Itoa(eventProxyPort)}
+	server := p.server
+	p.Unlock()
+	err := server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
 		ctx.Log().Error("error_type", "eel_service", "error", err.Error())
 	}
 	p.Settings.Active = false
 	ctx.Log().Info("action", "stopping_plugin", "op", "webhook")
-	if p.Settings.ExitOnErr {
+	if p.Settings.ExitOnErr && err != http.ErrServerClosed {
 		os.Exit(1)
 	}
 }
 
+// StopPlugin stops accepting new connections and waits up to ShutdownTimeout for requests in progress to complete.
 func (p *WebhookPlugin) StopPlugin(ctx Context) {
-	ctx.Log().Info("action", "shutdown_plugin", "op", "stdin", "details", "cannot_shutdonw")
+	p.Lock()
+	defer p.Unlock()
+	ctx.Log().Info("action", "shutdown_plugin", "op", "webhook")
+	if p.server == nil {
+		return
+	}
+	p.ShuttingDown = true
+	sctx, cancel := context.WithTimeout(context.Background(), GetShutdownTimeout(ctx))
+	defer cancel()
+	err := p.server.Shutdown(sctx)
+	if err != nil {
+		ctx.Log().Error("error_type", "eel_service", "cause", "shutdown", "op", "webhook", "error", err.Error())
+	}
+	p.server = nil
 }
 
 func (p *WebhookPlugin) IsActive() bool {
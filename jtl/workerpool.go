@@ -18,6 +18,9 @@ package jtl
 
 import (
 	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	. "github.com/Comcast/eel/util"
 )
@@ -28,6 +31,9 @@ type Worker struct {
 	work        chan *WorkRequest
 	WorkerQueue chan chan *WorkRequest
 	quitChan    chan bool
+	dispatcher  *WorkDispatcher
+	current     *WorkRequest
+	sync.Mutex
 }
 
 // WorkRequest is a work request
@@ -35,6 +41,7 @@ type WorkRequest struct {
 	Raw   string
 	Event *JDoc
 	Ctx   Context
+	Done  func() // optional - called after the event has been handled, for example to acknowledge the event at its source
 }
 
 // WorkDispatcher dispatches work requests to workers in the pool using channels
@@ -43,6 +50,10 @@ type WorkDispatcher struct {
 	WorkerQueue chan chan *WorkRequest
 	workers     []*Worker
 	quitChan    chan bool
+	stopped     chan bool
+	pending     []*WorkRequest // work requests taken from the work queue but not handed to a worker when stopped
+	inflight    int64          // work requests taken from the work queue that have not been handled yet
+	started     bool
 	tenant      string
 }
 
@@ -81,9 +92,8 @@ func (w *Worker) Start() {
 			w.WorkerQueue <- w.work
 			select {
 			case work := <-w.work:
-				stats := work.Ctx.Value(EelTotalStats).(*ServiceStats)
 				//w.ctx.Log.Info("action", "received_work", "id", strconv.Itoa(w.id))
-				handleEvent(work.Ctx, stats, work.Event, work.Raw, false, false)
+				w.handle(work)
 				//w.ctx.Log.Info("action", "handled_work", "id", strconv.Itoa(w.id))
 			case <-w.quitChan:
 				Gctx.Log().Info("action", "stopping_worker", "id", strconv.Itoa(w.id))
@@ -93,6 +103,36 @@ func (w *Worker) Start() {
 	}()
 }
 
+// handle handles a single work request. Done is also called if handling the event panics, otherwise a kafka partition
+// would never be committed again.
+func (w *Worker) handle(work *WorkRequest) {
+	w.setCurrent(work)
+	defer func() {
+		if work.Done != nil {
+			work.Done()
+		}
+		w.setCurrent(nil)
+		if w.dispatcher != nil {
+			atomic.AddInt64(&w.dispatcher.inflight, -1)
+		}
+	}()
+	stats := work.Ctx.Value(EelTotalStats).(*ServiceStats)
+	handleEvent(work.Ctx, stats, work.Event, work.Raw, false, false)
+}
+
+func (w *Worker) setCurrent(work *WorkRequest) {
+	w.Lock()
+	defer w.Unlock()
+	w.current = work
+}
+
+// Current returns the work request the worker is currently handling or nil if the worker is idle
+func (w *Worker) Current() *WorkRequest {
+	w.Lock()
+	defer w.Unlock()
+	return w.current
+}
+
 // Stop stops a worker via quit channel
 func (w *Worker) Stop() {
 	go func() {
@@ -108,6 +148,7 @@ func NewWorkDispatcher(nworkers int, queueDepth int, tenant string) *WorkDispatc
 	disp.WorkerQueue = make(chan chan *WorkRequest, nworkers)
 	disp.workers = make([]*Worker, nworkers)
 	disp.quitChan = make(chan bool)
+	disp.stopped = make(chan bool)
 	disp.tenant = tenant
 	return disp
 }
@@ -117,18 +158,28 @@ func (disp *WorkDispatcher) Start(ctx Context) {
 	ctx.Log().Info("action", "starting_workers", "count", len(disp.workers), "tenant", disp.tenant)
 	for i := 0; i < len(disp.workers); i++ {
 		disp.workers[i] = NewWorker(i, disp.WorkerQueue)
+		disp.workers[i].dispatcher = disp
 		disp.workers[i].Start()
 	}
+	disp.started = true
 	go func() {
 		defer ctx.HandlePanic()
+		defer close(disp.stopped)
 		for {
 			select {
 			case work := <-disp.WorkQueue:
+				atomic.AddInt64(&disp.inflight, 1)
 				//ctx.Log().Info("action", "received_work_request", "tenant", disp.tenant)
 				//go func() {
-				worker := <-disp.WorkerQueue
-				//ctx.Log.Info("action", "dispatched_work_request")
-				worker <- work
+				select {
+				case worker := <-disp.WorkerQueue:
+					//ctx.Log.Info("action", "dispatched_work_request")
+					worker <- work
+				case <-disp.quitChan:
+					atomic.AddInt64(&disp.inflight, -1)
+					disp.pending = append(disp.pending, work)
+					return
+				}
 				//}()
 			case <-disp.quitChan:
 				return
@@ -137,6 +188,40 @@ func (disp *WorkDispatcher) Start(ctx Context) {
 	}()
 }
 
+// IsIdle returns true if the work queue is empty and no worker is busy
+func (disp *WorkDispatcher) IsIdle() bool {
+	return len(disp.WorkQueue) == 0 && atomic.LoadInt64(&disp.inflight) == 0
+}
+
+// Drain waits until all queued work requests have been handled by the workers (including their in flight publishes) or
+// until the deadline has passed, and then stops the worker pool. Work requests that have not been picked up by a worker
+// are returned, work requests that are still being handled at the deadline are logged.
+func (disp *WorkDispatcher) Drain(ctx Context, deadline time.Time) []*WorkRequest {
+	ctx.Log().Info("action", "draining_workers", "queued", len(disp.WorkQueue), "tenant", disp.tenant)
+	for !disp.IsIdle() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	for _, w := range disp.workers {
+		if work := w.Current(); work != nil {
+			work.Ctx.Log().Error("error_type", "shutdown", "cause", "drain_deadline_exceeded", "action", "abandoned_in_flight", "tenant", disp.tenant, "trace.in.data", work.Raw)
+		}
+	}
+	if disp.started {
+		disp.Stop(ctx)
+		<-disp.stopped
+	}
+	leftovers := disp.pending
+	disp.pending = nil
+	for {
+		select {
+		case work := <-disp.WorkQueue:
+			leftovers = append(leftovers, work)
+		default:
+			return leftovers
+		}
+	}
+}
+
 // Stop stops the worker pool
 func (disp *WorkDispatcher) Stop(ctx Context) {
 	if disp.workers != nil && disp.quitChan != nil {
@@ -21,9 +21,11 @@ import (
 	"flag"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
 	"strconv"
+	"syscall"
 	"time"
 
 	. "github.com/Comcast/eel/jtl"
@@ -49,6 +51,8 @@ var (
 	in    = flag.String("in", "", "incoming event string or @file")
 	tf    = flag.String("tf", "", "transformation string or @file")
 	istbe = flag.Bool("istbe", true, "is template by example flag")
+	vet   = flag.Bool("vet", false, "vet handlers and print diagnostics")
+	jsn   = flag.Bool("json", false, "print vet diagnostics as json")
 )
 
 // useCores if GOMAXPROCS not set use all cores you got.
@@ -147,6 +151,11 @@ func registerAdminServices() {
 	http.HandleFunc("/reload", c.WrapPanicHttpHandler(ReloadConfigHandler))
 	http.HandleFunc("/toggletracelogger", c.WrapPanicHttpHandler(TraceLogConfigHandler))
 	http.HandleFunc("/vet", c.WrapPanicHttpHandler(VetHandler))
+	http.HandleFunc("/functions", c.WrapPanicHttpHandler(FunctionsHandler))
+	http.HandleFunc("/metrics", c.WrapPanicHttpHandler(MetricsHandler))
+	http.HandleFunc("/deadletters", c.WrapPanicHttpHandler(DeadLetterHandler))
+	http.HandleFunc("/deadletters/", c.WrapPanicHttpHandler(DeadLetterHandler))
+	http.HandleFunc("/handlers/", c.WrapPanicHttpHandler(HandlerApiHandler))
 	http.HandleFunc("/version", c.WrapPanicHttpHandler(VersionHandler))
 	http.HandleFunc("/test", c.WrapPanicHttpHandler(TopicTestHandler))
 	http.HandleFunc("/test/handlers", c.WrapPanicHttpHandler(HandlersTestHandler))
@@ -166,6 +175,11 @@ func registerAdminServices() {
 	http.HandleFunc("/v1/reload", c.WrapPanicHttpHandler(ReloadConfigHandler))
 	http.HandleFunc("/v1/toggletracelogger", c.WrapPanicHttpHandler(TraceLogConfigHandler))
 	http.HandleFunc("/v1/vet", c.WrapPanicHttpHandler(VetHandler))
+	http.HandleFunc("/v1/functions", c.WrapPanicHttpHandler(FunctionsHandler))
+	http.HandleFunc("/v1/metrics", c.WrapPanicHttpHandler(MetricsHandler))
+	http.HandleFunc("/v1/deadletters", c.WrapPanicHttpHandler(DeadLetterHandler))
+	http.HandleFunc("/v1/deadletters/", c.WrapPanicHttpHandler(DeadLetterHandler))
+	http.HandleFunc("/v1/handlers/", c.WrapPanicHttpHandler(HandlerApiHandler))
 	http.HandleFunc("/v1/version", c.WrapPanicHttpHandler(VersionHandler))
 	http.HandleFunc("/v1/test", c.WrapPanicHttpHandler(TopicTestHandler))
 	http.HandleFunc("/v1/test/handlers", c.WrapPanicHttpHandler(HandlersTestHandler))
@@ -181,7 +195,9 @@ func registerAdminServices() {
 
 func main() {
 	flag.Parse()
-	if *tf != "" {
+	if *vet {
+		vetCmd(*jsn)
+	} else if *tf != "" {
 		eelCmd(*in, *tf, *istbe)
 	} else {
 		initLogging()
@@ -193,6 +209,8 @@ func main() {
 		useCores(ctx)
 		dc := NewLocalInMemoryDupChecker(GetConfig(ctx).DuplicateTimeout, 10000)
 		Gctx.AddValue(EelDuplicateChecker, dc)
+		InitDeadLetterStore(Gctx)
+		InitObservers(Gctx)
 
 		tenantIds := Gctx.Value(EelTenantIds).([]string)
 		for _, tenantId := range tenantIds {
@@ -201,13 +219,20 @@ func main() {
 			Gctx.AddValue(EelDispatcher+"_"+tenantId, dp)
 			ctx.Log().Info("action", "start_worker_pool", "size", GetConfig(ctx).WorkerPoolSize[tenantId], "queue_depth", GetConfig(ctx).MessageQueueDepth, "tenant_id", tenantId)
 		}
+		InitSpillOverQueue(Gctx)
 		registerAdminServices()
 		// register inbound plugins
 		RegisterInboundPluginType(NewStdinPlugin, "STDIN")
 		RegisterInboundPluginType(NewWebhookPlugin, "WEBHOOK")
+		RegisterInboundPluginType(NewKafkaPlugin, "KAFKA")
 		LoadInboundPlugins(Gctx, true)
-		// hang on channel forever
-		<-make(chan int)
+		StartHandlerWatcher(Gctx)
+		// hang on channel until terminated, then drain work queues before exiting
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+		sig := <-sigs
+		ctx.Log().Info("action", "received_signal", "signal", sig.String())
+		Shutdown(Gctx)
 	}
 }
 
@@ -106,6 +106,22 @@ func benchmarkSingleEvent(b *testing.B, folder string, headers map[string]string
 }
 
 func benchmarkRawTransformation(b *testing.B, folder string, isTransformationByExample bool) {
+	benchmarkTransformation(b, Gctx, folder, isTransformationByExample)
+}
+
+// benchmarkCompiledTransformation applies the transformation in the context of the loaded handler so that the
+// expressions pre-compiled at handler load are used instead of parsing every expression for every event.
+func benchmarkCompiledTransformation(b *testing.B, folder string, isTransformationByExample bool) {
+	h, warnings := GetHandlerConfigurationFromFile(Gctx, filepath.Join(folder, "handlers/tenant1/handler.json"))
+	if h == nil || len(warnings) > 0 {
+		b.Fatalf("could not load handler: %v\n", warnings)
+	}
+	ctx := Gctx.SubContext()
+	ctx.AddValue(EelHandlerConfig, h)
+	benchmarkTransformation(b, ctx, folder, isTransformationByExample)
+}
+
+func benchmarkTransformation(b *testing.B, ctx Context, folder string, isTransformationByExample bool) {
 	in, err := NewJDocFromFile(filepath.Join(folder, "in.json"))
 	if err != nil {
 		b.Fatalf("could not read in event: %s\n", err.Error())
@@ -118,7 +134,7 @@ func benchmarkRawTransformation(b *testing.B, folder string, isTransformationByE
 	if err != nil {
 		b.Fatalf("could not read handler: %s\n", err.Error())
 	}
-	t, err := NewJDocFromMap(h.EvalPath(Gctx, "/Transformation").(map[string]interface{}))
+	t, err := NewJDocFromMap(h.EvalPath(ctx, "/Transformation").(map[string]interface{}))
 	if err != nil {
 		b.Fatalf("could not parse transformation: %s\n", err.Error())
 	}
@@ -128,7 +144,7 @@ func benchmarkRawTransformation(b *testing.B, folder string, isTransformationByE
 			//b.Logf("n: %d\n", b.N)
 		}
 		if isTransformationByExample {
-			transformed := in.ApplyTransformationByExample(Gctx, t)
+			transformed := in.ApplyTransformationByExample(ctx, t)
 			if transformed == nil {
 				b.Fatalf("transformationfailed")
 			}
@@ -136,7 +152,7 @@ func benchmarkRawTransformation(b *testing.B, folder string, isTransformationByE
 				b.Fatalf("actual and expected event differ (transf by example):\nactual:\n%s\nexpected:\n%s\nin:\n%s\nt:\n%s\n", transformed.StringPretty(), out.StringPretty(), in.StringPretty(), t.StringPretty())
 			}
 		} else {
-			transformed := in.ApplyTransformation(Gctx, t)
+			transformed := in.ApplyTransformation(ctx, t)
 			if transformed == nil {
 				b.Fatalf("transformationfailed")
 			}
@@ -197,6 +213,36 @@ func BenchmarkRawTransformationRegex(b *testing.B) {
 	benchmarkRawTransformation(b, "data/test20/", false)
 }
 
+func BenchmarkCompiledTransformationCanonicalizeEvent(b *testing.B) {
+	initTests("data/test01/handlers")
+	benchmarkCompiledTransformation(b, "data/test01/", false)
+}
+
+func BenchmarkCompiledTransformationByExample(b *testing.B) {
+	initTests("data/test03/handlers")
+	benchmarkCompiledTransformation(b, "data/test03/", true)
+}
+
+func BenchmarkCompiledTransformationMessageGeneration(b *testing.B) {
+	initTests("data/test05/handlers")
+	benchmarkCompiledTransformation(b, "data/test05/", false)
+}
+
+func BenchmarkCompiledTransformationStringOps(b *testing.B) {
+	initTests("data/test15/handlers")
+	benchmarkCompiledTransformation(b, "data/test15/", false)
+}
+
+func BenchmarkCompiledTransformationCase(b *testing.B) {
+	initTests("data/test19/handlers")
+	benchmarkCompiledTransformation(b, "data/test19/", false)
+}
+
+func BenchmarkCompiledTransformationRegex(b *testing.B) {
+	initTests("data/test20/handlers")
+	benchmarkCompiledTransformation(b, "data/test20/", false)
+}
+
 func BenchmarkCanonicalizeEvent(b *testing.B) {
 	initTests("data/test01/handlers")
 	headers := make(map[string]string, 0)
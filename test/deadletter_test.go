@@ -0,0 +1,165 @@
+/**
+ * Copyright 2015 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/Comcast/eel/jtl"
+	. "github.com/Comcast/eel/util"
+)
+
+func TestDeadLetters(t *testing.T) {
+	var healthy int32
+	received := make(chan string, 10)
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if user, password, _ := r.BasicAuth(); user != "eel" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- string(body)
+	}))
+	defer endpoint.Close()
+	dir := writeTestHandler(t, "tenant1", "failing.json", `{
+		"Version": "1.0",
+		"Name": "Failing",
+		"Active": true,
+		"Match": null,
+		"TerminateOnMatch": true,
+		"Transformation": {
+			"{{/}}": "{{/}}"
+		},
+		"Endpoint": "`+endpoint.URL+`",
+		"AuthInfo": { "type": "basic", "username": "eel", "password": "secret" }
+	}`)
+	defer os.RemoveAll(dir)
+	initTests(dir)
+	fileName := filepath.Join(dir, "deadletters.log")
+	dls, err := NewLocalFileDeadLetterStore(Gctx, fileName)
+	if err != nil {
+		t.Fatalf("could not open dead letter store: %s\n", err.Error())
+	}
+	Gctx.AddValue(EelDeadLetterStore, dls)
+	defer Gctx.AddValue(EelDeadLetterStore, nil)
+	ts := httptest.NewServer(http.HandlerFunc(EventHandler))
+	defer ts.Close()
+	r, _ := http.NewRequest("POST", ts.URL, strings.NewReader(`{"message":"dead"}`))
+	r.Header.Set("Xrs-Tenant-Id", "tenant1")
+	r.Header.Set("X-B3-TraceId", "trace-dead")
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("error posting event: %s\n", err.Error())
+	}
+	resp.Body.Close()
+	for i := 0; i < 1000 && len(dls.List("tenant1", "Failing")) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	list := dls.List("tenant1", "Failing")
+	if len(list) != 1 {
+		t.Fatalf("unexpected number of dead letters: %d\n", len(list))
+	}
+	if list[0].TraceId != "trace-dead" || list[0].Url != endpoint.URL || !strings.Contains(list[0].Payload, "dead") || list[0].Error == "" {
+		t.Errorf("incomplete dead letter: %v\n", list[0])
+	}
+	if list[0].AuthInfo["password"] != "secret" {
+		t.Errorf("auth info not kept in dead letter: %v\n", list[0].AuthInfo)
+	}
+	if len(dls.List("tenant2", "")) != 0 {
+		t.Error("dead letter listed for wrong tenant")
+	}
+	// dead letters contain credentials and must only be readable by the owner
+	if fi, err := os.Stat(fileName); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("dead letter file is not private: %v %v\n", fi, err)
+	}
+	// dead letters survive a restart
+	dls, err = NewLocalFileDeadLetterStore(Gctx, fileName)
+	if err != nil {
+		t.Fatalf("could not reopen dead letter store: %s\n", err.Error())
+	}
+	Gctx.AddValue(EelDeadLetterStore, dls)
+	if dls.Get(list[0].Id) == nil {
+		t.Fatal("dead letter lost after reopening store")
+	}
+	admin := httptest.NewServer(http.HandlerFunc(DeadLetterHandler))
+	defer admin.Close()
+	call := func(method string, path string) map[string]int {
+		r, _ := http.NewRequest(method, admin.URL+path, nil)
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("error calling dead letter api: %s\n", err.Error())
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("dead letter api returned unhappy status: %s\n", resp.Status)
+		}
+		result := make(map[string]int)
+		body, _ := ioutil.ReadAll(resp.Body)
+		json.Unmarshal(body, &result)
+		return result
+	}
+	// credentials are masked in api responses
+	resp, err = http.Get(admin.URL + "/v1/deadletters/" + list[0].Id)
+	if err != nil {
+		t.Fatalf("error calling dead letter api: %s\n", err.Error())
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if strings.Contains(string(body), "secret") || !strings.Contains(string(body), "basic") {
+		t.Errorf("auth info not masked: %s\n", body)
+	}
+	// replay
+	atomic.StoreInt32(&healthy, 1)
+	result := call("POST", "/v1/deadletters/replay?tenant=tenant1&handler=Failing")
+	if result["replayed"] != 1 || result["failed"] != 0 {
+		t.Fatalf("unexpected replay result: %v\n", result)
+	}
+	select {
+	case body := <-received:
+		if !strings.Contains(body, "dead") {
+			t.Errorf("unexpected replayed payload: %s\n", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("dead letter not replayed")
+	}
+	if len(dls.List("", "")) != 0 {
+		t.Fatal("replayed dead letter not removed")
+	}
+	// purge
+	dls.Add(Gctx, &DeadLetter{Id: "1", TenantId: "tenant1", Handler: "Failing"})
+	dls.Add(Gctx, &DeadLetter{Id: "2", TenantId: "tenant2", Handler: "Failing"})
+	result = call("DELETE", "/v1/deadletters?tenant=tenant1")
+	if result["purged"] != 1 {
+		t.Fatalf("unexpected purge result: %v\n", result)
+	}
+	if dls.Get("2") == nil || dls.Get("1") != nil {
+		t.Fatal("purged wrong dead letters")
+	}
+}
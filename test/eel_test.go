@@ -540,6 +540,16 @@ func TestPTransformFunction(t *testing.T) {
 	transformEvent(t, "data/test59/", nil)
 }
 
+func TestWildcardPathTransformation(t *testing.T) {
+	initTests("data/test61/handlers")
+	transformEvent(t, "data/test61/", nil)
+}
+
+func TestWildcardExampleTransformation(t *testing.T) {
+	initTests("data/test62/handlers")
+	transformEvent(t, "data/test62/", nil)
+}
+
 func TestTopicHandlerParent(t *testing.T) {
 	initTests("data/test97/handlers")
 	fanoutEvent(t, "data/test97/", 1, false, nil)
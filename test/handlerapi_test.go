@@ -0,0 +1,145 @@
+/**
+ * Copyright 2015 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/Comcast/eel/jtl"
+	. "github.com/Comcast/eel/util"
+)
+
+const apiHandler = `{
+	"Version": "%s",
+	"Name": "Api",
+	"Active": true,
+	"Match": {
+		"{{/kind}}": "api"
+	},
+	"TerminateOnMatch": true,
+	"Transformation": {
+		"{{/%s}}": "{{/message}}"
+	}
+}`
+
+func TestHandlerApi(t *testing.T) {
+	dir := writeTestHandler(t, "_default", "default.json", `{
+		"Version": "1.0",
+		"Name": "Default",
+		"Active": true,
+		"Match": null,
+		"TerminateOnMatch": true,
+		"Transformation": {
+			"{{/}}": "{{/}}"
+		}
+	}`)
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "tenant1"), 0755)
+	initTests(dir)
+	ts := httptest.NewServer(http.HandlerFunc(HandlerApiHandler))
+	defer ts.Close()
+	call := func(method string, body string, header string, val string) (int, string, string) {
+		r, _ := http.NewRequest(method, ts.URL+"/v1/handlers/tenant1/Api", strings.NewReader(body))
+		if header != "" {
+			r.Header.Set(header, val)
+		}
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("error calling handler api: %s\n", err.Error())
+		}
+		defer resp.Body.Close()
+		buf, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, resp.Header.Get("ETag"), string(buf)
+	}
+	handler := func() *HandlerConfiguration {
+		return GetHandlerFactory(Gctx).CustomHandlerMap["tenant1"]["Api"]
+	}
+	if status, _, _ := call("GET", "", "", ""); status != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown handler but got %d\n", status)
+	}
+	// invalid handler is rejected
+	status, _, body := call("PUT", fmt.Sprintf(apiHandler, "", "v1"), "", "")
+	if status != http.StatusBadRequest || !strings.Contains(body, "missing version") {
+		t.Fatalf("expected 400 with warnings but got %d %s\n", status, body)
+	}
+	// create
+	status, etag, body := call("PUT", fmt.Sprintf(apiHandler, "1", "v1"), "If-None-Match", "*")
+	if status != http.StatusCreated || etag != `"1"` {
+		t.Fatalf("expected 201 with etag 1 but got %d %s %s\n", status, etag, body)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "tenant1", "Api.json")); err != nil {
+		t.Fatalf("handler file not written: %s\n", err.Error())
+	}
+	if handler() == nil || handler().Version != "1" {
+		t.Fatal("handler not added to live handler factory")
+	}
+	if GetHandlerFactory(Gctx).CustomHandlerMap["tenant1"]["Default"] == nil {
+		t.Fatal("default handler not populated into tenant")
+	}
+	event, _ := NewJDocFromString(`{"kind":"api","message":"hello"}`)
+	handlers := GetHandlerFactory(Gctx).GetHandlersForEvent(Gctx, event)
+	if len(handlers) != 1 || handlers[0].Name != "Api" {
+		t.Fatalf("expected new handler to match event but got %v\n", handlers)
+	}
+	if status, _, _ := call("PUT", fmt.Sprintf(apiHandler, "1", "v1"), "If-None-Match", "*"); status != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 on create of existing handler but got %d\n", status)
+	}
+	// update requires current version
+	if status, _, _ := call("PUT", fmt.Sprintf(apiHandler, "2", "v2"), "", ""); status != http.StatusPreconditionRequired {
+		t.Fatalf("expected 428 without If-Match but got %d\n", status)
+	}
+	if status, _, _ := call("PUT", fmt.Sprintf(apiHandler, "2", "v2"), "If-Match", `"0"`); status != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for stale version but got %d\n", status)
+	}
+	if status, _, _ := call("PUT", fmt.Sprintf(apiHandler, "1", "v2"), "If-Match", `"1"`); status != http.StatusConflict {
+		t.Fatalf("expected 409 for unchanged version but got %d\n", status)
+	}
+	status, etag, _ = call("PUT", fmt.Sprintf(apiHandler, "2", "v2"), "If-Match", `"1"`)
+	if status != http.StatusOK || etag != `"2"` {
+		t.Fatalf("expected 200 with etag 2 but got %d %s\n", status, etag)
+	}
+	if handler() == nil || handler().Version != "2" {
+		t.Fatal("handler not updated in live handler factory")
+	}
+	status, etag, body = call("GET", "", "", "")
+	if status != http.StatusOK || etag != `"2"` || !strings.Contains(body, "{{/v2}}") {
+		t.Fatalf("unexpected handler %d %s %s\n", status, etag, body)
+	}
+	// delete
+	if status, _, _ := call("DELETE", "", "If-Match", `"1"`); status != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 for stale version but got %d\n", status)
+	}
+	if status, _, _ := call("DELETE", "", "If-Match", `"2"`); status != http.StatusOK {
+		t.Fatalf("expected 200 on delete but got %d\n", status)
+	}
+	if handler() != nil {
+		t.Fatal("handler not removed from live handler factory")
+	}
+	if GetHandlerFactory(Gctx).CustomHandlerMap["_default"]["Default"] == nil {
+		t.Fatal("default handler removed")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "tenant1", "Api.json")); !os.IsNotExist(err) {
+		t.Fatal("handler file not deleted")
+	}
+}
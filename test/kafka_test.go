@@ -0,0 +1,312 @@
+/**
+ * Copyright 2015 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/Comcast/eel/jtl"
+	. "github.com/Comcast/eel/util"
+)
+
+type kafkaTestRequest struct {
+	body    string
+	traceId string
+	tenant  string
+}
+
+// writeTestHandler writes a single handler config into a new tenant folder and returns the handler base folder.
+func writeTestHandler(t *testing.T, tenant string, name string, handler string) string {
+	dir, err := ioutil.TempDir("", "eel")
+	if err != nil {
+		t.Fatalf("could not create handler folder: %s\n", err.Error())
+	}
+	err = os.MkdirAll(filepath.Join(dir, tenant), 0755)
+	if err != nil {
+		t.Fatalf("could not create tenant folder: %s\n", err.Error())
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, tenant, name), []byte(handler), 0644)
+	if err != nil {
+		t.Fatalf("could not write handler: %s\n", err.Error())
+	}
+	return dir
+}
+
+func TestKafkaPlugin(t *testing.T) {
+	received := make(chan *kafkaTestRequest, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- &kafkaTestRequest{string(body), r.Header.Get("X-B3-TraceId"), r.Header.Get("X-Tenant-Id")}
+	}))
+	defer ts.Close()
+	dir := writeTestHandler(t, "tenant1", "kafka.json", `{
+		"Version": "1.0",
+		"Name": "Kafka",
+		"Active": true,
+		"Match": null,
+		"TerminateOnMatch": true,
+		"Transformation": {
+			"{{/}}": "{{/}}"
+		},
+		"Endpoint": "`+ts.URL+`",
+		"HttpHeaders": {
+			"X-B3-TraceId": "{{traceid()}}",
+			"X-Tenant-Id": "{{tenant()}}"
+		}
+	}`)
+	defer os.RemoveAll(dir)
+	initTests(dir)
+	broker := NewInMemoryKafkaBroker()
+	defer SetKafkaDialer(GetKafkaDialer())
+	SetKafkaDialer(broker)
+	settings := &PluginSettings{
+		Type:       "KAFKA",
+		Name:       "KAFKA",
+		Parameters: map[string]interface{}{"Brokers": "localhost:9092", "Topics": []interface{}{"events"}, "GroupId": "eel"},
+	}
+	p := NewKafkaPlugin(settings)
+	p.StartPlugin(Gctx)
+	broker.Produce(&KafkaMessage{Topic: "events", Value: []byte(`{"message":"hello"}`), Headers: map[string]string{"X-B3-TraceId": "trace-1", "Xrs-Tenant-Id": "tenant1"}})
+	select {
+	case r := <-received:
+		if !strings.Contains(r.body, "hello") {
+			t.Errorf("unexpected payload: %s\n", r.body)
+		}
+		if r.traceId != "trace-1" {
+			t.Errorf("trace id not adopted from message header: %s\n", r.traceId)
+		}
+		if r.tenant != "tenant1" {
+			t.Errorf("tenant not adopted from message header: %s\n", r.tenant)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not published")
+	}
+	for i := 0; i < 100 && broker.CommittedOffset("eel", "events") != 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if broker.CommittedOffset("eel", "events") != 1 {
+		t.Fatalf("offset not committed after handling event: %d\n", broker.CommittedOffset("eel", "events"))
+	}
+	// restarted consumer must resume after the committed offset
+	p.StopPlugin(Gctx)
+	if p.IsActive() {
+		t.Error("plugin still active after stop")
+	}
+	broker.Produce(&KafkaMessage{Topic: "events", Value: []byte(`{"message":"world"}`), Headers: map[string]string{"Xrs-Tenant-Id": "tenant1"}})
+	p.StartPlugin(Gctx)
+	defer p.StopPlugin(Gctx)
+	select {
+	case r := <-received:
+		if !strings.Contains(r.body, "world") {
+			t.Errorf("unexpected payload after restart: %s\n", r.body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not published after restart")
+	}
+}
+
+func TestKafkaPluginNoWorkDispatcher(t *testing.T) {
+	received := make(chan *kafkaTestRequest, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- &kafkaTestRequest{string(body), r.Header.Get("X-B3-TraceId"), r.Header.Get("X-Tenant-Id")}
+	}))
+	defer ts.Close()
+	dir := writeTestHandler(t, "tenant1", "kafka.json", `{
+		"Version": "1.0",
+		"Name": "Kafka",
+		"Active": true,
+		"Match": null,
+		"TerminateOnMatch": true,
+		"Transformation": {
+			"{{/}}": "{{/}}"
+		},
+		"Endpoint": "`+ts.URL+`"
+	}`)
+	defer os.RemoveAll(dir)
+	initTests(dir)
+	// without default worker pool events of unknown tenants have no work dispatcher
+	ctx := Gctx.SubContext()
+	ctx.AddValue(EelDispatcher+"_", nil)
+	broker := NewInMemoryKafkaBroker()
+	defer SetKafkaDialer(GetKafkaDialer())
+	SetKafkaDialer(broker)
+	settings := &PluginSettings{
+		Type:       "KAFKA",
+		Name:       "KAFKA",
+		Parameters: map[string]interface{}{"Brokers": "localhost:9092", "Topics": []interface{}{"events"}, "GroupId": "eel"},
+	}
+	p := NewKafkaPlugin(settings)
+	p.StartPlugin(ctx)
+	defer p.StopPlugin(ctx)
+	broker.Produce(&KafkaMessage{Topic: "events", Value: []byte(`{"message":"lost"}`), Headers: map[string]string{"Xrs-Tenant-Id": "unknown"}})
+	broker.Produce(&KafkaMessage{Topic: "events", Value: []byte(`{"message":"hello"}`), Headers: map[string]string{"Xrs-Tenant-Id": "tenant1"}})
+	select {
+	case r := <-received:
+		if !strings.Contains(r.body, "hello") {
+			t.Errorf("unexpected payload: %s\n", r.body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not published")
+	}
+	for i := 0; i < 100 && broker.CommittedOffset("eel", "events") != 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if broker.CommittedOffset("eel", "events") != 2 {
+		t.Fatalf("offset not committed past event without work dispatcher: %d\n", broker.CommittedOffset("eel", "events"))
+	}
+}
+
+func TestKafkaPluginStopFetching(t *testing.T) {
+	started := make(chan bool, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- true
+		time.Sleep(300 * time.Millisecond)
+	}))
+	defer ts.Close()
+	dir := writeTestHandler(t, "tenant1", "slow.json", `{
+		"Version": "1.0",
+		"Name": "Slow",
+		"Active": true,
+		"Match": null,
+		"TerminateOnMatch": true,
+		"Transformation": {
+			"{{/}}": "{{/}}"
+		},
+		"Endpoint": "`+ts.URL+`"
+	}`)
+	defer os.RemoveAll(dir)
+	initTests(dir)
+	broker := NewInMemoryKafkaBroker()
+	defer SetKafkaDialer(GetKafkaDialer())
+	SetKafkaDialer(broker)
+	settings := &PluginSettings{
+		Type:       "KAFKA",
+		Name:       "KAFKA",
+		Parameters: map[string]interface{}{"Brokers": "localhost:9092", "Topics": []interface{}{"events"}, "GroupId": "eel"},
+	}
+	p := NewKafkaPlugin(settings).(FetchingInboundPlugin)
+	p.StartPlugin(Gctx)
+	broker.Produce(&KafkaMessage{Topic: "events", Value: []byte(`{"message":"slow"}`), Headers: map[string]string{"Xrs-Tenant-Id": "tenant1"}})
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not published")
+	}
+	// same order as Shutdown, the offset of the event in flight is committed before the consumer is closed
+	p.StopFetching(Gctx)
+	if !p.IsActive() {
+		t.Error("plugin no longer active after stop fetching")
+	}
+	GetWorkDispatcher(Gctx, "tenant1").Drain(Gctx, time.Now().Add(5*time.Second))
+	p.StopPlugin(Gctx)
+	if p.IsActive() {
+		t.Error("plugin still active after stop")
+	}
+	if broker.CommittedOffset("eel", "events") != 1 {
+		t.Fatalf("offset of drained event not committed: %d\n", broker.CommittedOffset("eel", "events"))
+	}
+}
+
+func TestKafkaPublisher(t *testing.T) {
+	dir := writeTestHandler(t, "tenant1", "kafka.json", `{
+		"Version": "1.0",
+		"Name": "Kafka",
+		"Active": true,
+		"Match": null,
+		"TerminateOnMatch": true,
+		"Transformation": {
+			"{{/}}": "{{/}}"
+		},
+		"Protocol": "kafka",
+		"Endpoint": "kafka://localhost:9092",
+		"HttpHeaders": {
+			"X-Tenant-Id": "{{tenant()}}"
+		},
+		"PublisherConfigs": {
+			"Topic": "out-{{/type}}",
+			"Key": "{{/id}}",
+			"Acks": "one"
+		}
+	}`)
+	defer os.RemoveAll(dir)
+	initTests(dir)
+	broker := NewInMemoryKafkaBroker()
+	defer SetKafkaDialer(GetKafkaDialer())
+	SetKafkaDialer(broker)
+	ts := httptest.NewServer(http.HandlerFunc(EventHandler))
+	defer ts.Close()
+	stats := Gctx.Value(EelTotalStats).(*ServiceStats)
+	postEvent := func() {
+		r, _ := http.NewRequest("POST", ts.URL, strings.NewReader(`{"type":"foo","id":"123"}`))
+		r.Header.Set("Xrs-Tenant-Id", "tenant1")
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("error posting event: %s\n", err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("eel returned unhappy status: %s\n", resp.Status)
+		}
+	}
+	outCount := atomic.LoadUint64(&stats.OutCount)
+	postEvent()
+	for i := 0; i < 100 && len(broker.Messages("out-foo")) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	msgs := broker.Messages("out-foo")
+	if len(msgs) != 1 {
+		t.Fatalf("unexpected number of published records: %d\n", len(msgs))
+	}
+	if string(msgs[0].Key) != "123" {
+		t.Errorf("wrong record key: %s\n", string(msgs[0].Key))
+	}
+	if msgs[0].Headers["X-Tenant-Id"] != "tenant1" {
+		t.Errorf("http header not sent as record header: %v\n", msgs[0].Headers)
+	}
+	if !strings.Contains(string(msgs[0].Value), `"foo"`) {
+		t.Errorf("unexpected record value: %s\n", string(msgs[0].Value))
+	}
+	for i := 0; i < 100 && atomic.LoadUint64(&stats.OutCount) == outCount; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if atomic.LoadUint64(&stats.OutCount) == outCount {
+		t.Error("out count not incremented")
+	}
+	// delivery failures are counted as errors
+	errorCount := atomic.LoadUint64(&stats.ErrorCount)
+	broker.SetProduceError(errors.New("broker not available"))
+	postEvent()
+	for i := 0; i < 100 && atomic.LoadUint64(&stats.ErrorCount) == errorCount; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if atomic.LoadUint64(&stats.ErrorCount) == errorCount {
+		t.Error("error count not incremented after delivery failure")
+	}
+	if len(broker.Messages("out-foo")) != 1 {
+		t.Errorf("unexpected number of published records: %d\n", len(broker.Messages("out-foo")))
+	}
+}
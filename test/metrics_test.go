@@ -0,0 +1,96 @@
+/**
+ * Copyright 2015 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/Comcast/eel/jtl"
+	. "github.com/Comcast/eel/util"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer endpoint.Close()
+	dir := writeTestHandler(t, "tenant1", "metrics.json", `{
+		"Version": "1.0",
+		"Name": "Metrics",
+		"Active": true,
+		"Match": null,
+		"TerminateOnMatch": true,
+		"Transformation": {
+			"{{/}}": "{{/}}"
+		},
+		"Endpoint": "`+endpoint.URL+`"
+	}`)
+	defer os.RemoveAll(dir)
+	initTests(dir)
+	o := NewPrometheusObserver()
+	RegisterObserver(Gctx, o)
+	defer Gctx.AddValue(EelObserver, nil)
+	ts := httptest.NewServer(http.HandlerFunc(EventHandler))
+	defer ts.Close()
+	r, _ := http.NewRequest("POST", ts.URL, strings.NewReader(`{"message":"hello"}`))
+	r.Header.Set("Xrs-Tenant-Id", "tenant1")
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("error posting event: %s\n", err.Error())
+	}
+	resp.Body.Close()
+	metrics := httptest.NewServer(http.HandlerFunc(MetricsHandler))
+	defer metrics.Close()
+	getMetrics := func() string {
+		resp, err := http.Get(metrics.URL)
+		if err != nil {
+			t.Fatalf("error getting metrics: %s\n", err.Error())
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return string(body)
+	}
+	out := regexp.MustCompile(`eel_message_out_total\{handler="Metrics",host="` + regexp.QuoteMeta(endpoint.URL) + `",tenant="tenant1",topic="[^"]*"\} 1`)
+	body := getMetrics()
+	for i := 0; i < 100 && !out.MatchString(body); i++ {
+		time.Sleep(10 * time.Millisecond)
+		body = getMetrics()
+	}
+	if !out.MatchString(body) {
+		t.Fatalf("out counter missing from metrics:\n%s\n", body)
+	}
+	expected := []string{
+		`# TYPE eel_in_total counter`,
+		`eel_message_in_total{handler="",tenant="tenant1",topic=""} 1`,
+		`# TYPE eel_message_process_duration_seconds histogram`,
+		`eel_message_process_duration_seconds_bucket{handler="Metrics",`,
+		`eel_http_request_duration_seconds_count{handler="Metrics",host="` + endpoint.URL + `",http_method="POST",http_status_code="200",tenant="tenant1",`,
+		`le="+Inf"} 1`,
+		`eel_workers_idle{tenant=""}`,
+		`eel_work_queue_fill_level{tenant=""} 0`,
+	}
+	for _, e := range expected {
+		if !strings.Contains(body, e) {
+			t.Errorf("missing %s in metrics:\n%s\n", e, body)
+		}
+	}
+}
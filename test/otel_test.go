@@ -0,0 +1,98 @@
+/**
+ * Copyright 2015 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/Comcast/eel/jtl"
+	. "github.com/Comcast/eel/util"
+)
+
+func TestOTelObserver(t *testing.T) {
+	const traceId = "4bf92f3577b34da6a3ce929d0e0e4736"
+	const parentSpanId = "00f067aa0ba902b7"
+	received := make(chan string, 10)
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("traceparent")
+	}))
+	defer endpoint.Close()
+	dir := writeTestHandler(t, "tenant1", "otel.json", `{
+		"Version": "1.0",
+		"Name": "OTel",
+		"Active": true,
+		"Match": null,
+		"TerminateOnMatch": true,
+		"Transformation": {
+			"{{/}}": "{{/}}"
+		},
+		"Endpoint": "`+endpoint.URL+`"
+	}`)
+	defer os.RemoveAll(dir)
+	initTests(dir)
+	fileName := filepath.Join(dir, "spans.json")
+	o, err := NewOTelObserver(&EelOpenTelemetryParams{Exporter: "file", FileName: fileName})
+	if err != nil {
+		t.Fatalf("could not create otel observer: %s\n", err.Error())
+	}
+	RegisterObserver(Gctx, MultiObserver{NewPrometheusObserver(), o})
+	defer Gctx.AddValue(EelObserver, nil)
+	ts := httptest.NewServer(http.HandlerFunc(EventHandler))
+	defer ts.Close()
+	r, _ := http.NewRequest("POST", ts.URL, strings.NewReader(`{"message":"hello"}`))
+	r.Header.Set("Xrs-Tenant-Id", "tenant1")
+	r.Header.Set("traceparent", "00-"+traceId+"-"+parentSpanId+"-01")
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("error posting event: %s\n", err.Error())
+	}
+	resp.Body.Close()
+	select {
+	case tp := <-received:
+		parts := strings.Split(tp, "-")
+		if len(parts) != 4 || parts[1] != traceId {
+			t.Errorf("trace context not propagated: %s\n", tp)
+		} else if parts[2] == parentSpanId {
+			t.Errorf("outbound request not in a new span: %s\n", tp)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("event not published")
+	}
+	// wait for the message.process span to end after publishing
+	time.Sleep(100 * time.Millisecond)
+	if GetPrometheusObserver(Gctx) == nil {
+		t.Error("prometheus observer not found in multi observer")
+	}
+	ShutdownObservers(Gctx)
+	buf, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatalf("could not read exported spans: %s\n", err.Error())
+	}
+	spans := string(buf)
+	for _, e := range []string{`"Name":"message.process"`, `"Name":"http.request"`, traceId, parentSpanId} {
+		if !strings.Contains(spans, e) {
+			t.Errorf("missing %s in exported spans:\n%s\n", e, spans)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+/**
+ * Copyright 2015 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/Comcast/eel/jtl"
+	. "github.com/Comcast/eel/util"
+)
+
+func TestShutdown(t *testing.T) {
+	var published int32
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		atomic.AddInt32(&published, 1)
+	}))
+	defer endpoint.Close()
+	dir := writeTestHandler(t, "tenant1", "slow.json", `{
+		"Version": "1.0",
+		"Name": "Slow",
+		"Active": true,
+		"Match": null,
+		"TerminateOnMatch": true,
+		"Transformation": {
+			"{{/}}": "{{/}}"
+		},
+		"Endpoint": "`+endpoint.URL+`"
+	}`)
+	defer os.RemoveAll(dir)
+	ts := httptest.NewServer(http.HandlerFunc(EventHandler))
+	defer ts.Close()
+	postEvents := func(n int) {
+		for i := 0; i < n; i++ {
+			r, _ := http.NewRequest("POST", ts.URL, strings.NewReader(fmt.Sprintf(`{"message":"event-%d"}`, i)))
+			r.Header.Set("Xrs-Tenant-Id", "tenant1")
+			resp, err := http.DefaultClient.Do(r)
+			if err != nil {
+				t.Fatalf("error posting event: %s\n", err.Error())
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusAccepted {
+				t.Fatalf("eel returned unhappy status: %s\n", resp.Status)
+			}
+		}
+	}
+	// queued events and in flight publishes complete before shutdown returns
+	initTests(dir)
+	GetConfig(Gctx).ShutdownTimeout = 5000
+	Gctx.AddValue(EelDispatcher+"_tenant1", func() *WorkDispatcher {
+		dp := NewWorkDispatcher(1, 10, "tenant1")
+		dp.Start(Gctx)
+		return dp
+	}())
+	postEvents(3)
+	Shutdown(Gctx)
+	if atomic.LoadInt32(&published) != 3 {
+		t.Fatalf("events not published before shutdown: %d\n", atomic.LoadInt32(&published))
+	}
+	// events that cannot be handled before the deadline are spilled over
+	initTests(dir)
+	GetConfig(Gctx).ShutdownTimeout = 100
+	spillDir := filepath.Join(dir, "spillover")
+	sq, err := NewSpillOverQueue(Gctx, spillDir, 0, 0)
+	if err != nil {
+		t.Fatalf("could not open spill-over queue: %s\n", err.Error())
+	}
+	Gctx.AddValue(EelSpillOverQueue, sq)
+	Gctx.AddValue(EelDispatcher+"_tenant1", func() *WorkDispatcher {
+		dp := NewWorkDispatcher(1, 10, "tenant1")
+		dp.Start(Gctx)
+		return dp
+	}())
+	atomic.StoreInt32(&published, 0)
+	postEvents(4)
+	start := time.Now()
+	Shutdown(Gctx)
+	if time.Since(start) > 2*time.Second {
+		t.Errorf("shutdown did not respect drain deadline: %s\n", time.Since(start).String())
+	}
+	sq, err = NewSpillOverQueue(Gctx, spillDir, 0, 0)
+	if err != nil {
+		t.Fatalf("could not reopen spill-over queue: %s\n", err.Error())
+	}
+	defer sq.Close(Gctx)
+	if sq.Stats(Gctx).Depth != 3 {
+		t.Errorf("unexpected number of spilled over events after shutdown: %d\n", sq.Stats(Gctx).Depth)
+	}
+}
@@ -0,0 +1,186 @@
+/**
+ * Copyright 2015 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/Comcast/eel/jtl"
+	. "github.com/Comcast/eel/util"
+)
+
+func TestSpillOverQueue(t *testing.T) {
+	received := make(chan *kafkaTestRequest, 10)
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- &kafkaTestRequest{string(body), r.Header.Get("X-B3-TraceId"), r.Header.Get("X-Tenant-Id")}
+	}))
+	defer endpoint.Close()
+	dir := writeTestHandler(t, "tenant1", "spill.json", `{
+		"Version": "1.0",
+		"Name": "Spill",
+		"Active": true,
+		"Match": null,
+		"TerminateOnMatch": true,
+		"Transformation": {
+			"{{/}}": "{{/}}"
+		},
+		"Endpoint": "`+endpoint.URL+`",
+		"HttpHeaders": {
+			"X-B3-TraceId": "{{traceid()}}",
+			"X-Tenant-Id": "{{tenant()}}"
+		}
+	}`)
+	defer os.RemoveAll(dir)
+	initTests(dir)
+	GetConfig(Gctx).MessageQueueTimeout = 50
+	// worker pool that has not been started yet, so the work queue is always full
+	dp := NewWorkDispatcher(1, 0, "tenant1")
+	Gctx.AddValue(EelDispatcher+"_tenant1", dp)
+	spillDir := filepath.Join(dir, "spillover")
+	sq, err := NewSpillOverQueue(Gctx, spillDir, 100, 0)
+	if err != nil {
+		t.Fatalf("could not open spill-over queue: %s\n", err.Error())
+	}
+	Gctx.AddValue(EelSpillOverQueue, sq)
+	defer Gctx.AddValue(EelSpillOverQueue, nil)
+	ts := httptest.NewServer(http.HandlerFunc(EventHandler))
+	defer ts.Close()
+	for i := 0; i < 3; i++ {
+		r, _ := http.NewRequest("POST", ts.URL, strings.NewReader(fmt.Sprintf(`{"message":"spill-%d"}`, i)))
+		r.Header.Set("Xrs-Tenant-Id", "tenant1")
+		r.Header.Set("X-B3-TraceId", fmt.Sprintf("trace-%d", i))
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("error posting event: %s\n", err.Error())
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			t.Fatalf("eel returned unhappy status: %s\n", resp.Status)
+		}
+	}
+	stats := sq.Stats(Gctx)
+	if stats.Depth != 3 {
+		t.Fatalf("unexpected spill-over depth: %d\n", stats.Depth)
+	}
+	if stats.Segments < 2 {
+		t.Errorf("segments not rolled over: %d\n", stats.Segments)
+	}
+	// spilled over events survive a restart
+	sq.Close(Gctx)
+	sq, err = NewSpillOverQueue(Gctx, spillDir, 100, 0)
+	if err != nil {
+		t.Fatalf("could not reopen spill-over queue: %s\n", err.Error())
+	}
+	Gctx.AddValue(EelSpillOverQueue, sq)
+	if sq.Stats(Gctx).Depth != 3 {
+		t.Fatalf("unexpected spill-over depth after restart: %d\n", sq.Stats(Gctx).Depth)
+	}
+	// events are drained once there is capacity
+	dp.Start(Gctx)
+	defer dp.Stop(Gctx)
+	sq.Start(Gctx)
+	for i := 0; i < 3; i++ {
+		select {
+		case r := <-received:
+			if !strings.Contains(r.body, fmt.Sprintf("spill-%d", i)) {
+				t.Errorf("unexpected payload: %s\n", r.body)
+			}
+			if r.traceId != fmt.Sprintf("trace-%d", i) {
+				t.Errorf("trace id not restored: %s\n", r.traceId)
+			}
+			if r.tenant != "tenant1" {
+				t.Errorf("tenant not restored: %s\n", r.tenant)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("spilled over event not drained")
+		}
+	}
+	for i := 0; i < 100 && (sq.Stats(Gctx).Depth != 0 || sq.Stats(Gctx).InFlight != 0); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats := sq.Stats(Gctx); stats.Segments != 1 || stats.Size != 0 {
+		t.Errorf("drained segments not deleted: %d segments, %d bytes\n", stats.Segments, stats.Size)
+	}
+	status := httptest.NewServer(http.HandlerFunc(StatusHandler))
+	defer status.Close()
+	resp, err := http.Get(status.URL)
+	if err != nil {
+		t.Fatalf("error getting status: %s\n", err.Error())
+	}
+	defer resp.Body.Close()
+	var state struct {
+		Stats struct {
+			SpillOver *SpillOverStats
+		}
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	json.Unmarshal(body, &state)
+	if state.Stats.SpillOver == nil || state.Stats.SpillOver.Depth != 0 {
+		t.Errorf("spill-over stats missing or not drained: %s\n", string(body))
+	}
+	sq.Close(Gctx)
+	sq, err = NewSpillOverQueue(Gctx, spillDir, 100, 0)
+	if err != nil {
+		t.Fatalf("could not reopen spill-over queue: %s\n", err.Error())
+	}
+	defer sq.Close(Gctx)
+	if sq.Stats(Gctx).Depth != 0 {
+		t.Errorf("drained events delivered again after restart: %d\n", sq.Stats(Gctx).Depth)
+	}
+}
+
+func TestSpillOverQueueMaxSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "eel-spillover-max")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %s\n", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	// no dispatcher for the tenant, drained events are dropped right away
+	sctx := Gctx.SubContext()
+	sctx.AddValue(EelTenantId, "tenant-max")
+	sq, err := NewSpillOverQueue(sctx, dir, 10000, 200)
+	if err != nil {
+		t.Fatalf("could not open spill-over queue: %s\n", err.Error())
+	}
+	defer sq.Close(sctx)
+	err = sq.Push(sctx, `{"message":"first"}`)
+	if err != nil {
+		t.Fatalf("could not push event: %s\n", err.Error())
+	}
+	if sq.Push(sctx, `{"message":"second"}`) != ErrSpillOverQueueFull {
+		t.Fatalf("spill-over queue not full\n")
+	}
+	sq.Start(sctx)
+	for i := 0; i < 100 && sq.Stats(sctx).Depth != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	// the segment is still being written to, the drained event no longer counts towards the max size
+	err = sq.Push(sctx, `{"message":"third"}`)
+	if err != nil {
+		t.Errorf("drained events still count towards max size: %s\n", err.Error())
+	}
+}
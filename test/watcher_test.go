@@ -0,0 +1,106 @@
+/**
+ * Copyright 2015 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/Comcast/eel/jtl"
+	. "github.com/Comcast/eel/util"
+)
+
+const watchedHandler = `{
+	"Version": "1.0",
+	"Name": "%s",
+	"Active": true,
+	"Match": null,
+	"TerminateOnMatch": true,
+	"Transformation": {
+		"{{/}}": "{{/}}"
+	}
+}`
+
+func TestHandlerWatcher(t *testing.T) {
+	dir := writeTestHandler(t, "tenant1", "watched.json", fmt.Sprintf(watchedHandler, "Watched"))
+	defer os.RemoveAll(dir)
+	initTests(dir)
+	hw := NewHandlerWatcher(Gctx, 20*time.Millisecond, 100*time.Millisecond)
+	hw.Start(Gctx)
+	defer hw.Stop(Gctx)
+	hasHandler := func(name string) bool {
+		return GetHandlerFactory(Gctx).CustomHandlerMap["tenant1"][name] != nil
+	}
+	if !hasHandler("Watched") {
+		t.Fatal("handler not loaded")
+	}
+	// valid change is picked up
+	err := ioutil.WriteFile(filepath.Join(dir, "tenant1", "watched.json"), []byte(fmt.Sprintf(watchedHandler, "WatchedAgain")), 0644)
+	if err != nil {
+		t.Fatalf("could not update handler: %s\n", err.Error())
+	}
+	for i := 0; i < 300 && !hasHandler("WatchedAgain"); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !hasHandler("WatchedAgain") {
+		t.Fatal("changed handler not hot-reloaded")
+	}
+	// invalid change is rejected and current handlers are kept
+	hf := GetHandlerFactory(Gctx)
+	err = ioutil.WriteFile(filepath.Join(dir, "tenant1", "broken.json"), []byte(`{"Version": "1.0", "Name": "Broken",`), 0644)
+	if err != nil {
+		t.Fatalf("could not write handler: %s\n", err.Error())
+	}
+	time.Sleep(500 * time.Millisecond)
+	if GetHandlerFactory(Gctx) != hf || !hasHandler("WatchedAgain") {
+		t.Fatal("handlers replaced despite vet warnings")
+	}
+	if warnings := HotReload(Gctx, false); len(warnings) == 0 {
+		t.Error("no warnings for broken handler")
+	}
+}
+
+func TestHotReloadLint(t *testing.T) {
+	dir := writeTestHandler(t, "tenant1", "watched.json", fmt.Sprintf(watchedHandler, "Watched"))
+	defer os.RemoveAll(dir)
+	initTests(dir)
+	hf := GetHandlerFactory(Gctx)
+	// the handler loads but fails the lint checks of /vet
+	err := ioutil.WriteFile(filepath.Join(dir, "tenant1", "lint.json"), []byte(`{
+		"Version": "1.0",
+		"Name": "Lint",
+		"Active": true,
+		"Match": null,
+		"TerminateOnMatch": true,
+		"Transformation": {
+			"{{/}}": "{{transform('missing', '{{/}}')}}"
+		}
+	}`), 0644)
+	if err != nil {
+		t.Fatalf("could not write handler: %s\n", err.Error())
+	}
+	if warnings := HotReload(Gctx, false); len(warnings) == 0 {
+		t.Error("no warnings for handler failing lint checks")
+	}
+	if GetHandlerFactory(Gctx) != hf || GetHandlerFactory(Gctx).CustomHandlerMap["tenant1"]["Lint"] != nil {
+		t.Fatal("handlers replaced despite lint warnings")
+	}
+}
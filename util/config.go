@@ -73,6 +73,15 @@ type EelSettings struct {
 	HandlerConfigPath              string
 	AllowPartner                   bool
 	DefaultPartner                 string
+	DeadLetterFile                 string
+	SpillOverDir                   string
+	SpillOverSegmentSize           int64
+	SpillOverMaxSize               int64
+	ShutdownTimeout                int
+	PrometheusMetrics              bool
+	OpenTelemetry                  *EelOpenTelemetryParams
+	HandlerWatchInterval           int
+	HandlerWatchDebounce           int
 }
 
 // EelDebugLogParams struct is an optional debug white list and log param config in eel settings
@@ -119,12 +128,19 @@ const (
 	EelTenantId             = "Eel.TenantId"
 	EelPartnerId            = "Eel.PartnerId"
 	EelCustomProperties     = "Eel.CustomProperties"
+	EelVariables            = "Eel.Variables"
 	EelRetryService         = "Eel.RetryService"
 	EelErrors               = "Eel.Errors"
 	EelSyncPath             = "Eel.SyncPath"
 	EelTraceLogger          = "Eel.TraceLogger"
 	EelCache                = "Eel.Cache"
 	EelTenantIds            = "Eel.TenantIds"
+	EelDeadLetterStore      = "Eel.DeadLetterStore"
+	EelSpillOverQueue       = "Eel.SpillOverQueue"
+	EelOTelContext          = "Eel.OTelContext"
+	EelHandlerWatcher       = "Eel.HandlerWatcher"
+	EelPathTrace            = "Eel.PathTrace"
+	EelLiteralSecrets       = "Eel.LiteralSecrets"
 	LogTenantId             = "gears.app.id"
 	LogPartnerId            = "gears.partner.id"
 )
@@ -189,28 +205,49 @@ func GetCustomProperties(ctx Context) map[string]interface{} {
 	return make(map[string]interface{}, 0)
 }
 
+// GetVariables gets the variables of the current handler evaluated for the current event from context.
+func GetVariables(ctx Context) map[string]interface{} {
+	if ctx.Value(EelVariables) != nil {
+		return ctx.Value(EelVariables).(map[string]interface{})
+	}
+	return make(map[string]interface{}, 0)
+}
+
 // GetConfigFromFile loads config.json from disk and returns a pointer to a EelSettings struct.
 func GetConfigFromFile(ctx Context) *EelSettings {
-	configFile, err := os.Open(ConfigPath)
+	config, cause, err := readConfigFile()
 	if err != nil {
 		// csv-context-go may not be ready yet for logging
 		fmt.Printf("{ \"error\" : \"%s\" }", err.Error())
-		ctx.Log().Error("error_type", "get_config", "cause", "open_config", "error", err.Error())
+		ctx.Log().Error("error_type", "get_config", "cause", cause, "error", err.Error())
 		os.Exit(1)
 	}
+	return config
+}
+
+// ReadConfigFromFile loads config.json from disk like GetConfigFromFile but returns an error instead of exiting.
+func ReadConfigFromFile(ctx Context) (*EelSettings, error) {
+	config, cause, err := readConfigFile()
+	if err != nil {
+		ctx.Log().Error("error_type", "get_config", "cause", cause, "error", err.Error())
+	}
+	return config, err
+}
+
+func readConfigFile() (*EelSettings, string, error) {
+	configFile, err := os.Open(ConfigPath)
+	if err != nil {
+		return nil, "open_config", err
+	}
 	defer configFile.Close()
 	configData, err := ioutil.ReadAll(configFile)
 	if err != nil {
-		fmt.Printf("{ \"error\" : \"%s\" }", err.Error())
-		ctx.Log().Error("error_type", "get_config", "cause", "read_config", "error", err.Error())
-		os.Exit(1)
+		return nil, "read_config", err
 	}
 	var config EelSettings
 	err = json.Unmarshal(configData, &config)
 	if err != nil {
-		fmt.Printf("{ \"error\" : \"%s\" }", err.Error())
-		ctx.Log().Error("error_type", "get_config", "cause", "parse_config", "error", err.Error())
-		os.Exit(1)
+		return nil, "parse_config", err
 	}
-	return &config
+	return &config, "", nil
 }
@@ -88,6 +88,31 @@ func (e ParseError) Error() string {
 	return fmt.Sprintf("%s", e.Message)
 }
 
+// ParseDiagnostic is a parse error of a jpath expression in a handler config, located by handler file, json pointer
+// of the handler field and line and column within the expression.
+type ParseDiagnostic struct {
+	Message    string
+	File       string // handler file
+	Pointer    string // json pointer of the handler field, for example /Transformation/{{/a}}
+	Expression string // the malformed expression
+	Line       int    // 1-based line of the error within the expression
+	Column     int    // 1-based column of the error within its line
+	Excerpt    string // line of the expression with a caret pointing at the column
+	Suggestion string // optional suggestion, for example for a misspelled function name
+	Check      string // syntax, arity, unknown_function, ifte_condition, unknown_transformation, unknown_property or unknown_variable
+}
+
+func (e ParseDiagnostic) Error() string {
+	msg := fmt.Sprintf("%s:%d:%d: %s", e.Pointer, e.Line, e.Column, e.Message)
+	if e.File != "" {
+		msg = e.File + ":" + msg
+	}
+	if e.Suggestion != "" {
+		msg += " (" + e.Suggestion + ")"
+	}
+	return msg + "\n" + e.Excerpt
+}
+
 // ClearErrors clears any stale errors from current transacction in case lib user recycles contexts
 func ClearErrors(ctx Context) {
 	if ctx == nil {
@@ -95,8 +95,33 @@ func InitHttpTransportWithDial(ctx Context, dial func(network, addr string) (net
 	ctx.AddValue(EelHttpClient, client)
 }
 
+// HttpResponse holds status, headers and body of the response to an outbound http request.
+type HttpResponse struct {
+	Status int
+	Header http.Header
+	Body   string
+}
+
 // HitEndpoint helper method for posting payloads to endpoints. Supports other verbs, http headers and basic auth.
 func HitEndpoint(ctx Context, url string, payload string, verb string, headers map[string]string, auth map[string]string) (string, int, error) {
+	body, status, _, err := hitEndpoint(ctx, url, payload, verb, headers, auth, GetHttpClient(ctx))
+	return body, status, err
+}
+
+// HitEndpointWithResponse same as HitEndpoint but returns status, headers and body of the response. A positive timeout
+// replaces HttpTimeout from config.json for this request.
+func HitEndpointWithResponse(ctx Context, url string, payload string, verb string, headers map[string]string, auth map[string]string, timeout time.Duration) (*HttpResponse, error) {
+	client := GetHttpClient(ctx)
+	if timeout > 0 {
+		c := *client
+		c.Timeout = timeout
+		client = &c
+	}
+	body, status, header, err := hitEndpoint(ctx, url, payload, verb, headers, auth, client)
+	return &HttpResponse{status, header, body}, err
+}
+
+func hitEndpoint(ctx Context, url string, payload string, verb string, headers map[string]string, auth map[string]string, client *http.Client) (string, int, http.Header, error) {
 	stats := ctx.Value(EelTotalStats).(*ServiceStats)
 	stats.IncBytesOut(len(payload))
 
@@ -104,7 +129,7 @@ func HitEndpoint(ctx Context, url string, payload string, verb string, headers m
 	if err != nil {
 		ctx.Log().Error("op", "HitEndpoint", "error_type", "reaching_service", "cause", "error_new_request", "url", url, "verb", verb, "error", err.Error())
 		stats.IncErrors()
-		return "", 0, err
+		return "", 0, nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("User-Agent", "EEL")
@@ -124,8 +149,11 @@ func HitEndpoint(ctx Context, url string, payload string, verb string, headers m
 		}
 	}
 	if auth != nil {
-		if auth["type"] == "basic" {
+		switch auth["type"] {
+		case "basic":
 			req.SetBasicAuth(auth["username"], auth["password"])
+		case "bearer":
+			req.Header.Set("Authorization", "Bearer "+auth["token"])
 		}
 	}
 
@@ -135,6 +163,7 @@ func HitEndpoint(ctx Context, url string, payload string, verb string, headers m
 
 	uri := req.URL.Redacted()
 	ctx = Start(ctx, HTTPRequest, map[string]string{HTTPURLKey: uri})
+	InjectTraceContextHeader(ctx, req.Header)
 	start := time.Now()
 	defer func() {
 		var status string
@@ -152,13 +181,13 @@ func HitEndpoint(ctx Context, url string, payload string, verb string, headers m
 
 	//AddLatencyLog(ctx, stats, "stat.eel.time")
 	// send request
-	if resp, err = GetHttpClient(ctx).Do(req); err != nil {
+	if resp, err = client.Do(req); err != nil {
 		ctx.Log().Error("op", "HitEndpoint", "error_type", "reaching_service", "cause", "get_http_client", "trace.out.url", url, "trace.out.verb", verb, "trace.out.headers", headers, "error", err.Error())
 		stats.IncErrors()
 		if ctx.LogValue("destination") != nil {
 			ctx.Log().Metric("drops", M_Namespace, "xrs", M_Metric, "drops", M_Unit, "Count", M_Dims, "app="+AppId+"&env="+EnvName+"&instance="+InstanceName+"&destination="+ctx.LogValue("destination").(string), M_Val, 1.0)
 		}
-		return "", 0, err
+		return "", 0, nil, err
 	}
 
 	//AddLatencyLog(ctx, stats, "stat.external.time")
@@ -170,7 +199,7 @@ func HitEndpoint(ctx Context, url string, payload string, verb string, headers m
 		if readErr != nil {
 			ctx.Log().Error("op", "HitEndpoint", "error_type", "reaching_service", "cause", "error_reading_response", "trace.out.url", url, "trace.out.verb", verb, "trace.out.headers", headers, "status", strconv.Itoa(resp.StatusCode), "error", readErr.Error())
 			stats.IncErrors()
-			return "", resp.StatusCode, readErr
+			return "", resp.StatusCode, resp.Header, readErr
 		}
 		closeErr := resp.Body.Close()
 		if closeErr != nil {
@@ -178,7 +207,7 @@ func HitEndpoint(ctx Context, url string, payload string, verb string, headers m
 			stats.IncErrors()
 		}
 		if body == nil {
-			return "", resp.StatusCode, nil
+			return "", resp.StatusCode, resp.Header, nil
 		}
 	}
 
@@ -192,5 +221,5 @@ func HitEndpoint(ctx Context, url string, payload string, verb string, headers m
 	if ctx.LogValue("destination") != nil {
 		ctx.Log().Metric("hits", M_Namespace, "xrs", M_Metric, "hits", M_Unit, "Count", M_Dims, "app="+AppId+"&env="+EnvName+"&instance="+InstanceName+"&destination="+ctx.LogValue("destination").(string), M_Val, 1.0)
 	}
-	return string(body), resp.StatusCode, nil
+	return string(body), resp.StatusCode, resp.Header, nil
 }
@@ -23,8 +23,31 @@ type (
 		End(ctx Context, attrs map[string]string, err error)
 		Record(ctx Context, metric string, attrs map[string]string, val int)
 	}
+	// MultiObserver forwards to several observers, for example for metrics and tracing at the same time
+	MultiObserver []Observer
 )
 
+// InitObservers registers the observers enabled in config.json
+func InitObservers(ctx Context) {
+	observers := make(MultiObserver, 0)
+	if GetConfig(ctx).PrometheusMetrics {
+		observers = append(observers, NewPrometheusObserver())
+	}
+	if params := GetConfig(ctx).OpenTelemetry; params != nil && params.Exporter != "" {
+		o, err := NewOTelObserver(params)
+		if err != nil {
+			ctx.Log().Error("error_type", "otel", "cause", "bad_config", "error", err.Error())
+		} else {
+			observers = append(observers, o)
+		}
+	}
+	if len(observers) == 1 {
+		RegisterObserver(ctx, observers[0])
+	} else if len(observers) > 1 {
+		RegisterObserver(ctx, observers)
+	}
+}
+
 // RegisterObserver registers an observer implementation
 func RegisterObserver(ctx Context, o Observer) {
 	ctx.AddValue(EelObserver, o)
@@ -52,3 +75,42 @@ func Record(ctx Context, metric string, attrs map[string]string, val int) {
 		o.Record(ctx, metric, attrs, val)
 	}
 }
+
+// ShutdownObservers flushes and stops observers that need to be shut down
+func ShutdownObservers(ctx Context) {
+	for _, o := range GetObservers(ctx) {
+		if s, ok := o.(interface{ Shutdown(Context) }); ok {
+			s.Shutdown(ctx)
+		}
+	}
+}
+
+// GetObservers returns the registered observers
+func GetObservers(ctx Context) []Observer {
+	switch o := ctx.Value(EelObserver).(type) {
+	case MultiObserver:
+		return o
+	case Observer:
+		return []Observer{o}
+	}
+	return nil
+}
+
+func (m MultiObserver) Start(ctx Context, trace string, attrs map[string]string) Context {
+	for _, o := range m {
+		ctx = o.Start(ctx, trace, attrs)
+	}
+	return ctx
+}
+
+func (m MultiObserver) End(ctx Context, attrs map[string]string, err error) {
+	for _, o := range m {
+		o.End(ctx, attrs, err)
+	}
+}
+
+func (m MultiObserver) Record(ctx Context, metric string, attrs map[string]string, val int) {
+	for _, o := range m {
+		o.Record(ctx, metric, attrs, val)
+	}
+}
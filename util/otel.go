@@ -0,0 +1,218 @@
+/**
+ * Copyright 2015 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type (
+	// EelOpenTelemetryParams configures the OpenTelemetry observer in eel settings.
+	EelOpenTelemetryParams struct {
+		Exporter    string            // otlp, stdout or file, blank disables tracing
+		Endpoint    string            // otlp collector host:port, default localhost:4318
+		UrlPath     string            // otlp traces path, default /v1/traces
+		Insecure    bool              // use http instead of https for otlp
+		Headers     map[string]string // additional otlp request headers
+		FileName    string            // output file for the file exporter
+		ServiceName string            // default eel
+		SampleRatio float64           // ratio of new traces to sample, 0 samples all
+	}
+	// OTelObserver is an Observer creating OpenTelemetry spans. Inbound W3C trace context (traceparent) is adopted from
+	// the request headers of the event, the current span is kept in the context and injected into outbound requests.
+	OTelObserver struct {
+		provider *sdktrace.TracerProvider
+		tracer   trace.Tracer
+		closer   io.Closer
+	}
+	headerMapCarrier map[string]string
+)
+
+var otelPropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// NewOTelObserver creates an OpenTelemetry observer with the configured exporter.
+func NewOTelObserver(params *EelOpenTelemetryParams) (*OTelObserver, error) {
+	o := new(OTelObserver)
+	var exporter sdktrace.SpanExporter
+	var err error
+	opts := make([]sdktrace.TracerProviderOption, 0)
+	switch strings.ToLower(params.Exporter) {
+	case "otlp":
+		hopts := make([]otlptracehttp.Option, 0)
+		if params.Endpoint != "" {
+			hopts = append(hopts, otlptracehttp.WithEndpoint(params.Endpoint))
+		}
+		if params.UrlPath != "" {
+			hopts = append(hopts, otlptracehttp.WithURLPath(params.UrlPath))
+		}
+		if params.Insecure {
+			hopts = append(hopts, otlptracehttp.WithInsecure())
+		}
+		if len(params.Headers) > 0 {
+			hopts = append(hopts, otlptracehttp.WithHeaders(params.Headers))
+		}
+		exporter, err = otlptracehttp.New(context.Background(), hopts...)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithSyncer(exporter))
+	case "file":
+		if params.FileName == "" {
+			return nil, errors.New("missing file name for file exporter")
+		}
+		file, err := os.OpenFile(params.FileName, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		o.closer = file
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, sdktrace.WithSyncer(exporter))
+	default:
+		return nil, errors.New("unknown exporter " + params.Exporter)
+	}
+	serviceName := params.ServiceName
+	if serviceName == "" {
+		serviceName = "eel"
+	}
+	opts = append(opts, sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName))))
+	if params.SampleRatio > 0 {
+		opts = append(opts, sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(params.SampleRatio))))
+	}
+	o.provider = sdktrace.NewTracerProvider(opts...)
+	o.tracer = o.provider.Tracer("github.com/Comcast/eel")
+	return o, nil
+}
+
+// Start starts a span as child of the span in ctx or, if there is none, of the trace context in the inbound request headers.
+func (o *OTelObserver) Start(ctx Context, name string, attrs map[string]string) Context {
+	parent := otelContext(ctx)
+	if !trace.SpanContextFromContext(parent).IsValid() {
+		if header, ok := ctx.Value(EelRequestHeader).(http.Header); ok {
+			parent = otelPropagator.Extract(parent, propagation.HeaderCarrier(header))
+		}
+	}
+	kind := trace.SpanKindInternal
+	if name == HTTPRequest {
+		kind = trace.SpanKindClient
+	} else if name == MessageProcess {
+		kind = trace.SpanKindConsumer
+	}
+	sctx, _ := o.tracer.Start(parent, name, trace.WithSpanKind(kind), trace.WithAttributes(otelAttributes(attrs)...))
+	c := ctx.SubContext()
+	c.AddValue(EelOTelContext, sctx)
+	return c
+}
+
+// End ends the span in ctx and records err if not nil.
+func (o *OTelObserver) End(ctx Context, attrs map[string]string, err error) {
+	span := trace.SpanFromContext(otelContext(ctx))
+	if len(attrs) > 0 {
+		span.SetAttributes(otelAttributes(attrs)...)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Record is a no-op, metrics are exposed by the prometheus observer.
+func (o *OTelObserver) Record(ctx Context, metric string, attrs map[string]string, val int) {
+}
+
+// Shutdown flushes pending spans and stops the exporter.
+func (o *OTelObserver) Shutdown(ctx Context) {
+	err := o.provider.Shutdown(context.Background())
+	if err != nil {
+		ctx.Log().Error("error_type", "otel", "cause", "shutdown", "error", err.Error())
+	}
+	if o.closer != nil {
+		o.closer.Close()
+	}
+}
+
+// InjectTraceContext adds the W3C trace context of the current span in ctx (if any) to outbound headers.
+func InjectTraceContext(ctx Context, headers map[string]string) {
+	if headers == nil {
+		return
+	}
+	if c, ok := ctx.Value(EelOTelContext).(context.Context); ok {
+		otelPropagator.Inject(c, headerMapCarrier(headers))
+	}
+}
+
+// InjectTraceContextHeader adds the W3C trace context of the current span in ctx (if any) to an outbound http header.
+func InjectTraceContextHeader(ctx Context, header http.Header) {
+	if c, ok := ctx.Value(EelOTelContext).(context.Context); ok {
+		otelPropagator.Inject(c, propagation.HeaderCarrier(header))
+	}
+}
+
+func otelContext(ctx Context) context.Context {
+	if c, ok := ctx.Value(EelOTelContext).(context.Context); ok {
+		return c
+	}
+	return context.Background()
+}
+
+func otelAttributes(attrs map[string]string) []attribute.KeyValue {
+	kvs := make([]attribute.KeyValue, 0, len(attrs))
+	for k, v := range attrs {
+		kvs = append(kvs, attribute.String(k, v))
+	}
+	return kvs
+}
+
+func (c headerMapCarrier) Get(key string) string {
+	return c[key]
+}
+
+func (c headerMapCarrier) Set(key string, value string) {
+	c[key] = value
+}
+
+func (c headerMapCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,211 @@
+/**
+ * Copyright 2015 Comcast Cable Communications Management, LLC
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *   http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package util
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type (
+	// PrometheusObserver is an Observer that aggregates recorded metrics in memory and writes them in Prometheus text
+	// exposition format. Metrics ending in .duration are recorded as latency histograms in seconds, all other metrics
+	// are counters. Every series is labeled with tenant, handler and topic (taken from attributes or context) plus all
+	// other attributes except the full URL.
+	PrometheusObserver struct {
+		counters   map[string]*prometheusCounter
+		histograms map[string]*prometheusHistogram
+		sync.Mutex
+	}
+	prometheusCounter struct {
+		name   string
+		labels string
+		value  float64
+	}
+	prometheusHistogram struct {
+		name    string
+		labels  string
+		buckets []uint64
+		sum     float64
+		count   uint64
+	}
+)
+
+// PrometheusBuckets are the upper bounds in seconds of the latency histogram buckets.
+var PrometheusBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// NewPrometheusObserver creates a new observer, use RegisterObserver to activate it.
+func NewPrometheusObserver() *PrometheusObserver {
+	o := new(PrometheusObserver)
+	o.counters = make(map[string]*prometheusCounter, 0)
+	o.histograms = make(map[string]*prometheusHistogram, 0)
+	return o
+}
+
+// GetPrometheusObserver returns the registered prometheus observer or nil.
+func GetPrometheusObserver(ctx Context) *PrometheusObserver {
+	for _, o := range GetObservers(ctx) {
+		if p, ok := o.(*PrometheusObserver); ok {
+			return p
+		}
+	}
+	return nil
+}
+
+func (o *PrometheusObserver) Start(ctx Context, trace string, attrs map[string]string) Context {
+	return ctx.SubContext()
+}
+
+func (o *PrometheusObserver) End(ctx Context, attrs map[string]string, err error) {
+}
+
+func (o *PrometheusObserver) Record(ctx Context, metric string, attrs map[string]string, val int) {
+	labels := prometheusLabels(ctx, attrs)
+	name := "eel_" + prometheusName(metric)
+	o.Lock()
+	defer o.Unlock()
+	if strings.HasSuffix(metric, ".duration") {
+		name += "_seconds"
+		h, ok := o.histograms[name+labels]
+		if !ok {
+			h = &prometheusHistogram{name: name, labels: labels, buckets: make([]uint64, len(PrometheusBuckets))}
+			o.histograms[name+labels] = h
+		}
+		secs := float64(val) / 1000
+		for i, b := range PrometheusBuckets {
+			if secs <= b {
+				h.buckets[i]++
+			}
+		}
+		h.sum += secs
+		h.count++
+	} else {
+		name += "_total"
+		c, ok := o.counters[name+labels]
+		if !ok {
+			c = &prometheusCounter{name: name, labels: labels}
+			o.counters[name+labels] = c
+		}
+		c.value += float64(val)
+	}
+}
+
+// WriteMetrics writes all recorded metrics in Prometheus text exposition format.
+func (o *PrometheusObserver) WriteMetrics(w io.Writer) {
+	o.Lock()
+	defer o.Unlock()
+	keys := make([]string, 0, len(o.counters))
+	for k := range o.counters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	lastName := ""
+	for _, k := range keys {
+		c := o.counters[k]
+		if c.name != lastName {
+			fmt.Fprintf(w, "# TYPE %s counter\n", c.name)
+			lastName = c.name
+		}
+		fmt.Fprintf(w, "%s{%s} %s\n", c.name, c.labels, prometheusValue(c.value))
+	}
+	keys = make([]string, 0, len(o.histograms))
+	for k := range o.histograms {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		h := o.histograms[k]
+		if h.name != lastName {
+			fmt.Fprintf(w, "# TYPE %s histogram\n", h.name)
+			lastName = h.name
+		}
+		sep := ""
+		if h.labels != "" {
+			sep = ","
+		}
+		for i, b := range PrometheusBuckets {
+			fmt.Fprintf(w, "%s_bucket{%s%sle=\"%s\"} %d\n", h.name, h.labels, sep, prometheusValue(b), h.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", h.name, h.labels, sep, h.count)
+		fmt.Fprintf(w, "%s_sum{%s} %s\n", h.name, h.labels, prometheusValue(h.sum))
+		fmt.Fprintf(w, "%s_count{%s} %d\n", h.name, h.labels, h.count)
+	}
+}
+
+// WritePrometheusMetric writes a single sample with its type header in Prometheus text exposition format.
+func WritePrometheusMetric(w io.Writer, name string, metricType string, labels map[string]string, val float64) {
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+	fmt.Fprintf(w, "%s{%s} %s\n", name, prometheusLabelString(labels), prometheusValue(val))
+}
+
+func prometheusLabels(ctx Context, attrs map[string]string) string {
+	labels := map[string]string{"tenant": "", "handler": "", "topic": ""}
+	if ctx.Value(EelTenantId) != nil {
+		labels["tenant"] = ToFlatString(ctx.Value(EelTenantId))
+	}
+	if ctx.LogValue(HandlerKey) != nil {
+		labels["handler"] = ToFlatString(ctx.LogValue(HandlerKey))
+	}
+	if ctx.LogValue(TopicKey) != nil {
+		labels["topic"] = ToFlatString(ctx.LogValue(TopicKey))
+	}
+	for k, v := range attrs {
+		switch k {
+		case HTTPURLKey:
+			// unbounded cardinality
+		case HTTPHostKey:
+			labels["host"] = v
+		default:
+			labels[prometheusName(k)] = v
+		}
+	}
+	return prometheusLabelString(labels)
+}
+
+func prometheusLabelString(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"=\""+prometheusEscape(labels[k])+"\"")
+	}
+	return strings.Join(pairs, ",")
+}
+
+func prometheusName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func prometheusEscape(val string) string {
+	return strings.NewReplacer("\\", "\\\\", "\"", "\\\"", "\n", "\\n").Replace(val)
+}
+
+func prometheusValue(val float64) string {
+	return strconv.FormatFloat(val, 'g', -1, 64)
+}
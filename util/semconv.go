@@ -12,6 +12,9 @@ const (
 	MessageProcessDuration  = "message.process.duration"
 	MessageResponseDuration = "message.response.duration"
 	MessageLatency          = "message.message.latency"
+	MessageIn               = "message.in"
+	MessageOut              = "message.out"
+	MessageError            = "message.error"
 
 	// span names
 	HTTPHandle  = "http.handle"
@@ -28,4 +31,5 @@ const (
 
 	TopicKey   = "topic"
 	HandlerKey = "handler"
+	TenantKey  = "tenant"
 )